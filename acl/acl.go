@@ -0,0 +1,209 @@
+package acl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultUser is used by connections which didn't call AUTH with username
+const DefaultUser = "default"
+
+// ACL stores all users and the denial log
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*User
+	log   *Log
+	// isCommand validates command names in rules
+	isCommand func(string) bool
+}
+
+// MakeACL creates ACL with the default user, which has all permissions
+// requirePass 不为空时 default 用户需要使用该密码认证
+func MakeACL(isCommand func(string) bool, requirePass string, logMaxLen int) *ACL {
+	acl := &ACL{
+		users:     make(map[string]*User),
+		log:       makeLog(logMaxLen),
+		isCommand: isCommand,
+	}
+	acl.users[DefaultUser] = makeDefaultUser(requirePass)
+	return acl
+}
+
+func makeDefaultUser(requirePass string) *User {
+	user := makeUser(DefaultUser)
+	rules := []string{"on", "allkeys", "allchannels", "allcommands"}
+	if requirePass == "" {
+		rules = append(rules, "nopass")
+	} else {
+		rules = append(rules, ">"+requirePass)
+	}
+	for _, rule := range rules {
+		_ = user.applyRule(rule, nil)
+	}
+	return user
+}
+
+// Log returns the denial log
+func (acl *ACL) Log() *Log {
+	return acl.log
+}
+
+// GetUser returns user by name
+func (acl *ACL) GetUser(name string) (*User, bool) {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	user, ok := acl.users[name]
+	return user, ok
+}
+
+// SetUser creates or modifies user by rules, no rule will take effect if any of them is invalid
+func (acl *ACL) SetUser(name string, rules []string) error {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	var user *User
+	if old, ok := acl.users[name]; ok {
+		user = old.clone()
+	} else {
+		user = makeUser(name)
+	}
+	for _, rule := range rules {
+		if err := user.applyRule(rule, acl.isCommand); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", rule, err.Error())
+		}
+	}
+	acl.users[name] = user
+	return nil
+}
+
+// DelUsers removes users, returns the number of removed users
+func (acl *ACL) DelUsers(names []string) (int, error) {
+	for _, name := range names {
+		if name == DefaultUser {
+			return 0, errors.New("The 'default' user cannot be removed")
+		}
+	}
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	count := 0
+	for _, name := range names {
+		if _, ok := acl.users[name]; ok {
+			delete(acl.users, name)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Users returns sorted user names
+func (acl *ACL) Users() []string {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	names := make([]string, 0, len(acl.users))
+	for name := range acl.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List returns descriptions of all users, sorted by name
+func (acl *ACL) List() []string {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	names := make([]string, 0, len(acl.users))
+	for name := range acl.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]string, len(names))
+	for i, name := range names {
+		result[i] = acl.users[name].Describe()
+	}
+	return result
+}
+
+// Authenticate validates username and password
+func (acl *ACL) Authenticate(name string, password string) bool {
+	user, ok := acl.GetUser(name)
+	if !ok {
+		return false
+	}
+	return user.CheckPassword(password)
+}
+
+// LoadFile replaces all users with users in aclfile
+// 文件中每行一个用户: user <name> <rules...>, 文件不存在时保持当前用户不变
+func (acl *ACL) LoadFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "user" {
+			return fmt.Errorf("%s:%d: line should start with user keyword", filename, lineNum)
+		}
+		user := makeUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := user.applyRule(rule, acl.isCommand); err != nil {
+				return fmt.Errorf("%s:%d: %s. Error in user declaration '%s'", filename, lineNum, err.Error(), fields[1])
+			}
+		}
+		users[user.Name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if _, ok := users[DefaultUser]; !ok {
+		// 文件中没有 default 用户时保留当前的 default 用户
+		acl.mu.RLock()
+		users[DefaultUser] = acl.users[DefaultUser]
+		acl.mu.RUnlock()
+	}
+
+	acl.mu.Lock()
+	acl.users = users
+	acl.mu.Unlock()
+	return nil
+}
+
+// SaveFile writes all users into aclfile, the file is replaced atomically
+func (acl *ACL) SaveFile(filename string) error {
+	tmpFilename := filename + ".tmp"
+	file, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, line := range acl.List() {
+		_, _ = writer.WriteString(line + "\n")
+	}
+	if err := writer.Flush(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
@@ -0,0 +1,112 @@
+package acl
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogMaxLen = 128
+	// 相同的拒绝在该时间内合并为一条记录
+	logGroupInterval = 60 * time.Second
+)
+
+// LogEntry records a denied command or a failed authentication
+type LogEntry struct {
+	Count      int
+	Reason     string // command, key, channel or auth
+	Context    string // toplevel or multi
+	Object     string // command name, key, channel or AUTH
+	Username   string
+	ClientInfo string
+	EntryID    int64
+	Created    time.Time
+	Updated    time.Time
+}
+
+// Log is a ring buffer of recent ACL denials
+type Log struct {
+	mu      sync.Mutex
+	entries []*LogEntry
+	// 下一条记录写入的位置
+	head   int
+	size   int
+	nextID int64
+}
+
+// makeLog creates a log holding at most maxLen entries
+func makeLog(maxLen int) *Log {
+	if maxLen <= 0 {
+		maxLen = defaultLogMaxLen
+	}
+	return &Log{
+		entries: make([]*LogEntry, maxLen),
+	}
+}
+
+// Add records a denial, it will be merged into the same recent denial
+func (l *Log) Add(reason, context, object, username, clientInfo string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for i := 0; i < l.size; i++ {
+		entry := l.at(i)
+		if entry.Reason == reason && entry.Context == context && entry.Object == object &&
+			entry.Username == username && entry.ClientInfo == clientInfo &&
+			now.Sub(entry.Updated) < logGroupInterval {
+			entry.Count++
+			entry.Updated = now
+			return
+		}
+	}
+	l.entries[l.head] = &LogEntry{
+		Count:      1,
+		Reason:     reason,
+		Context:    context,
+		Object:     object,
+		Username:   username,
+		ClientInfo: clientInfo,
+		EntryID:    l.nextID,
+		Created:    now,
+		Updated:    now,
+	}
+	l.nextID++
+	l.head = (l.head + 1) % len(l.entries)
+	if l.size < len(l.entries) {
+		l.size++
+	}
+}
+
+// at returns the i-th newest entry
+func (l *Log) at(i int) *LogEntry {
+	idx := (l.head - 1 - i + len(l.entries)) % len(l.entries)
+	return l.entries[idx]
+}
+
+// Entries returns copies of at most count newest entries, count < 0 means all
+func (l *Log) Entries(count int) []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if count < 0 || count > l.size {
+		count = l.size
+	}
+	result := make([]LogEntry, count)
+	for i := 0; i < count; i++ {
+		result[i] = *l.at(i)
+	}
+	return result
+}
+
+// Reset clears all entries
+func (l *Log) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := range l.entries {
+		l.entries[i] = nil
+	}
+	l.head = 0
+	l.size = 0
+}
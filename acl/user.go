@@ -0,0 +1,349 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"redisgo/lib/wildcard"
+	"sort"
+	"strings"
+)
+
+// Categories lists all command categories, @all matches every command
+var Categories = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string",
+	"pubsub", "admin", "fast", "slow", "dangerous", "connection", "transaction",
+}
+
+// IsCategory tells whether the given name is a known category
+func IsCategory(name string) bool {
+	if name == "all" {
+		return true
+	}
+	for _, cat := range Categories {
+		if cat == name {
+			return true
+		}
+	}
+	return false
+}
+
+var errSyntax = errors.New("Syntax error")
+
+// pattern is a compiled glob pattern for keys or channels
+type pattern struct {
+	raw      string
+	compiled *wildcard.Pattern
+}
+
+// User describes permissions of an ACL user
+// 用户创建后不会被修改, SETUSER 会生成新的 User 替换旧的
+type User struct {
+	Name    string
+	enabled bool
+	nopass  bool
+	// sha256 hex of passwords
+	passwords map[string]struct{}
+	// 按顺序记录的命令规则, 如 +get, -@write, 后面的规则覆盖前面的
+	cmdRules []string
+	keys     []pattern
+	channels []pattern
+}
+
+// makeUser creates a user without any permission
+func makeUser(name string) *User {
+	return &User{
+		Name:      name,
+		passwords: make(map[string]struct{}),
+	}
+}
+
+// clone copies the user so rules can be applied without affecting the original one
+func (u *User) clone() *User {
+	cp := &User{
+		Name:      u.Name,
+		enabled:   u.enabled,
+		nopass:    u.nopass,
+		passwords: make(map[string]struct{}, len(u.passwords)),
+		cmdRules:  append([]string(nil), u.cmdRules...),
+		keys:      append([]pattern(nil), u.keys...),
+		channels:  append([]pattern(nil), u.channels...),
+	}
+	for hash := range u.passwords {
+		cp.passwords[hash] = struct{}{}
+	}
+	return cp
+}
+
+// HashPassword returns sha256 hex of password
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isValidHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// applyRule changes the user according to a single ACL rule, isCommand validates command names
+func (u *User) applyRule(rule string, isCommand func(string) bool) error {
+	lower := strings.ToLower(rule)
+	switch lower {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass = true
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "allkeys":
+		u.keys = []pattern{compilePattern("*")}
+		return nil
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allchannels":
+		u.channels = []pattern{compilePattern("*")}
+		return nil
+	case "resetchannels":
+		u.channels = nil
+		return nil
+	case "allcommands":
+		u.cmdRules = []string{"+@all"}
+		return nil
+	case "nocommands":
+		u.cmdRules = nil
+		return nil
+	case "reset":
+		u.enabled = false
+		u.nopass = false
+		u.passwords = make(map[string]struct{})
+		u.cmdRules = nil
+		u.keys = nil
+		u.channels = nil
+		return nil
+	}
+	if len(rule) < 2 {
+		return errSyntax
+	}
+	body := rule[1:]
+	switch rule[0] {
+	case '>':
+		u.nopass = false
+		u.passwords[HashPassword(body)] = struct{}{}
+	case '<':
+		hash := HashPassword(body)
+		if _, ok := u.passwords[hash]; !ok {
+			return errors.New("no such password")
+		}
+		delete(u.passwords, hash)
+	case '#':
+		hash := strings.ToLower(body)
+		if !isValidHash(hash) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.nopass = false
+		u.passwords[hash] = struct{}{}
+	case '!':
+		hash := strings.ToLower(body)
+		if _, ok := u.passwords[hash]; !ok {
+			return errors.New("no such password")
+		}
+		delete(u.passwords, hash)
+	case '~':
+		u.keys = append(u.keys, compilePattern(body))
+	case '&':
+		u.channels = append(u.channels, compilePattern(body))
+	case '+', '-':
+		target := strings.ToLower(body)
+		if strings.HasPrefix(target, "@") {
+			if !IsCategory(target[1:]) {
+				return errors.New("Unknown command or category name in ACL")
+			}
+			if target == "@all" {
+				// +@all/-@all 覆盖之前所有命令规则
+				u.cmdRules = nil
+			}
+		} else if !isCommand(target) {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		if rule[0] == '-' && target == "@all" {
+			return nil // 没有任何规则即拒绝所有命令
+		}
+		u.cmdRules = append(u.cmdRules, rule[:1]+target)
+	default:
+		return errSyntax
+	}
+	return nil
+}
+
+func compilePattern(raw string) pattern {
+	return pattern{
+		raw:      raw,
+		compiled: wildcard.CompilePattern(raw),
+	}
+}
+
+// IsEnabled returns whether the user is allowed to authenticate
+func (u *User) IsEnabled() bool {
+	return u.enabled
+}
+
+// NoPass returns whether the user can authenticate with any password
+func (u *User) NoPass() bool {
+	return u.nopass
+}
+
+// CheckPassword validates password of user
+func (u *User) CheckPassword(password string) bool {
+	if !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+	_, ok := u.passwords[HashPassword(password)]
+	return ok
+}
+
+// CanExecute tells whether the user can execute command with the given categories
+// 规则从前往后匹配, 最后一条匹配的规则决定结果, 没有匹配则拒绝
+func (u *User) CanExecute(cmdName string, categories []string) bool {
+	allowed := false
+	for _, rule := range u.cmdRules {
+		target := rule[1:]
+		matched := false
+		if strings.HasPrefix(target, "@") {
+			cat := target[1:]
+			if cat == "all" {
+				matched = true
+			} else {
+				for _, c := range categories {
+					if c == cat {
+						matched = true
+						break
+					}
+				}
+			}
+		} else {
+			matched = target == cmdName
+		}
+		if matched {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+// CanAccessKey tells whether the key matches one of key patterns of the user
+func (u *User) CanAccessKey(key string) bool {
+	for _, p := range u.keys {
+		if p.compiled.IsMatch(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessChannel tells whether the channel matches one of channel patterns of the user
+func (u *User) CanAccessChannel(channel string) bool {
+	for _, p := range u.channels {
+		if p.compiled.IsMatch(channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessPattern tells whether the user can subscribe the pattern by PSUBSCRIBE
+// 模式本身必须是允许的频道模式之一, 除非允许所有频道
+func (u *User) CanAccessPattern(channelPattern string) bool {
+	for _, p := range u.channels {
+		if p.raw == "*" || p.raw == channelPattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Flags returns flags shown in ACL GETUSER
+func (u *User) Flags() []string {
+	flags := make([]string, 0, 2)
+	if u.enabled {
+		flags = append(flags, "on")
+	} else {
+		flags = append(flags, "off")
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// PasswordHashes returns sorted password hashes of the user
+func (u *User) PasswordHashes() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for hash := range u.passwords {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// DescribeCommands returns command rules, e.g. "+@all -flushdb"
+func (u *User) DescribeCommands() string {
+	if len(u.cmdRules) == 0 {
+		return "-@all"
+	}
+	return strings.Join(u.cmdRules, " ")
+}
+
+// DescribeKeys returns key patterns, e.g. "~user:* ~order:*"
+func (u *User) DescribeKeys() string {
+	return describePatterns("~", u.keys)
+}
+
+// DescribeChannels returns channel patterns, e.g. "&news.*"
+func (u *User) DescribeChannels() string {
+	return describePatterns("&", u.channels)
+}
+
+func describePatterns(prefix string, patterns []pattern) string {
+	parts := make([]string, len(patterns))
+	for i, p := range patterns {
+		parts[i] = prefix + p.raw
+	}
+	return strings.Join(parts, " ")
+}
+
+// Describe returns the user in the format used by ACL LIST and aclfile
+func (u *User) Describe() string {
+	parts := []string{"user", u.Name}
+	parts = append(parts, u.Flags()...)
+	for _, hash := range u.PasswordHashes() {
+		parts = append(parts, "#"+hash)
+	}
+	if keys := u.DescribeKeys(); keys != "" {
+		parts = append(parts, keys)
+	} else {
+		parts = append(parts, "resetkeys")
+	}
+	if channels := u.DescribeChannels(); channels != "" {
+		parts = append(parts, channels)
+	} else {
+		parts = append(parts, "resetchannels")
+	}
+	parts = append(parts, u.DescribeCommands())
+	return strings.Join(parts, " ")
+}
@@ -1,119 +1,358 @@
 package aof
 
 import (
-	"io"
+	"fmt"
 	"os"
+	"path/filepath"
 	"redisgo/config"
 	"redisgo/interface/database"
 	"redisgo/lib/logger"
+	"redisgo/lib/sync/atomic"
 	"redisgo/lib/utils"
 	"redisgo/redis/connection"
-	"redisgo/redis/parser"
 	"redisgo/redis/reply"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type CmdLine = [][]byte
 
 const aofBufferSize = 1 << 16
 
+const (
+	// FsyncAlways do fsync for every command, client receives reply after data persisted
+	FsyncAlways = "always"
+	// FsyncEverySec do fsync every second
+	FsyncEverySec = "everysec"
+	// FsyncNo lets operating system decides when to fsync
+	FsyncNo = "no"
+)
+
+// 一个payload中的多条命令连续写入, 事务不会被其他命令打断
 type payload struct {
-	cmdLine CmdLine
-	dbIndex int
+	cmdLines []CmdLine
+	dbIndex  int
 }
 
 // AofHandler receives msgs from channel and write to AOF file
 type AofHandler struct {
 	database    database.Database
-	aofFile     *os.File
+	// tmpDBMaker creates an empty database without aof, used by rewrite
+	tmpDBMaker  func() database.DBEngine
+	// aofDir stores base, incr and manifest files
+	aofDir      string
+	// aofFilename is the prefix of files in aofDir, such as appendonly.aof
 	aofFilename string
+	manifest    *manifest
+	// aofFile is the incr file being written
+	aofFile     *os.File
 	currentDB   int
 	aofChan     chan *payload
+	// aofFinished is closed after handleAof drained aofChan
+	aofFinished chan struct{}
+	closed      atomic.Boolean
+	aofFsync    string
+	// 写入文件时持有; 切换文件和更新 manifest 时持有以暂停写入
+	pausingAof sync.Mutex
+	rewriting  atomic.Boolean
+	// manifest 中所有文件的总大小和上次重写后的大小, 用于触发自动重写
+	aofSize     int64
+	aofBaseSize int64
 }
 
 // NewAOFHandler creates a new aof.AofHandler
-func NewAOFHandler(database database.Database) (*AofHandler, error) {
+func NewAOFHandler(database database.Database, tmpDBMaker func() database.DBEngine) (*AofHandler, error) {
 	handler := &AofHandler{}
-	handler.aofFilename =  config.Properties.AppendFilename
+	handler.aofDir = config.Properties.AppendDirname
+	handler.aofFilename = filepath.Base(config.Properties.AppendFilename)
 	handler.database = database
+	handler.tmpDBMaker = tmpDBMaker
+	handler.aofFsync = strings.ToLower(config.Properties.AppendFsync)
+	if handler.aofFsync != FsyncAlways && handler.aofFsync != FsyncNo {
+		handler.aofFsync = FsyncEverySec
+	}
+	if err := os.MkdirAll(handler.aofDir, 0755); err != nil {
+		return nil, err
+	}
 	//Load
-	handler.LoadAof()
-	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
+	if err := handler.LoadAof(); err != nil {
 		return nil, err
 	}
-	handler.aofFile = aofFile
+	if err := handler.openIncrFile(); err != nil {
+		return nil, err
+	}
+	handler.aofSize = handler.filesSize(handler.manifest.files())
+	handler.aofBaseSize = handler.aofSize
 	handler.aofChan = make(chan *payload, aofBufferSize)
+	handler.aofFinished = make(chan struct{})
 	go func() {
 		handler.handleAof()
 	}()
+	if handler.aofFsync == FsyncEverySec {
+		handler.fsyncEverySecond()
+	}
 	return handler, nil
 }
 
-// AddAof sends command to aof goroutine through channel
-func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
-	if config.Properties.AppendOnly && handler.aofChan != nil {
-		handler.aofChan <- &payload{
-			cmdLine: cmdLine,
-			dbIndex: dbIndex,
+func (handler *AofHandler) path(info *aofInfo) string {
+	return filepath.Join(handler.aofDir, info.name)
+}
+
+// filesSize returns total size of the given files
+func (handler *AofHandler) filesSize(files []*aofInfo) int64 {
+	var size int64
+	for _, info := range files {
+		if stat, err := os.Stat(handler.path(info)); err == nil {
+			size += stat.Size()
 		}
 	}
+	return size
+}
+
+// openIncrFile continues writing the last incr file, creates one if there is no incr file
+func (handler *AofHandler) openIncrFile() error {
+	m := handler.manifest
+	if len(m.incrs) == 0 {
+		return handler.switchIncrFile()
+	}
+	last := m.incrs[len(m.incrs)-1]
+	aofFile, err := os.OpenFile(handler.path(last), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	handler.aofFile = aofFile
+	// 文件末尾选择的DB未知, 下次写入时总是先写入 SELECT
+	handler.currentDB = -1
+	return nil
+}
+
+// switchIncrFile creates a new incr file and writes following commands into it, caller should hold pausingAof
+func (handler *AofHandler) switchIncrFile() error {
+	m := handler.manifest
+	info := &aofInfo{
+		name:     incrFileName(handler.aofFilename, m.curIncrSeq+1),
+		seq:      m.curIncrSeq + 1,
+		fileType: aofTypeIncr,
+	}
+	aofFile, err := os.OpenFile(handler.path(info), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	next := *m
+	next.incrs = append(append([]*aofInfo(nil), m.incrs...), info)
+	next.curIncrSeq = info.seq
+	if err := persistManifest(handler.aofDir, handler.aofFilename, &next); err != nil {
+		_ = aofFile.Close()
+		_ = os.Remove(handler.path(info))
+		return err
+	}
+	if handler.aofFile != nil {
+		_ = handler.aofFile.Close()
+	}
+	handler.manifest = &next
+	handler.aofFile = aofFile
+	// 每个文件由独立的客户端加载, 从 DB 0 开始
+	handler.currentDB = 0
+	return nil
+}
+
+// AddAof writes commands into aof file
+// appendfsync 为 always 时同步写入并 fsync 后才返回, 否则通过channel交给 handleAof 异步写入
+func (handler *AofHandler) AddAof(dbIndex int, cmdLines ...CmdLine) {
+	if !config.Properties.AppendOnly || handler.aofChan == nil || len(cmdLines) == 0 || handler.closed.Get() {
+		return
+	}
+	p := &payload{
+		cmdLines: cmdLines,
+		dbIndex:  dbIndex,
+	}
+	if handler.aofFsync == FsyncAlways {
+		handler.writeAof(p)
+		return
+	}
+	handler.aofChan <- p
 }
 
 // handlerAof listen aof channel and write into file
 func (handler *AofHandler) handleAof() {
-	handler.currentDB = 0
 	for p := range handler.aofChan {
-		if p.dbIndex != handler.currentDB {
-			args := utils.ToCmdLine("select", strconv.Itoa(p.dbIndex)) // 将命令转换成resp协议的byte字节组
-			data := reply.MakeMultiBulkReply(args).ToBytes()
-			_, err := handler.aofFile.Write(data)
-			if err != nil {
-				logger.Warn(err)
-				continue
-			}
-			handler.currentDB = p.dbIndex
+		if p == nil {
+			// Close 发送的结束标记, 之前的命令都已写入
+			break
 		}
+		handler.writeAof(p)
+	}
+	close(handler.aofFinished)
+}
 
-		data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
-		_, err := handler.aofFile.Write(data)
-		if err != nil {
-			logger.Warn(err)
+// fsyncEverySecond flushes aof file to disk every second in background
+func (handler *AofHandler) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				handler.pausingAof.Lock()
+				if err := handler.aofFile.Sync(); err != nil {
+					logger.Error("fsync failed: " + err.Error())
+				}
+				handler.pausingAof.Unlock()
+			case <-handler.aofFinished:
+				ticker.Stop()
+				return
+			}
 		}
+	}()
+}
+
+// writeAof writes payload into aof file, auto rewrite will be started if necessary
+func (handler *AofHandler) writeAof(p *payload) {
+	if handler.doWriteAof(p) {
+		// 在独立协程中开始, startRewrite 需要等待本次写入释放锁
+		go func() {
+			if err := handler.BGRewrite(); err != nil && err != ErrRewriteInProgress {
+				logger.Error("auto aof rewrite failed: " + err.Error())
+			}
+		}()
 	}
 }
 
-// LoadAof reads aof files
-func (handler *AofHandler) LoadAof() {
-	file, err := os.Open(handler.aofFilename)
+// doWriteAof returns whether auto rewrite should be started
+func (handler *AofHandler) doWriteAof(p *payload) bool {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+
+	var data []byte
+	if p.dbIndex != handler.currentDB {
+		args := utils.ToCmdLine("select", strconv.Itoa(p.dbIndex)) // 将命令转换成resp协议的byte字节组
+		data = append(data, reply.MakeMultiBulkReply(args).ToBytes()...)
+	}
+	for _, cmdLine := range p.cmdLines {
+		data = append(data, reply.MakeMultiBulkReply(cmdLine).ToBytes()...)
+	}
+	n, err := handler.aofFile.Write(data)
+	handler.aofSize += int64(n)
 	if err != nil {
-		logger.Error(err)
-		return 
+		logger.Warn(err)
+		return false
 	}
-	defer file.Close()
-	payloads := parser.ParseStream(file)
-	// only used for save dbIndex
-	fakeConn := &connection.Connection{}
-	logger.Info("LoadAof...")
-	for p := range payloads {
-		if p.Err != nil {
-			if p.Err == io.EOF {
-				break
-			}
-			logger.Error(p.Err)
-			continue
-		}
-		if p.Data == nil {
-			logger.Error("empty payload")
-			continue
+	if handler.aofFsync == FsyncAlways {
+		if err := handler.aofFile.Sync(); err != nil {
+			logger.Error("fsync failed: " + err.Error())
 		}
-		data, ok := p.Data.(*reply.MultiBulkReply)
-		if !ok {
-			logger.Error("multibulk needed")
-			continue
+	}
+	handler.currentDB = p.dbIndex
+	return handler.needRewrite()
+}
+
+// Close waits for pending commands written and flushes aof file to disk
+func (handler *AofHandler) Close() {
+	if handler.aofFile == nil || handler.closed.Get() {
+		return
+	}
+	handler.closed.Set(true)
+	// 不关闭 aofChan, 避免仍在执行的命令向已关闭的channel发送
+	handler.aofChan <- nil
+	<-handler.aofFinished
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	if err := handler.aofFile.Sync(); err != nil {
+		logger.Error("fsync failed: " + err.Error())
+	}
+	_ = handler.aofFile.Close()
+}
+
+// needRewrite checks auto-aof-rewrite-percentage and auto-aof-rewrite-min-size
+func (handler *AofHandler) needRewrite() bool {
+	percentage := config.Properties.AutoAofRewritePercentage
+	if percentage <= 0 || handler.rewriting.Get() {
+		return false
+	}
+	if handler.aofSize < int64(config.Properties.AutoAofRewriteMinSize) {
+		return false
+	}
+	base := handler.aofBaseSize
+	if base == 0 {
+		base = 1
+	}
+	growth := (handler.aofSize - base) * 100 / base
+	return growth >= int64(percentage)
+}
+
+// LoadAof loads files listed in manifest, legacy single aof file is loaded and moved into aofDir as base file
+// 只有最后一个文件末尾命令不完整时, 若开启 aof-load-truncated 则截断到最后一条完整命令, 其余情况返回错误
+func (handler *AofHandler) LoadAof() error {
+	m, err := loadManifest(filepath.Join(handler.aofDir, manifestName(handler.aofFilename)))
+	if err == nil {
+		handler.manifest = m
+		files := m.files()
+		for i, info := range files {
+			if err := handler.loadFile(handler.path(info), i == len(files)-1); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	handler.manifest = &manifest{}
+	legacy := config.Properties.AppendFilename
+	if _, err := os.Stat(legacy); err != nil {
+		return nil
+	}
+	if err := handler.loadFile(legacy, true); err != nil {
+		return err
+	}
+	// 旧格式升级: 先写入 manifest 再移动文件, 中途崩溃时因找不到 base 文件而拒绝启动, 不会丢失数据
+	m = &manifest{
+		base: &aofInfo{
+			name:     baseFileName(handler.aofFilename, 1, false),
+			seq:      1,
+			fileType: aofTypeBase,
+		},
+		curBaseSeq: 1,
+	}
+	if err := persistManifest(handler.aofDir, handler.aofFilename, m); err != nil {
+		return err
+	}
+	if err := os.Rename(legacy, handler.path(m.base)); err != nil {
+		return err
+	}
+	handler.manifest = m
+	logger.Info("legacy aof file " + legacy + " is moved into " + handler.aofDir)
+	return nil
+}
 
-		_ = handler.database.Exec(fakeConn, data.Args)
-	
+// loadFile replays an aof file into database, truncated tail is allowed only for the last file
+func (handler *AofHandler) loadFile(filename string, last bool) error {
+	logger.Info("LoadAof " + filename)
+	err := loadAof(filename, handler.database)
+	formatErr, ok := err.(*FormatError)
+	if !ok || !formatErr.Truncated || !last {
+		if err != nil {
+			return fmt.Errorf("load %s: %w", filename, err)
+		}
+		return nil
+	}
+	if !config.Properties.AofLoadTruncated {
+		return fmt.Errorf("%s: %v, use redisgo-check-aof --fix to repair it, or set aof-load-truncated yes", filename, err)
 	}
-}
\ No newline at end of file
+	logger.Warn(fmt.Sprintf("!!! Warning: %s: %v, truncating the AOF at offset %d", filename, err, formatErr.ValidSize))
+	return os.Truncate(filename, formatErr.ValidSize)
+}
+
+// loadAof replays commands in file into db
+func loadAof(filename string, db database.Database) error {
+	// only used for save dbIndex
+	fakeConn := &connection.Connection{}
+	fakeConn.SetAuthenticated(true)
+	// 不完整的事务只会入队, 不会被执行
+	return ReadFile(filename, func(cmdLine CmdLine) bool {
+		_ = db.Exec(fakeConn, cmdLine)
+		return true
+	})
+}
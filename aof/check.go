@@ -0,0 +1,229 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"redisgo/lib/utils"
+	"redisgo/rdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBulkLen limits the length of a single argument, same as proto-max-bulk-len of redis
+const maxBulkLen = 512 << 20
+
+// FormatError describes an invalid aof file
+type FormatError struct {
+	// Offset is the position where the invalid content found
+	Offset int64
+	// ValidSize is the size of valid prefix, content after it is incomplete or corrupted
+	ValidSize int64
+	// Truncated means the file ends with an incomplete command, usually caused by crash during writing
+	Truncated bool
+	msg       string
+}
+
+func (e *FormatError) Error() string {
+	if e.Truncated {
+		return fmt.Sprintf("unexpected end of aof file at offset %d: %s", e.Offset, e.msg)
+	}
+	return fmt.Sprintf("bad aof format at offset %d: %s", e.Offset, e.msg)
+}
+
+// cmdReader reads commands in RESP format and records the offset of bytes consumed
+type cmdReader struct {
+	reader *bufio.Reader
+	offset int64
+}
+
+var errBadFormat = errors.New("bad format")
+
+// readLine reads a line ending with CRLF, returns io.EOF only if nothing read
+func (r *cmdReader) readLine() ([]byte, error) {
+	line, err := r.reader.ReadBytes('\n')
+	r.offset += int64(len(line))
+	if err == io.EOF {
+		if len(line) == 0 {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errBadFormat
+	}
+	return line[:len(line)-2], nil
+}
+
+// readCommand reads a multi bulk command, bad format line is described by the returned message
+func (r *cmdReader) readCommand() (CmdLine, int64, string, error) {
+	lineStart := r.offset
+	line, err := r.readLine()
+	if err != nil {
+		return nil, lineStart, "invalid line", err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, lineStart, "multi bulk header expected", errBadFormat
+	}
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count <= 0 {
+		return nil, lineStart, "invalid multi bulk length", errBadFormat
+	}
+	cmdLine := make(CmdLine, 0, count)
+	for i := 0; i < count; i++ {
+		lineStart = r.offset
+		line, err = r.readLine()
+		if err != nil {
+			return nil, lineStart, "invalid line", err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, lineStart, "bulk header expected", errBadFormat
+		}
+		bulkLen, err := strconv.Atoi(string(line[1:]))
+		if err != nil || bulkLen < 0 || bulkLen > maxBulkLen {
+			return nil, lineStart, "invalid bulk length", errBadFormat
+		}
+		lineStart = r.offset
+		body := make([]byte, bulkLen+2)
+		n, err := io.ReadFull(r.reader, body)
+		r.offset += int64(n)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, lineStart, "invalid bulk", err
+		}
+		if body[bulkLen] != '\r' || body[bulkLen+1] != '\n' {
+			return nil, lineStart, "bulk should end with CRLF", errBadFormat
+		}
+		cmdLine = append(cmdLine, body[:bulkLen])
+	}
+	return cmdLine, 0, "", nil
+}
+
+// ReadCommands reads commands in aof file one by one and passes them to cb, stops if cb returns false
+// 返回 *FormatError 表示文件不完整或已损坏; 末尾没有 EXEC 的事务也视为不完整, 整个事务都不算有效内容
+func ReadCommands(reader io.Reader, cb func(cmdLine CmdLine) bool) error {
+	r := &cmdReader{reader: bufio.NewReader(reader)}
+	var validSize int64
+	multiStart := int64(-1)
+	for {
+		cmdStart := r.offset
+		cmdLine, errOffset, msg, err := r.readCommand()
+		if err == io.EOF {
+			if multiStart >= 0 {
+				return &FormatError{
+					Offset:    r.offset,
+					ValidSize: multiStart,
+					Truncated: true,
+					msg:       "MULTI without EXEC",
+				}
+			}
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF || err == errBadFormat {
+			formatErr := &FormatError{
+				Offset:    errOffset,
+				ValidSize: validSize,
+				Truncated: err == io.ErrUnexpectedEOF,
+				msg:       msg,
+			}
+			if multiStart >= 0 {
+				formatErr.ValidSize = multiStart
+			}
+			return formatErr
+		}
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(string(cmdLine[0])) {
+		case "multi":
+			multiStart = cmdStart
+		case "exec":
+			multiStart = -1
+		}
+		if multiStart < 0 {
+			validSize = r.offset
+		}
+		if !cb(cmdLine) {
+			return nil
+		}
+	}
+}
+
+// rdbMagic is the beginning of aof file with rdb preamble
+const rdbMagic = "REDIS"
+
+// ReadFile reads an aof file and passes commands to cb, stops if cb returns false
+// 文件以RDB开头时, RDB中的每个key被转换为重建它的命令, 已过期的key被跳过; RDB之后的命令从 DB 0 开始
+// 返回的 *FormatError 中的位置是相对于整个文件的
+func ReadFile(filename string, cb func(cmdLine CmdLine) bool) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var offset int64
+	head := make([]byte, len(rdbMagic))
+	n, _ := io.ReadFull(file, head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if n == len(rdbMagic) && string(head) == rdbMagic {
+		stopped := false
+		dbIndex := 0
+		now := time.Now()
+		emit := func(cmdLine CmdLine) bool {
+			stopped = !cb(cmdLine)
+			return !stopped
+		}
+		dec := rdb.NewDecoder(file)
+		err := dec.Parse(func(o *rdb.Object) bool {
+			if o.Expiration != nil && o.Expiration.Before(now) {
+				return true
+			}
+			if o.DBIndex != dbIndex {
+				dbIndex = o.DBIndex
+				if !emit(utils.ToCmdLine("select", strconv.Itoa(dbIndex))) {
+					return false
+				}
+			}
+			for _, cmdLine := range EntityToCmds(o.Key, o.Entity) {
+				if !emit(cmdLine) {
+					return false
+				}
+			}
+			if o.Expiration != nil {
+				return emit(MakeExpireCmd(o.Key, *o.Expiration))
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("bad rdb preamble: %w", err)
+		}
+		if stopped {
+			return nil
+		}
+		if dbIndex != 0 && !emit(utils.ToCmdLine("select", "0")) {
+			return nil
+		}
+		offset = dec.Offset()
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	err = ReadCommands(file, cb)
+	if formatErr, ok := err.(*FormatError); ok {
+		formatErr.Offset += offset
+		formatErr.ValidSize += offset
+	}
+	return err
+}
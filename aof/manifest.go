@@ -0,0 +1,178 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 和 redis 7 相同的多文件布局: 一个 base 文件加若干 incr 文件, 由 manifest 记录加载顺序
+// base 文件是重写生成的快照, incr 文件是之后追加的命令, 重写时切换到新的 incr 文件, 不需要复制正在写入的文件
+
+const (
+	aofTypeBase    = "b"
+	aofTypeHistory = "h"
+	aofTypeIncr    = "i"
+
+	baseFileSuffix    = ".base"
+	incrFileSuffix    = ".incr"
+	rdbFormatSuffix   = ".rdb"
+	aofFormatSuffix   = ".aof"
+	manifestSuffix    = ".manifest"
+	tmpManifestPrefix = "temp-"
+)
+
+// aofInfo describes a file in manifest
+type aofInfo struct {
+	name     string
+	seq      int64
+	fileType string
+}
+
+// manifest records files of aof in loading order
+type manifest struct {
+	base       *aofInfo
+	incrs      []*aofInfo
+	curBaseSeq int64
+	curIncrSeq int64
+}
+
+// files returns base and incr files in loading order
+func (m *manifest) files() []*aofInfo {
+	files := make([]*aofInfo, 0, len(m.incrs)+1)
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+func (m *manifest) marshal() []byte {
+	var buf bytes.Buffer
+	for _, info := range m.files() {
+		buf.WriteString(fmt.Sprintf("file %s seq %d type %s\n", info.name, info.seq, info.fileType))
+	}
+	return buf.Bytes()
+}
+
+func baseFileName(filename string, seq int64, useRDB bool) string {
+	format := aofFormatSuffix
+	if useRDB {
+		format = rdbFormatSuffix
+	}
+	return filename + "." + strconv.FormatInt(seq, 10) + baseFileSuffix + format
+}
+
+func incrFileName(filename string, seq int64) string {
+	return filename + "." + strconv.FormatInt(seq, 10) + incrFileSuffix + aofFormatSuffix
+}
+
+func manifestName(filename string) string {
+	return filename + manifestSuffix
+}
+
+// loadManifest reads manifest file, returns error satisfies os.IsNotExist if it doesn't exist
+// 每行格式为 file <name> seq <seq> type <b|h|i>, history 类型的文件被忽略
+func loadManifest(path string) (*manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	m := &manifest{}
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid aof manifest line %d: %s", lineNum, line)
+		}
+		info := &aofInfo{}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				info.name = fields[i+1]
+			case "seq":
+				info.seq, err = strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid aof manifest line %d: %s", lineNum, line)
+				}
+			case "type":
+				info.fileType = fields[i+1]
+			}
+		}
+		if info.name == "" || info.seq <= 0 || filepath.Base(info.name) != info.name {
+			return nil, fmt.Errorf("invalid aof manifest line %d: %s", lineNum, line)
+		}
+		switch info.fileType {
+		case aofTypeBase:
+			if m.base != nil {
+				return nil, errors.New("found duplicate base file information in aof manifest")
+			}
+			m.base = info
+			m.curBaseSeq = info.seq
+		case aofTypeIncr:
+			m.incrs = append(m.incrs, info)
+			if info.seq > m.curIncrSeq {
+				m.curIncrSeq = info.seq
+			}
+		case aofTypeHistory:
+		default:
+			return nil, fmt.Errorf("unknown aof file type in manifest line %d: %s", lineNum, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// persistManifest writes manifest into temp file then renames it, so the manifest is always complete
+func persistManifest(dir string, filename string, m *manifest) error {
+	tmpFile, err := os.CreateTemp(dir, tmpManifestPrefix+"*"+manifestSuffix)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	if _, err := tmpFile.Write(m.marshal()); err != nil {
+		return fail(err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpFile.Name(), filepath.Join(dir, manifestName(filename))); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	return nil
+}
+
+// ManifestFiles returns paths of aof files listed in manifest in loading order
+func ManifestFiles(manifestPath string) ([]string, error) {
+	m, err := loadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(manifestPath)
+	var paths []string
+	for _, info := range m.files() {
+		paths = append(paths, filepath.Join(dir, info.name))
+	}
+	return paths, nil
+}
@@ -0,0 +1,134 @@
+package aof
+
+import (
+	"redisgo/datastruct/dict"
+	List "redisgo/datastruct/list"
+	"redisgo/datastruct/set"
+	SortedSet "redisgo/datastruct/sortedset"
+	"redisgo/interface/database"
+	"strconv"
+	"time"
+)
+
+var pExpireAtBytes = []byte("PEXPIREAT")
+
+// MakeExpireCmd generates command line to set expiration for the given key
+// 使用绝对时间，重放AOF时不会延长key的寿命
+func MakeExpireCmd(key string, expireAt time.Time) CmdLine {
+	args := make([][]byte, 3)
+	args[0] = pExpireAtBytes
+	args[1] = []byte(key)
+	args[2] = []byte(strconv.FormatInt(expireAt.UnixMilli(), 10))
+	return args
+}
+
+// aofRewriteItemsPerCmd limits elements in a single command, large collections are split into multiple commands
+const aofRewriteItemsPerCmd = 64
+
+var (
+	setCmd   = []byte("SET")
+	rPushCmd = []byte("RPUSH")
+	hSetCmd  = []byte("HSET")
+	sAddCmd  = []byte("SADD")
+	zAddCmd  = []byte("ZADD")
+)
+
+// EntityToCmds serializes data entity into minimal commands which rebuild it
+func EntityToCmds(key string, entity *database.DataEntity) []CmdLine {
+	if entity == nil {
+		return nil
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		return []CmdLine{{setCmd, []byte(key), val}}
+	case List.List:
+		return listToCmds(key, val)
+	case dict.Dict:
+		return hashToCmds(key, val)
+	case *set.Set:
+		return setToCmds(key, val)
+	case *SortedSet.SortedSet:
+		return zSetToCmds(key, val)
+	}
+	return nil
+}
+
+// batcher collects arguments and emits a command every aofRewriteItemsPerCmd items
+type batcher struct {
+	prefix CmdLine
+	args   CmdLine
+	count  int
+	cmds   []CmdLine
+}
+
+func makeBatcher(name []byte, key string) *batcher {
+	return &batcher{
+		prefix: CmdLine{name, []byte(key)},
+	}
+}
+
+func (b *batcher) add(item ...[]byte) {
+	if b.count == 0 {
+		b.args = append(CmdLine{}, b.prefix...)
+	}
+	b.args = append(b.args, item...)
+	b.count++
+	if b.count == aofRewriteItemsPerCmd {
+		b.flush()
+	}
+}
+
+func (b *batcher) flush() {
+	if b.count > 0 {
+		b.cmds = append(b.cmds, b.args)
+		b.args = nil
+		b.count = 0
+	}
+}
+
+func (b *batcher) result() []CmdLine {
+	b.flush()
+	return b.cmds
+}
+
+func listToCmds(key string, list List.List) []CmdLine {
+	b := makeBatcher(rPushCmd, key)
+	list.ForEach(func(i int, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		b.add(bytes)
+		return true
+	})
+	return b.result()
+}
+
+func hashToCmds(key string, hash dict.Dict) []CmdLine {
+	b := makeBatcher(hSetCmd, key)
+	hash.ForEach(func(field string, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		b.add([]byte(field), bytes)
+		return true
+	})
+	return b.result()
+}
+
+func setToCmds(key string, s *set.Set) []CmdLine {
+	b := makeBatcher(sAddCmd, key)
+	s.ForEach(func(member string) bool {
+		b.add([]byte(member))
+		return true
+	})
+	return b.result()
+}
+
+func zSetToCmds(key string, zset *SortedSet.SortedSet) []CmdLine {
+	b := makeBatcher(zAddCmd, key)
+	if zset.Len() == 0 {
+		return nil
+	}
+	zset.ForEachByRank(0, zset.Len(), false, func(element *SortedSet.Element) bool {
+		score := strconv.FormatFloat(element.Score, 'f', -1, 64)
+		b.add([]byte(score), []byte(element.Member))
+		return true
+	})
+	return b.result()
+}
@@ -0,0 +1,218 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"redisgo/config"
+	"redisgo/interface/database"
+	"redisgo/lib/logger"
+	"redisgo/lib/utils"
+	"redisgo/rdb"
+	"redisgo/redis/reply"
+	"strconv"
+	"time"
+)
+
+// ErrRewriteInProgress is returned when another rewrite is running
+var ErrRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+
+// rewriteCtx holds the state between start and finish of a rewrite
+type rewriteCtx struct {
+	// files 是开始重写时 manifest 中的文件, 不会再被写入, 新的 base 由它们重建
+	files   []*aofInfo
+	tmpFile *os.File
+	useRDB  bool
+}
+
+// IsRewriting tells whether a rewrite is running
+func (handler *AofHandler) IsRewriting() bool {
+	return handler.rewriting.Get()
+}
+
+// Rewrite compacts aof files, it blocks until finished but doesn't block writing commands
+// 1. 暂停写入, 切换到新的 incr 文件, 之前的文件不再变化
+// 2. 在临时DB中重放之前的文件, 写入新的 base 文件, 开启 aof-use-rdb-preamble 时使用RDB格式
+// 3. 暂停写入, 更新 manifest 为新的 base 和重写期间的 incr 文件, 删除旧文件
+func (handler *AofHandler) Rewrite() error {
+	ctx, err := handler.startRewrite()
+	if err != nil {
+		return err
+	}
+	err = handler.doRewrite(ctx)
+	if err != nil {
+		handler.abortRewrite(ctx)
+		return err
+	}
+	return handler.finishRewrite(ctx)
+}
+
+// BGRewrite starts rewrite and returns immediately, returns ErrRewriteInProgress if another rewrite is running
+func (handler *AofHandler) BGRewrite() error {
+	ctx, err := handler.startRewrite()
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := handler.doRewrite(ctx); err != nil {
+			handler.abortRewrite(ctx)
+			logger.Error("aof rewrite failed: " + err.Error())
+			return
+		}
+		if err := handler.finishRewrite(ctx); err != nil {
+			logger.Error("aof rewrite failed: " + err.Error())
+		}
+	}()
+	return nil
+}
+
+func (handler *AofHandler) startRewrite() (*rewriteCtx, error) {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+
+	if handler.rewriting.Get() {
+		return nil, ErrRewriteInProgress
+	}
+	if err := handler.aofFile.Sync(); err != nil {
+		return nil, err
+	}
+	files := handler.manifest.files()
+	tmpFile, err := os.CreateTemp(handler.aofDir, "temp-rewriteaof-*.aof")
+	if err != nil {
+		return nil, err
+	}
+	if err := handler.switchIncrFile(); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return nil, err
+	}
+	handler.rewriting.Set(true)
+	return &rewriteCtx{
+		files:   files,
+		tmpFile: tmpFile,
+		useRDB:  config.Properties.AofUseRdbPreamble,
+	}, nil
+}
+
+func (handler *AofHandler) doRewrite(ctx *rewriteCtx) error {
+	start := time.Now()
+	tmpDB := handler.tmpDBMaker()
+	for _, info := range ctx.files {
+		if err := loadAof(handler.path(info), tmpDB); err != nil {
+			return err
+		}
+	}
+
+	if ctx.useRDB {
+		enc := rdb.NewEncoder(ctx.tmpFile)
+		if err := enc.WriteHeader(); err != nil {
+			return err
+		}
+		if err := enc.WriteAux("aof-base", "1"); err != nil {
+			return err
+		}
+		if err := enc.WriteDBEngine(tmpDB, config.Properties.Databases); err != nil {
+			return err
+		}
+		if err := enc.WriteEnd(); err != nil {
+			return err
+		}
+	} else {
+		writer := bufio.NewWriter(ctx.tmpFile)
+		for i := 0; i < config.Properties.Databases; i++ {
+			if err := writeDB(writer, tmpDB, i); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := ctx.tmpFile.Sync(); err != nil {
+		return err
+	}
+	logger.Info("aof rewrite snapshot finished in " + time.Since(start).String())
+	return nil
+}
+
+// writeDB writes all keys of the given db, empty db is skipped
+func writeDB(writer *bufio.Writer, tmpDB database.DBEngine, dbIndex int) error {
+	var err error
+	selected := false
+	tmpDB.ForEach(dbIndex, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		if !selected {
+			selectCmd := utils.ToCmdLine("select", strconv.Itoa(dbIndex))
+			if _, err = writer.Write(reply.MakeMultiBulkReply(selectCmd).ToBytes()); err != nil {
+				return false
+			}
+			selected = true
+		}
+		for _, cmdLine := range EntityToCmds(key, entity) {
+			if _, err = writer.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+				return false
+			}
+		}
+		if expiration != nil {
+			cmdLine := MakeExpireCmd(key, *expiration)
+			if _, err = writer.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	return err
+}
+
+// abortRewrite removes temp file, the new incr file is kept since it has been added into manifest
+func (handler *AofHandler) abortRewrite(ctx *rewriteCtx) {
+	_ = ctx.tmpFile.Close()
+	_ = os.Remove(ctx.tmpFile.Name())
+	handler.rewriting.Set(false)
+}
+
+func (handler *AofHandler) finishRewrite(ctx *rewriteCtx) error {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	defer handler.rewriting.Set(false)
+
+	_ = ctx.tmpFile.Close()
+	m := handler.manifest
+	base := &aofInfo{
+		name:     baseFileName(handler.aofFilename, m.curBaseSeq+1, ctx.useRDB),
+		seq:      m.curBaseSeq + 1,
+		fileType: aofTypeBase,
+	}
+	if err := os.Rename(ctx.tmpFile.Name(), handler.path(base)); err != nil {
+		_ = os.Remove(ctx.tmpFile.Name())
+		return err
+	}
+	// 开始重写后新建的 incr 文件保留, 之前的文件已包含在新的 base 中
+	replaced := make(map[string]struct{}, len(ctx.files))
+	for _, info := range ctx.files {
+		replaced[info.name] = struct{}{}
+	}
+	next := &manifest{
+		base:       base,
+		curBaseSeq: base.seq,
+		curIncrSeq: m.curIncrSeq,
+	}
+	for _, info := range m.incrs {
+		if _, ok := replaced[info.name]; !ok {
+			next.incrs = append(next.incrs, info)
+		}
+	}
+	if err := persistManifest(handler.aofDir, handler.aofFilename, next); err != nil {
+		_ = os.Remove(handler.path(base))
+		return err
+	}
+	handler.manifest = next
+	for _, info := range ctx.files {
+		if err := os.Remove(handler.path(info)); err != nil {
+			logger.Warn("remove history aof file failed: " + err.Error())
+		}
+	}
+	handler.aofSize = handler.filesSize(next.files())
+	handler.aofBaseSize = handler.aofSize
+	logger.Info("aof rewrite finished")
+	return nil
+}
@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"crypto/subtle"
+	"redisgo/config"
+	"redisgo/interface/redis"
+	"redisgo/redis/reply"
+)
+
+// 内部命令可以修改哈希环, 绕过路由或者执行事务, 只允许集群中的其它节点调用:
+// 连接池中的连接建立后先发送 _peerauth <secret>, 通过后该连接才能执行内部命令
+
+const relayPeerAuth = "_peerauth"
+
+var notPeerErrReply = reply.MakeErrReply("NOPERM Internal commands are only allowed for cluster peers")
+
+var noSecretErrReply = reply.MakeErrReply("NOPERM cluster-secret is not configured, internal commands are disabled")
+
+// internalCmds are commands sent between cluster nodes
+var internalCmds = map[string]struct{}{
+	relayTryExec:    {},
+	relayImportExec: {},
+	relaySetRing:    {},
+	relayMigrate:    {},
+	relayRingDone:   {},
+	relayTryTx:      {},
+	relayCommitTx:   {},
+	relayRollbackTx: {},
+	relayPublish:    {},
+}
+
+func isInternalCmd(cmdName string) bool {
+	_, ok := internalCmds[cmdName]
+	return ok
+}
+
+// execPeerAuth executes _peerauth <secret>, marks the connection as cluster peer
+// 节点之间使用独立的密钥认证, 不依赖 requirepass 和 ACL 用户, 加载 aclfile 后仍然可以互相转发命令
+// 没有配置密钥时拒绝所有节点, 不能只凭 IP 判断连接是否来自集群节点
+func execPeerAuth(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply(relayPeerAuth)
+	}
+	secret := config.Properties.ClusterSecret
+	if secret == "" {
+		return noSecretErrReply
+	}
+	if subtle.ConstantTimeCompare(args[1], []byte(secret)) != 1 {
+		return reply.MakeErrReply("WRONGPASS invalid cluster secret")
+	}
+	c.SetClusterPeer(true)
+	return reply.MakeOkReply()
+}
@@ -3,6 +3,8 @@ package cluster
 import (
 	"context"
 	"errors"
+	"redisgo/config"
+	"redisgo/lib/utils"
 	"redisgo/redis/client"
 
 	pool "github.com/jolestar/go-commons-pool/v2"
@@ -19,6 +21,13 @@ func (f *connectionFactory) MakeObject(ctx context.Context) (*pool.PooledObject,
 		return nil, err
 	}
 	c.Start()
+	// 通过节点认证后才能执行内部命令, 并且不再检查转发的命令的权限, 没有配置密钥时只能转发普通命令
+	if secret := config.Properties.ClusterSecret; secret != "" {
+		if err := c.Handshake(utils.ToCmdLine(relayPeerAuth, secret)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
 	cc := pool.NewPooledObject(c) //新建客户端对象，放入池中
 	return cc, nil
 }
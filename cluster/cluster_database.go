@@ -5,13 +5,13 @@ import (
 	"fmt"
 	"redisgo/config"
 	database2 "redisgo/database"
-	"redisgo/interface/database"
 	"redisgo/interface/redis"
 	"redisgo/lib/consistenthash"
 	"redisgo/lib/logger"
 	"redisgo/redis/reply"
 	"runtime/debug"
 	"strings"
+	"sync"
 
 	pool "github.com/jolestar/go-commons-pool/v2"
 )
@@ -19,10 +19,28 @@ import (
 
 type ClusterDatabase struct {
 	self string
+	// 保护 nodes, peerPicker, peerConnection 和迁移状态, 节点加入或离开时会修改
+	topologyMu sync.RWMutex
 	nodes []string
 	peerPicker *consistenthash.NodeMap
 	peerConnection map[string]*pool.ObjectPool // 连接池
-	db database.Database
+	// 节点权重, 没有记录的节点权重为1
+	weights map[string]int
+	hashFunc consistenthash.HashFunc
+	// 重新分片期间的旧哈希环, nil 表示没有正在进行的迁移
+	prevNodes []string
+	prevPicker *consistenthash.NodeMap
+	migration *migrationStatus
+	// 发起重新分片的节点持有, 同一时间只允许一个重新分片
+	reshardMu sync.Mutex
+	// 本节点作为参与者执行的跨节点事务, 以及已经回滚的事务
+	txMu sync.Mutex
+	transactions map[string]*participantTx
+	abortedTxs map[string]struct{}
+	txSeq uint64
+	db *database2.StandaloneDatabase
+	// 开启 cluster-enabled 时使用哈希槽模式, 不属于本节点的 key 返回重定向而不是转发
+	slots *slotTable
 }
 
 // CmdFunc represents the handler of a redis command
@@ -30,11 +48,36 @@ type CmdFunc func(cluster *ClusterDatabase, c redis.Connection, cmdArgs [][]byte
 
 // 初始化一个cluster
 func MakeClusterDatabase() *ClusterDatabase {
+	if config.Properties.Self == "" {
+		config.Properties.Self = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port)
+	}
+	hashFunc, ok := consistenthash.GetHashFunc(config.Properties.ClusterHash)
+	if !ok {
+		panic("unknown cluster-hash " + config.Properties.ClusterHash)
+	}
+	weights := make(map[string]int)
+	for _, entry := range config.Properties.NodeWeights {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		node, weight, err := parseNodeWeight(strings.TrimSpace(entry))
+		if err != nil {
+			panic(err)
+		}
+		weights[node] = weight
+	}
+	if config.Properties.ClusterSecret == "" {
+		logger.Warn("cluster-secret is not configured, nodes refuse internal commands from each other, " +
+			"resharding, cross-node transactions and publishing to other nodes will fail")
+	}
 	cluster := &ClusterDatabase{
 		self: config.Properties.Self,
 		db: database2.NewStandaloneDataBase(),
-		peerPicker: consistenthash.NewNodeMap(nil),
 		peerConnection: make(map[string]*pool.ObjectPool),
+		weights: weights,
+		hashFunc: hashFunc,
+		transactions: make(map[string]*participantTx),
+		abortedTxs: make(map[string]struct{}),
 	}
 	nodes := make([]string, 0, len(config.Properties.Peers) + 1)
 	for _, peer := range config.Properties.Peers {
@@ -42,8 +85,11 @@ func MakeClusterDatabase() *ClusterDatabase {
 	}
 	// nodes = append(nodes, config.Properties.Peers...)
 	nodes = append(nodes, config.Properties.Self)
-	cluster.peerPicker.AddNode(nodes...) //一致性哈希选择节点
+	cluster.peerPicker = cluster.makePicker(nodes) //一致性哈希选择节点
 	cluster.nodes = nodes
+	if config.Properties.ClusterEnabled {
+		cluster.slots = makeSlotTable(cluster.self, nodes)
+	}
 	ctx := context.Background()
 	for _, peer := range config.Properties.Peers {
 		cluster.peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{
@@ -64,6 +110,40 @@ func (c *ClusterDatabase) Exec(conn redis.Connection, cmdLine [][]byte) (result
 	}()
 
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	switch cmdName {
+	case "auth":
+		return c.db.Auth(conn, cmdLine[1:])
+	case "hello":
+		return c.db.Hello(conn, cmdLine[1:], "cluster")
+	case relayPeerAuth:
+		return execPeerAuth(c, conn, cmdLine)
+	}
+	// 内部命令由其它节点发送, 不经过 ACL 检查, 其中的用户命令在入口节点已经检查过
+	if isInternalCmd(cmdName) {
+		if !conn.IsClusterPeer() {
+			return notPeerErrReply
+		}
+		cmdFunc, ok := router[cmdName]
+		if !ok {
+			return reply.MakeErrReply("ERR unknow command '" + cmdName + "', or not supported in cluster mode")
+		}
+		return cmdFunc(c, conn, cmdLine)
+	}
+	// 在入口节点检查权限, 再转发给负责的节点
+	if errReply := c.db.CheckAccess(conn, cmdLine); errReply != nil {
+		return errReply
+	}
+	if c.slots != nil {
+		return c.execWithSlots(conn, cmdName, cmdLine)
+	}
+	// 订阅模式下的命令由本地节点处理, SSUBSCRIBE 仍需检查分片频道是否属于本节点
+	if conn.SubsCount()+conn.PSubsCount()+conn.SSubsCount() > 0 && cmdName != "ssubscribe" {
+		return c.db.Exec(conn, cmdLine)
+	}
+	// 事务中的命令在 EXEC 时按节点分组执行
+	if conn.InMultiState() && cmdName != "exec" && cmdName != "discard" && cmdName != "multi" {
+		return enqueueCmd(conn, cmdName, cmdLine)
+	}
 	cmdFunc, ok := router[cmdName]
 	if !ok {
 		return reply.MakeErrReply("ERR unknow command '" + cmdName + "', or not supported in cluster mode")
@@ -80,4 +160,7 @@ func (c *ClusterDatabase) Close() {
 
 func (c *ClusterDatabase) AfterClientClose(conn redis.Connection) {
 	c.db.AfterClientClose(conn)
+	if c.slots != nil {
+		c.slots.takeAsking(conn)
+	}
 }
@@ -12,7 +12,9 @@ import (
 
 // borrow object from connection pool
 func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
+	cluster.topologyMu.RLock()
 	factory, ok := cluster.peerConnection[peer]
+	cluster.topologyMu.RUnlock()
 	if !ok {
 		return nil, errors.New("connection factory not found")
 	}
@@ -29,7 +31,9 @@ func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, erro
 
 // return object to the connection pool
 func (cluster *ClusterDatabase) returnPeerClient(peer string, peerClient *client.Client) error {
+	cluster.topologyMu.RLock()
 	factory, ok := cluster.peerConnection[peer]
+	cluster.topologyMu.RUnlock()
 	if !ok {
 		return errors.New("connection factory not found")
 	}
@@ -55,10 +59,12 @@ func (cluster *ClusterDatabase) relay(peer string, c redis.Connection, args [][]
 
 
 // broadcast broadvcasts command to all nodes in cluster
+// 对端在本地执行, 不再次广播
 func (cluster *ClusterDatabase) broadcast(c redis.Connection, args [][]byte) map[string]redis.Reply {
 	result := make(map[string]redis.Reply)
-	for _, node := range cluster.nodes { //挨个node执行
-		reply := cluster.relay(node, c, args)
+	localArgs := prependCmd(relayImportExec, args)
+	for _, node := range cluster.getNodes() { //挨个node执行
+		reply := cluster.relayInternal(node, c, execImportExec, localArgs)
 		result[node] = reply
 	}
 	return result
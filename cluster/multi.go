@@ -0,0 +1,98 @@
+package cluster
+
+import (
+	database2 "redisgo/database"
+	"redisgo/interface/redis"
+	"redisgo/redis/reply"
+	"sort"
+)
+
+// 集群模式下的 MULTI/EXEC: 命令在收到 MULTI 的节点上排队, EXEC 时按负责节点分组,
+// 只涉及一个节点时也使用事务执行, 每条命令的 key 必须属于同一个节点, 不支持 WATCH
+
+// enqueueCmd validates command and puts it into transaction queue of connection
+func enqueueCmd(c redis.Connection, cmdName string, cmdLine [][]byte) redis.Reply {
+	if _, ok := router[cmdName]; !ok {
+		errReply := reply.MakeErrReply("ERR unknow command '" + cmdName + "', or not supported in cluster mode")
+		c.AddTxError(errReply)
+		return errReply
+	}
+	return database2.EnqueueCmd(c, cmdLine)
+}
+
+func execMulti(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("multi")
+	}
+	return database2.StartMulti(c)
+}
+
+func execDiscard(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("discard")
+	}
+	return database2.DiscardMulti(c)
+}
+
+// execExec executes queued commands on their nodes in a transaction
+func execExec(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("exec")
+	}
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	cmdLines := c.GetQueuedCmdLine()
+	hasErrors := len(c.GetTxErrors()) > 0
+	c.SetMultiState(false)
+	if hasErrors {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	if len(cmdLines) == 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+
+	// 每个节点上的命令和它们在事务中的位置
+	cmdsOf := make(map[string][]CmdLine)
+	indexesOf := make(map[string][]int)
+	for i, cmdLine := range cmdLines {
+		keys, _ := database2.GetRelatedKeys(cmdLine)
+		peer := cluster.self
+		if len(keys) > 0 {
+			if cluster.isMigrating(keys...) {
+				return tryAgainErrReply
+			}
+			peer = cluster.pickNode(keys[0])
+			for _, key := range keys[1:] {
+				if cluster.pickNode(key) != peer {
+					return crossSlotErrReply
+				}
+			}
+		}
+		cmdsOf[peer] = append(cmdsOf[peer], cmdLine)
+		indexesOf[peer] = append(indexesOf[peer], i)
+	}
+	peers := make([]string, 0, len(cmdsOf))
+	for peer := range cmdsOf {
+		peers = append(peers, peer)
+	}
+	// 按节点顺序加锁, 避免两个事务互相等待
+	sort.Strings(peers)
+
+	t := cluster.beginTx(c)
+	results := make([]redis.Reply, len(cmdLines))
+	for _, peer := range peers {
+		encoded, errReply := t.try(peer, cmdsOf[peer])
+		if errReply != nil {
+			t.rollback()
+			return reply.MakeErrReply("EXECABORT Transaction failed on node " + peer + " (" + errMessage(errReply) + ")")
+		}
+		for i, index := range indexesOf[peer] {
+			results[index] = rawReply(encoded[i])
+		}
+	}
+	if errReply := t.commit(); errReply != nil {
+		return errReply
+	}
+	return reply.MakeMultiRawReply(results)
+}
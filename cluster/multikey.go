@@ -0,0 +1,217 @@
+package cluster
+
+import (
+	"fmt"
+	"redisgo/interface/redis"
+	"redisgo/lib/logger"
+	"redisgo/lib/utils"
+	"redisgo/redis/reply"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 多个 key 的命令按负责节点分组, 每个节点执行一条子命令, 并发发送后按原来的顺序合并结果
+// 不同节点上的子命令不保证原子性, 部分节点失败时返回的错误中包含失败的节点; MSETNX 需要原子性, 使用事务执行
+
+// keyGroup is keys of a command served by the same node
+type keyGroup struct {
+	peer    string
+	keys    []string
+	indexes []int // key 在原命令中的位置
+	result  redis.Reply
+}
+
+// groupKeys groups keys by the node serving them, keeps order of keys in each group
+// 重新分片期间按新旧负责节点分组, 保证每组都可以在旧节点上尝试执行
+func (cluster *ClusterDatabase) groupKeys(keys []string) []*keyGroup {
+	groupOf := make(map[string]*keyGroup)
+	var groups []*keyGroup
+	for i, key := range keys {
+		prev, cur := cluster.pickOwners(key)
+		id := prev + "->" + cur
+		group, ok := groupOf[id]
+		if !ok {
+			group = &keyGroup{peer: cur}
+			groupOf[id] = group
+			groups = append(groups, group)
+		}
+		group.keys = append(group.keys, key)
+		group.indexes = append(group.indexes, i)
+	}
+	return groups
+}
+
+// scatter relays sub command of each group concurrently, returns error naming the failed nodes if any group fails
+func (cluster *ClusterDatabase) scatter(c redis.Connection, cmdName string, groups []*keyGroup,
+	makeArgs func(group *keyGroup) [][]byte) redis.Reply {
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group *keyGroup) {
+			defer func() {
+				if err := recover(); err != nil {
+					logger.Warn(fmt.Sprintf("%s on %s failed: %v", cmdName, group.peer, err))
+					group.result = &reply.UnknowErrReply{}
+				}
+				wg.Done()
+			}()
+			group.result = cluster.relayByKeys(c, group.keys, makeArgs(group))
+		}(group)
+	}
+	wg.Wait()
+	var failures []string
+	for _, group := range groups {
+		if reply.IsErrorReply(group.result) {
+			failures = append(failures, group.peer+" ("+errMessage(group.result)+")")
+		}
+	}
+	if len(failures) > 0 {
+		return reply.MakeErrReply("ERR " + cmdName + " partially failed on node " + strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+func toKeys(args [][]byte) []string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return keys
+}
+
+// scatterCount executes commands replying number of keys such as DEL and EXISTS, replies the sum of all nodes
+func (cluster *ClusterDatabase) scatterCount(c redis.Connection, args [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	keys := toKeys(args[1:])
+	groups := cluster.groupKeys(keys)
+	if len(groups) == 1 {
+		return cluster.relayByKeys(c, keys, args)
+	}
+	errReply := cluster.scatter(c, cmdName, groups, func(group *keyGroup) [][]byte {
+		return utils.ToCmdLine(append([]string{cmdName}, group.keys...)...)
+	})
+	if errReply != nil {
+		return errReply
+	}
+	var count int64
+	for _, group := range groups {
+		intReply, ok := group.result.(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("ERR " + cmdName + " got unexpected reply from node " + group.peer)
+		}
+		count += intReply.Code
+	}
+	return reply.MakeIntReply(count)
+}
+
+// Exists counts existing keys on all nodes
+func Exists(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	return cluster.scatterCount(c, args)
+}
+
+// MGet gets values of keys from their nodes and replies in the original order
+func MGet(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("mget")
+	}
+	keys := toKeys(args[1:])
+	groups := cluster.groupKeys(keys)
+	if len(groups) == 1 {
+		return cluster.relayByKeys(c, keys, args)
+	}
+	errReply := cluster.scatter(c, "mget", groups, func(group *keyGroup) [][]byte {
+		return utils.ToCmdLine(append([]string{"mget"}, group.keys...)...)
+	})
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(keys))
+	for _, group := range groups {
+		multiBulk, ok := group.result.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Args) != len(group.keys) {
+			return reply.MakeErrReply("ERR mget got unexpected reply from node " + group.peer)
+		}
+		for i, index := range group.indexes {
+			result[index] = multiBulk.Args[i]
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// MSet sets keys on their nodes, keys on different nodes are not set atomically
+func MSet(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	keys := make([]string, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		keys = append(keys, string(args[i]))
+	}
+	groups := cluster.groupKeys(keys)
+	if len(groups) == 1 {
+		return cluster.relayByKeys(c, keys, args)
+	}
+	errReply := cluster.scatter(c, "mset", groups, func(group *keyGroup) [][]byte {
+		subArgs := make([][]byte, 0, len(group.keys)*2+1)
+		subArgs = append(subArgs, []byte("mset"))
+		for _, index := range group.indexes {
+			subArgs = append(subArgs, args[1+index*2], args[2+index*2])
+		}
+		return subArgs
+	})
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeOkReply()
+}
+
+// MSetNX sets keys only if none of them exists, keys on different nodes are set in a transaction
+func MSetNX(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return reply.MakeArgNumErrReply("msetnx")
+	}
+	keys := make([]string, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		keys = append(keys, string(args[i]))
+	}
+	groups := cluster.groupKeys(keys)
+	if len(groups) == 1 {
+		return cluster.relayByKeys(c, keys, args)
+	}
+	if cluster.isMigrating(keys...) {
+		return tryAgainErrReply
+	}
+	// 按节点顺序加锁, 避免两个事务互相等待
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].peer < groups[j].peer
+	})
+	t := cluster.beginTx(c)
+	for _, group := range groups {
+		subArgs := make([][]byte, 0, len(group.keys)*2+1)
+		subArgs = append(subArgs, []byte("msetnx"))
+		for _, index := range group.indexes {
+			subArgs = append(subArgs, args[1+index*2], args[2+index*2])
+		}
+		results, errReply := t.try(group.peer, []CmdLine{subArgs})
+		if errReply != nil {
+			t.rollback()
+			return errReply
+		}
+		result := parseResult(results[0])
+		if intReply, ok := result.(*reply.IntReply); !ok || intReply.Code != 1 {
+			t.rollback()
+			if ok {
+				return reply.MakeIntReply(0)
+			}
+			return result
+		}
+	}
+	if errReply := t.commit(); errReply != nil {
+		return errReply
+	}
+	return reply.MakeIntReply(1)
+}
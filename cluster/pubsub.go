@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"redisgo/interface/redis"
+	"redisgo/lib/logger"
+	"redisgo/redis/reply"
+)
+
+// relayPublish is the internal command relayed to peers, peers only deliver message to local subscribers
+// 使用单独的命令名, 收到转发的节点不会再次广播, 避免消息在节点间循环
+const relayPublish = "_publish"
+
+// execPubSub executes pub/sub commands on local node
+func execPubSub(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	return cluster.db.Exec(c, args)
+}
+
+// Publish delivers message to local subscribers and relays it to every peer, returns total number of receivers
+func Publish(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	relayArgs := make([][]byte, len(args))
+	copy(relayArgs, args)
+	relayArgs[0] = []byte(relayPublish)
+	var count int64 = 0
+	for _, node := range cluster.getNodes() {
+		var r redis.Reply
+		if node == cluster.self {
+			r = cluster.db.Exec(c, args)
+		} else {
+			r = cluster.relay(node, c, relayArgs)
+		}
+		if reply.IsErrorReply(r) {
+			// 某个节点不可用时不影响其他节点的订阅者
+			logger.Warn("publish to " + node + " failed: " + string(r.ToBytes()))
+			continue
+		}
+		if intReply, ok := r.(*reply.IntReply); ok {
+			count += intReply.Code
+		}
+	}
+	return reply.MakeIntReply(count)
+}
+
+// onRelayedPublish handles message relayed from peer
+func onRelayedPublish(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	publishArgs := make([][]byte, len(args))
+	copy(publishArgs, args)
+	publishArgs[0] = []byte("publish")
+	return cluster.db.Exec(c, publishArgs)
+}
+
+// SSubscribe subscribes shard channels, which must be served by current node
+// 分片频道按key哈希分布, SPUBLISH 只发往负责该频道的节点
+func SSubscribe(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("ssubscribe")
+	}
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+	peer := cluster.pickNode(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.pickNode(key) != peer {
+			return crossSlotErrReply
+		}
+	}
+	if peer != cluster.self {
+		return reply.MakeErrReply("ERR shard channel '" + keys[0] + "' is served by " + peer + ", please subscribe on that node")
+	}
+	return cluster.db.Exec(c, args)
+}
@@ -2,22 +2,77 @@ package cluster
 
 import (
 	"redisgo/interface/redis"
+	"redisgo/lib/utils"
 	"redisgo/redis/reply"
+	"strconv"
+	"strings"
 )
 
+// Rename renames key, source and destination on different nodes are renamed in a transaction
 func Rename(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(args[0]))
 	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'rename' command")
+		return reply.MakeArgNumErrReply(cmdName)
 	}
-	
+
 	src := string(args[1])
 	dest := string(args[2])
 
-	srcPeer := cluster.peerPicker.PickNode(src)
-	destPeer := cluster.peerPicker.PickNode(dest)
+	srcPrev, srcPeer := cluster.pickOwners(src)
+	destPrev, destPeer := cluster.pickOwners(dest)
+
+	if srcPeer == destPeer && srcPrev == destPrev {
+		return cluster.relayByKeys(c, []string{src, dest}, args)
+	}
+	if cluster.isMigrating(src, dest) {
+		return tryAgainErrReply
+	}
+	return cluster.renameTx(c, cmdName == "renamenx", src, srcPeer, dest, destPeer)
+}
 
-	if srcPeer != destPeer {
-		return reply.MakeErrReply("ERR cannot rename")
+// renameTx removes source key and restores its value and TTL as destination key on the other node
+func (cluster *ClusterDatabase) renameTx(c redis.Connection, isNX bool, src string, srcPeer string,
+	dest string, destPeer string) redis.Reply {
+	t := cluster.beginTx(c)
+	results, errReply := t.try(srcPeer, []CmdLine{
+		utils.ToCmdLine("dump", src),
+		utils.ToCmdLine("pttl", src),
+		utils.ToCmdLine("del", src),
+	})
+	if errReply != nil {
+		t.rollback()
+		return errReply
+	}
+	payload, ok := parseResult(results[0]).(*reply.BulkReply)
+	if !ok {
+		t.rollback()
+		return reply.MakeErrReply("ERR no such key")
+	}
+	ttl := "0"
+	if pttl, ok := parseResult(results[1]).(*reply.IntReply); ok && pttl.Code > 0 {
+		ttl = strconv.FormatInt(pttl.Code, 10)
+	}
+	restoreCmd := utils.ToCmdLine2("restore", []byte(dest), []byte(ttl), payload.Arg)
+	if !isNX {
+		restoreCmd = append(restoreCmd, []byte("REPLACE"))
+	}
+	results, errReply = t.try(destPeer, []CmdLine{restoreCmd})
+	if errReply != nil {
+		t.rollback()
+		return errReply
+	}
+	if result := parseResult(results[0]); reply.IsErrorReply(result) {
+		t.rollback()
+		if isNX && isErrorOf(result, "BUSYKEY") {
+			return reply.MakeIntReply(0)
+		}
+		return result
+	}
+	if errReply := t.commit(); errReply != nil {
+		return errReply
+	}
+	if isNX {
+		return reply.MakeIntReply(1)
 	}
-	return cluster.relay(srcPeer, c, args)
-}
\ No newline at end of file
+	return reply.MakeOkReply()
+}
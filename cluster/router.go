@@ -8,26 +8,159 @@ func makeRouter() map[string]CmdFunc {
 	routerMap := make(map[string]CmdFunc)
 
 	routerMap["ping"] = ping
+	routerMap["select"] = execSelect
 
 	routerMap["del"] = Del
 
-	routerMap["exists"] = defaultFunc
+	routerMap["exists"] = Exists
 	routerMap["type"] = defaultFunc
 	routerMap["rename"] = Rename
 	routerMap["renamenx"] = Rename
+	routerMap["expire"] = defaultFunc
+	routerMap["pexpire"] = defaultFunc
+	routerMap["expireat"] = defaultFunc
+	routerMap["pexpireat"] = defaultFunc
+	routerMap["ttl"] = defaultFunc
+	routerMap["pttl"] = defaultFunc
+	routerMap["persist"] = defaultFunc
 
 	routerMap["set"] = defaultFunc
 	routerMap["setnx"] = defaultFunc
 	routerMap["get"] = defaultFunc
 	routerMap["getset"] = defaultFunc
+	routerMap["mget"] = MGet
+	routerMap["mset"] = MSet
+	routerMap["msetnx"] = MSetNX
+	routerMap["strlen"] = defaultFunc
+	routerMap["incr"] = defaultFunc
+	routerMap["incrby"] = defaultFunc
+	routerMap["decr"] = defaultFunc
+	routerMap["decrby"] = defaultFunc
+
+	routerMap["lpush"] = defaultFunc
+	routerMap["lpushx"] = defaultFunc
+	routerMap["rpush"] = defaultFunc
+	routerMap["rpushx"] = defaultFunc
+	routerMap["lpop"] = defaultFunc
+	routerMap["rpop"] = defaultFunc
+	routerMap["lrem"] = defaultFunc
+	routerMap["llen"] = defaultFunc
+	routerMap["lindex"] = defaultFunc
+	routerMap["lset"] = defaultFunc
+	routerMap["lrange"] = defaultFunc
+	routerMap["ltrim"] = defaultFunc
+	routerMap["linsert"] = defaultFunc
+
+	routerMap["hset"] = defaultFunc
+	routerMap["hmset"] = defaultFunc
+	routerMap["hsetnx"] = defaultFunc
+	routerMap["hget"] = defaultFunc
+	routerMap["hexists"] = defaultFunc
+	routerMap["hdel"] = defaultFunc
+	routerMap["hlen"] = defaultFunc
+	routerMap["hstrlen"] = defaultFunc
+	routerMap["hmget"] = defaultFunc
+	routerMap["hkeys"] = defaultFunc
+	routerMap["hvals"] = defaultFunc
+	routerMap["hgetall"] = defaultFunc
+	routerMap["hincrby"] = defaultFunc
+	routerMap["hincrbyfloat"] = defaultFunc
+	routerMap["hrandfield"] = defaultFunc
+	routerMap["hscan"] = defaultFunc
+
+	routerMap["sadd"] = defaultFunc
+	routerMap["sismember"] = defaultFunc
+	routerMap["smismember"] = defaultFunc
+	routerMap["srem"] = defaultFunc
+	routerMap["spop"] = defaultFunc
+	routerMap["scard"] = defaultFunc
+	routerMap["smembers"] = defaultFunc
+	routerMap["srandmember"] = defaultFunc
+	routerMap["sscan"] = defaultFunc
+	routerMap["smove"] = SMove
+	routerMap["sinter"] = multiKeyFunc
+	routerMap["sinterstore"] = multiKeyFunc
+	routerMap["sintercard"] = SInterCard
+	routerMap["sunion"] = multiKeyFunc
+	routerMap["sunionstore"] = multiKeyFunc
+	routerMap["sdiff"] = multiKeyFunc
+	routerMap["sdiffstore"] = multiKeyFunc
+
+	routerMap["zadd"] = defaultFunc
+	routerMap["zscore"] = defaultFunc
+	routerMap["zmscore"] = defaultFunc
+	routerMap["zincrby"] = defaultFunc
+	routerMap["zrank"] = defaultFunc
+	routerMap["zrevrank"] = defaultFunc
+	routerMap["zcount"] = defaultFunc
+	routerMap["zlexcount"] = defaultFunc
+	routerMap["zcard"] = defaultFunc
+	routerMap["zrange"] = defaultFunc
+	routerMap["zrevrange"] = defaultFunc
+	routerMap["zrangebyscore"] = defaultFunc
+	routerMap["zrevrangebyscore"] = defaultFunc
+	routerMap["zrangebylex"] = defaultFunc
+	routerMap["zrevrangebylex"] = defaultFunc
+	routerMap["zrem"] = defaultFunc
+	routerMap["zremrangebyscore"] = defaultFunc
+	routerMap["zremrangebylex"] = defaultFunc
+	routerMap["zremrangebyrank"] = defaultFunc
+	routerMap["zpopmin"] = defaultFunc
+	routerMap["zpopmax"] = defaultFunc
+	routerMap["zunionstore"] = ZStore
+	routerMap["zinterstore"] = ZStore
 
 	routerMap["flushdb"] = FlushDB
 
+	routerMap["multi"] = execMulti
+	routerMap["exec"] = execExec
+	routerMap["discard"] = execDiscard
+
+	routerMap["subscribe"] = execPubSub
+	routerMap["unsubscribe"] = execPubSub
+	routerMap["psubscribe"] = execPubSub
+	routerMap["punsubscribe"] = execPubSub
+	routerMap["publish"] = Publish
+	routerMap[relayPublish] = onRelayedPublish
+	routerMap["pubsub"] = execPubSub
+	routerMap["ssubscribe"] = SSubscribe
+	routerMap["sunsubscribe"] = execPubSub
+	routerMap["spublish"] = defaultFunc
+
+	routerMap["acl"] = localFunc
+	routerMap["bgrewriteaof"] = localFunc
+	routerMap["save"] = localFunc
+	routerMap["bgsave"] = localFunc
+	routerMap["lastsave"] = localFunc
+	routerMap["replicaof"] = localFunc
+	routerMap["slaveof"] = localFunc
+	routerMap["psync"] = localFunc
+	routerMap["sync"] = localFunc
+	routerMap["replconf"] = localFunc
+	routerMap["role"] = localFunc
+	routerMap["wait"] = localFunc
+	routerMap["dump"] = defaultFunc
+	routerMap["restore"] = defaultFunc
+
+	routerMap["cluster"] = execClusterAdmin
+	routerMap[relayTryExec] = execTryExec
+	routerMap[relayImportExec] = execImportExec
+	routerMap[relaySetRing] = execSetRing
+	routerMap[relayMigrate] = execMigrate
+	routerMap[relayRingDone] = execRingDone
+	routerMap[relayTryTx] = execTryTx
+	routerMap[relayCommitTx] = execCommitTx
+	routerMap[relayRollbackTx] = execRollbackTx
+
 	return routerMap
 }
 
+// localFunc executes command on current node, such as ACL which is configured per node
+func localFunc(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	return cluster.db.Exec(c, args)
+}
+
 func defaultFunc(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
 	key := string(args[1])
-	peer := cluster.peerPicker.PickNode(key)
-	return cluster.relay(peer, c, args)
+	return cluster.relayByKeys(c, []string{key}, args)
 }
\ No newline at end of file
@@ -0,0 +1,51 @@
+package cluster
+
+import (
+	"redisgo/interface/redis"
+	"redisgo/redis/reply"
+	"strconv"
+)
+
+var crossSlotErrReply = reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same node")
+
+// relaySameNode relays a multi-key command to the node owning all keys
+// 所有的key必须位于同一个节点上
+func relaySameNode(cluster *ClusterDatabase, c redis.Connection, args [][]byte, keys [][]byte) redis.Reply {
+	peer := ""
+	relatedKeys := make([]string, len(keys))
+	for i, key := range keys {
+		node := cluster.pickNode(string(key))
+		if peer == "" {
+			peer = node
+		} else if peer != node {
+			return crossSlotErrReply
+		}
+		relatedKeys[i] = string(key)
+	}
+	return cluster.relayByKeys(c, relatedKeys, args)
+}
+
+// multiKeyFunc handles commands whose arguments are all keys, such as SINTER, SINTERSTORE
+func multiKeyFunc(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	return relaySameNode(cluster, c, args, args[1:])
+}
+
+// SMove moves member between sets on the same node
+func SMove(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 4 {
+		return reply.MakeArgNumErrReply("smove")
+	}
+	return relaySameNode(cluster, c, args, args[1:3])
+}
+
+// SInterCard relays SINTERCARD numkeys key [key ...] [LIMIT limit]
+func SInterCard(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply("sintercard")
+	}
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys <= 0 || numKeys > len(args)-2 {
+		return reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	return relaySameNode(cluster, c, args, args[2:2+numKeys])
+}
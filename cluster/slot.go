@@ -0,0 +1,208 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"redisgo/interface/redis"
+	"redisgo/lib/crc16"
+	"redisgo/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 哈希槽模式: 和 redis cluster 一样把 key 分到 16384 个槽, 每个槽属于一个节点
+// 请求的 key 不属于当前节点时返回 MOVED, 由客户端重定向, 不在服务端转发
+// 槽迁移期间源节点上不存在的 key 返回 ASK, 客户端先发送 ASKING 再到目标节点执行
+
+// SlotCount is the number of hash slots
+const SlotCount = 16384
+
+// getSlot returns hash slot of key, only the part in the first non-empty {hashtag} is hashed if exists
+func getSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16.Checksum([]byte(key))) % SlotCount
+}
+
+// slotNode is a node in hash slot cluster
+type slotNode struct {
+	id   string
+	addr string
+	host string
+	port int
+}
+
+// makeNodeID derives node id from address, so every node knows ids of others without gossip
+func makeNodeID(addr string) string {
+	sum := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(sum[:])
+}
+
+func makeSlotNode(addr string) *slotNode {
+	node := &slotNode{
+		id:   makeNodeID(addr),
+		addr: addr,
+		host: addr,
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		node.host = host
+		node.port, _ = strconv.Atoi(port)
+	}
+	return node
+}
+
+// slotRange is a range of continuous slots owned by the same node
+type slotRange struct {
+	start int
+	end   int
+	node  *slotNode
+}
+
+// slotTable records owner and migrating state of each slot
+// 没有 gossip 协议, 所有节点按相同规则初始分配槽, 之后由 CLUSTER SETSLOT 在每个节点上分别修改
+type slotTable struct {
+	mu     sync.RWMutex
+	self   *slotNode
+	nodes  map[string]*slotNode // node id -> node
+	owners [SlotCount]*slotNode
+	// 正在迁出的槽 -> 目标节点, 正在迁入的槽 -> 源节点
+	migrating map[int]*slotNode
+	importing map[int]*slotNode
+	// 发送了 ASKING 的连接, 下一条命令可以访问正在迁入的槽
+	asking map[redis.Connection]struct{}
+}
+
+// makeSlotTable assigns slots evenly to nodes sorted by address, every node computes the same result
+func makeSlotTable(self string, addrs []string) *slotTable {
+	table := &slotTable{
+		nodes:     make(map[string]*slotNode),
+		migrating: make(map[int]*slotNode),
+		importing: make(map[int]*slotNode),
+		asking:    make(map[redis.Connection]struct{}),
+	}
+	sorted := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr == "" {
+			continue
+		}
+		if _, ok := table.nodes[makeNodeID(addr)]; ok {
+			continue
+		}
+		node := makeSlotNode(addr)
+		table.nodes[node.id] = node
+		sorted = append(sorted, addr)
+	}
+	sort.Strings(sorted)
+	for i, addr := range sorted {
+		node := table.nodes[makeNodeID(addr)]
+		start := i * SlotCount / len(sorted)
+		end := (i + 1) * SlotCount / len(sorted)
+		for slot := start; slot < end; slot++ {
+			table.owners[slot] = node
+		}
+	}
+	table.self = table.nodes[makeNodeID(self)]
+	return table
+}
+
+// getNode finds node by id or address
+func (t *slotTable) getNode(name string) *slotNode {
+	if node, ok := t.nodes[name]; ok {
+		return node
+	}
+	return t.nodes[makeNodeID(name)]
+}
+
+// ranges returns continuous slot ranges ordered by start slot, unassigned slots are skipped
+func (t *slotTable) ranges() []*slotRange {
+	var result []*slotRange
+	var cur *slotRange
+	for slot, node := range t.owners {
+		if cur != nil && cur.node == node && cur.end == slot-1 {
+			cur.end = slot
+			continue
+		}
+		cur = nil
+		if node != nil {
+			cur = &slotRange{start: slot, end: slot, node: node}
+			result = append(result, cur)
+		}
+	}
+	return result
+}
+
+// sortedNodes returns nodes ordered by address
+func (t *slotTable) sortedNodes() []*slotNode {
+	nodes := make([]*slotNode, 0, len(t.nodes))
+	for _, node := range t.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].addr < nodes[j].addr
+	})
+	return nodes
+}
+
+func (t *slotTable) setAsking(c redis.Connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.asking[c] = struct{}{}
+}
+
+// takeAsking returns whether the connection sent ASKING and clears the flag, ASKING only affects the next command
+func (t *slotTable) takeAsking(c redis.Connection) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.asking[c]
+	delete(t.asking, c)
+	return ok
+}
+
+// checkSlot returns MOVED or ASK error if the keys should be served by other node, nil means executing locally
+// exists 检查 key 是否在本节点, 只在槽正在迁出时使用
+func (t *slotTable) checkSlot(keys []string, asking bool, exists func(key string) bool) redis.Reply {
+	slot := getSlot(keys[0])
+	for _, key := range keys[1:] {
+		if getSlot(key) != slot {
+			return slotCrossErrReply
+		}
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	owner := t.owners[slot]
+	if owner == nil {
+		return reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+	}
+	if owner != t.self {
+		if source := t.importing[slot]; source != nil && asking {
+			return nil
+		}
+		return reply.MakeErrReply("MOVED " + strconv.Itoa(slot) + " " + owner.addr)
+	}
+	target := t.migrating[slot]
+	if target == nil {
+		return nil
+	}
+	// 迁出中的槽: key 都在本节点时直接执行, 都已迁走时让客户端去目标节点, 部分迁走时稍后重试
+	missing := 0
+	for _, key := range keys {
+		if !exists(key) {
+			missing++
+		}
+	}
+	if missing == 0 {
+		return nil
+	}
+	if missing == len(keys) {
+		return reply.MakeErrReply("ASK " + strconv.Itoa(slot) + " " + target.addr)
+	}
+	return reply.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot")
+}
+
+var slotCrossErrReply = reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
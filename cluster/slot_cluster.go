@@ -0,0 +1,307 @@
+package cluster
+
+import (
+	"fmt"
+	database2 "redisgo/database"
+	"redisgo/interface/database"
+	"redisgo/interface/redis"
+	"redisgo/lib/utils"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execWithSlots executes command in hash slot mode, commands of keys served by other nodes are redirected
+func (cluster *ClusterDatabase) execWithSlots(c redis.Connection, cmdName string, cmdLine [][]byte) redis.Reply {
+	table := cluster.slots
+	switch cmdName {
+	case "cluster":
+		if len(cmdLine) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execCluster(cluster, cmdLine[1:])
+	case "asking":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		table.setAsking(c)
+		return reply.MakeOkReply()
+	case "readonly", "readwrite":
+		// 没有从节点, 读写都在主节点上执行
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return reply.MakeOkReply()
+	case "select":
+		if len(cmdLine) == 2 && string(cmdLine[1]) != "0" {
+			return reply.MakeErrReply("ERR SELECT is not allowed in cluster mode")
+		}
+	case "publish":
+		// 普通频道的消息发送到所有节点
+		return Publish(cluster, c, cmdLine)
+	case relayPublish:
+		return onRelayedPublish(cluster, c, cmdLine)
+	}
+
+	asking := table.takeAsking(c)
+	keys, ok := database2.GetRelatedKeys(cmdLine)
+	if !ok || len(keys) == 0 {
+		return cluster.db.Exec(c, cmdLine)
+	}
+	errReply := table.checkSlot(keys, asking, func(key string) bool {
+		return cluster.db.KeyExists(0, key)
+	})
+	if errReply != nil {
+		if c.InMultiState() {
+			c.AddTxError(errReply.(reply.ErrorReply))
+		}
+		return errReply
+	}
+	return cluster.db.Exec(c, cmdLine)
+}
+
+// execCluster executes CLUSTER subcommands
+func execCluster(cluster *ClusterDatabase, args [][]byte) redis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch subCmd {
+	case "info":
+		return clusterInfo(cluster.slots)
+	case "myid":
+		return reply.MakeBulkReply([]byte(cluster.slots.self.id))
+	case "slots":
+		return clusterSlots(cluster.slots)
+	case "shards":
+		return clusterShards(cluster.slots)
+	case "nodes":
+		return clusterNodes(cluster.slots)
+	case "keyslot":
+		if len(args) != 1 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'cluster|keyslot' command")
+		}
+		return reply.MakeIntReply(int64(getSlot(string(args[0]))))
+	case "countkeysinslot":
+		if len(args) != 1 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'cluster|countkeysinslot' command")
+		}
+		slot, err := parseSlot(args[0])
+		if err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeIntReply(int64(len(cluster.keysInSlot(slot, -1))))
+	case "getkeysinslot":
+		if len(args) != 2 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'cluster|getkeysinslot' command")
+		}
+		slot, err := parseSlot(args[0])
+		if err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		count, err := strconv.Atoi(string(args[1]))
+		if err != nil || count < 0 {
+			return reply.MakeErrReply("ERR Invalid number of keys")
+		}
+		keys := cluster.keysInSlot(slot, count)
+		return reply.MakeMultiBulkReply(utils.ToCmdLine(keys...))
+	case "setslot":
+		return clusterSetSlot(cluster.slots, args)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLUSTER HELP.")
+}
+
+func parseSlot(arg []byte) (int, error) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, fmt.Errorf("Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+// keysInSlot returns at most count keys in the slot, count < 0 means no limit
+// 没有按槽建立索引, 需要遍历 DB 0 的所有 key
+func (cluster *ClusterDatabase) keysInSlot(slot int, count int) []string {
+	var keys []string
+	if count == 0 {
+		return keys
+	}
+	cluster.db.ForEach(0, func(key string, _ *database.DataEntity, _ *time.Time) bool {
+		if getSlot(key) == slot {
+			keys = append(keys, key)
+		}
+		return count < 0 || len(keys) < count
+	})
+	return keys
+}
+
+func clusterInfo(t *slotTable) redis.Reply {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	assigned := 0
+	masters := make(map[*slotNode]struct{})
+	for _, node := range t.owners {
+		if node != nil {
+			assigned++
+			masters[node] = struct{}{}
+		}
+	}
+	state := "ok"
+	if assigned < SlotCount {
+		state = "fail"
+	}
+	lines := []string{
+		"cluster_enabled:1",
+		"cluster_state:" + state,
+		"cluster_slots_assigned:" + strconv.Itoa(assigned),
+		"cluster_slots_ok:" + strconv.Itoa(assigned),
+		"cluster_slots_pfail:0",
+		"cluster_slots_fail:0",
+		"cluster_known_nodes:" + strconv.Itoa(len(t.nodes)),
+		"cluster_size:" + strconv.Itoa(len(masters)),
+		"cluster_current_epoch:0",
+		"cluster_my_epoch:0",
+	}
+	return reply.MakeBulkReply([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+}
+
+// clusterSlots replies [start, end, [host, port, id]] for each slot range
+func clusterSlots(t *slotTable) redis.Reply {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ranges := t.ranges()
+	result := make([]redis.Reply, 0, len(ranges))
+	for _, r := range ranges {
+		result = append(result, reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeIntReply(int64(r.start)),
+			reply.MakeIntReply(int64(r.end)),
+			reply.MakeMultiRawReply([]redis.Reply{
+				reply.MakeBulkReply([]byte(r.node.host)),
+				reply.MakeIntReply(int64(r.node.port)),
+				reply.MakeBulkReply([]byte(r.node.id)),
+			}),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// clusterShards replies slots and nodes of each shard, every shard has only one master
+func clusterShards(t *slotTable) redis.Reply {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	slotsOf := make(map[*slotNode][]redis.Reply)
+	for _, r := range t.ranges() {
+		slotsOf[r.node] = append(slotsOf[r.node], reply.MakeIntReply(int64(r.start)), reply.MakeIntReply(int64(r.end)))
+	}
+	var result []redis.Reply
+	for _, node := range t.sortedNodes() {
+		nodeInfo := reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte("id")), reply.MakeBulkReply([]byte(node.id)),
+			reply.MakeBulkReply([]byte("port")), reply.MakeIntReply(int64(node.port)),
+			reply.MakeBulkReply([]byte("ip")), reply.MakeBulkReply([]byte(node.host)),
+			reply.MakeBulkReply([]byte("endpoint")), reply.MakeBulkReply([]byte(node.host)),
+			reply.MakeBulkReply([]byte("role")), reply.MakeBulkReply([]byte("master")),
+			reply.MakeBulkReply([]byte("replication-offset")), reply.MakeIntReply(0),
+			reply.MakeBulkReply([]byte("health")), reply.MakeBulkReply([]byte("online")),
+		})
+		result = append(result, reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte("slots")), reply.MakeMultiRawReply(slotsOf[node]),
+			reply.MakeBulkReply([]byte("nodes")), reply.MakeMultiRawReply([]redis.Reply{nodeInfo}),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// clusterNodes replies nodes in the format of nodes.conf
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func clusterNodes(t *slotTable) redis.Reply {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	slotsOf := make(map[*slotNode][]string)
+	for _, r := range t.ranges() {
+		if r.start == r.end {
+			slotsOf[r.node] = append(slotsOf[r.node], strconv.Itoa(r.start))
+		} else {
+			slotsOf[r.node] = append(slotsOf[r.node], strconv.Itoa(r.start)+"-"+strconv.Itoa(r.end))
+		}
+	}
+	if t.self != nil {
+		for slot, target := range t.migrating {
+			slotsOf[t.self] = append(slotsOf[t.self], fmt.Sprintf("[%d->-%s]", slot, target.id))
+		}
+		for slot, source := range t.importing {
+			slotsOf[t.self] = append(slotsOf[t.self], fmt.Sprintf("[%d-<-%s]", slot, source.id))
+		}
+	}
+	var buf strings.Builder
+	for _, node := range t.sortedNodes() {
+		flags := "master"
+		if node == t.self {
+			flags = "myself,master"
+		}
+		fields := []string{
+			node.id,
+			fmt.Sprintf("%s:%d@%d", node.host, node.port, node.port+10000),
+			flags, "-", "0", "0", "0", "connected",
+		}
+		fields = append(fields, slotsOf[node]...)
+		buf.WriteString(strings.Join(fields, " "))
+		buf.WriteString("\n")
+	}
+	return reply.MakeBulkReply([]byte(buf.String()))
+}
+
+// clusterSetSlot executes CLUSTER SETSLOT <slot> IMPORTING|MIGRATING|NODE <node-id> or STABLE on current node
+// 和 redis 一样需要在迁移涉及的节点上分别执行, 节点可以使用 id 或地址表示
+func clusterSetSlot(t *slotTable, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'cluster|setslot' command")
+	}
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	action := strings.ToLower(string(args[1]))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if action == "stable" {
+		if len(args) != 2 {
+			return reply.MakeSyntaxErrReply()
+		}
+		delete(t.migrating, slot)
+		delete(t.importing, slot)
+		return reply.MakeOkReply()
+	}
+	if len(args) != 3 {
+		return reply.MakeSyntaxErrReply()
+	}
+	node := t.getNode(string(args[2]))
+	if node == nil {
+		return reply.MakeErrReply("ERR I don't know about node " + string(args[2]))
+	}
+	switch action {
+	case "migrating":
+		if t.owners[slot] != t.self {
+			return reply.MakeErrReply("ERR I'm not the owner of hash slot " + strconv.Itoa(slot))
+		}
+		if node == t.self {
+			return reply.MakeErrReply("ERR I can't migrate hash slot to myself")
+		}
+		t.migrating[slot] = node
+	case "importing":
+		if t.owners[slot] == t.self {
+			return reply.MakeErrReply("ERR I'm already the owner of hash slot " + strconv.Itoa(slot))
+		}
+		if node == t.self {
+			return reply.MakeErrReply("ERR I can't import hash slot from myself")
+		}
+		t.importing[slot] = node
+	case "node":
+		// 迁移完成, 槽的所有者变为 node, 清除迁移状态
+		t.owners[slot] = node
+		delete(t.migrating, slot)
+		delete(t.importing, slot)
+	default:
+		return reply.MakeErrReply("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+	return reply.MakeOkReply()
+}
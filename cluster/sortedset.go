@@ -0,0 +1,24 @@
+package cluster
+
+import (
+	"redisgo/interface/redis"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+)
+
+// ZStore relays ZUNIONSTORE/ZINTERSTORE destination numkeys key [key ...] [options]
+func ZStore(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) < 4 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	numKeys, err := strconv.Atoi(string(args[2]))
+	if err != nil || numKeys <= 0 || numKeys > len(args)-3 {
+		return reply.MakeSyntaxErrReply()
+	}
+	keys := make([][]byte, 0, numKeys+1)
+	keys = append(keys, args[1])
+	keys = append(keys, args[3:3+numKeys]...)
+	return relaySameNode(cluster, c, args, keys)
+}
@@ -0,0 +1,249 @@
+package cluster
+
+import (
+	"fmt"
+	database2 "redisgo/database"
+	"redisgo/interface/redis"
+	"redisgo/lib/logger"
+	"redisgo/lib/utils"
+	"redisgo/redis/parser"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 跨节点的 RENAME, RENAMENX, MSETNX 和 MULTI/EXEC 使用 TCC 事务, 由收到命令的节点协调:
+// 1. try: 依次向参与的节点发送 _trytx, 节点锁定 key, 记录回滚日志并执行命令
+// 2. 所有节点都成功且结果符合要求时发送 _committx 释放锁, 否则向发送过 try 的节点发送 _rollbacktx 恢复 key
+// 参与者在 try 之后 txTimeout 内没有收到 commit 或 rollback 时自动回滚, 协调者崩溃或事务间互相等锁时 key 不会一直被锁定
+
+const (
+	relayTryTx      = "_trytx"
+	relayCommitTx   = "_committx"
+	relayRollbackTx = "_rollbacktx"
+)
+
+const (
+	// 小于节点间请求的超时时间, 互相等待锁的事务在协调者放弃之前就会回滚
+	txTimeout = 2 * time.Second
+	// 协调者超过这个时间还没有开始提交时放弃, 保证提交时参与者还没有自动回滚
+	txCommitDeadline = txTimeout / 2
+	// 回滚过的事务记录一段时间, 拒绝迟到的 try
+	abortedTxTTL = time.Minute
+)
+
+// participantTx is a transaction tried on current node, waiting for commit or rollback
+type participantTx struct {
+	tx    *database2.Tx
+	timer *time.Timer
+}
+
+// tccTx coordinates a transaction across nodes
+type tccTx struct {
+	cluster  *ClusterDatabase
+	c        redis.Connection
+	id       string
+	deadline time.Time
+	// 发送过 try 的节点, try 失败的节点也可能已经执行, 回滚时都需要通知
+	tried []string
+}
+
+// rawReply is a reply which has been encoded by other node
+type rawReply []byte
+
+func (r rawReply) ToBytes() []byte {
+	return r
+}
+
+func (cluster *ClusterDatabase) beginTx(c redis.Connection) *tccTx {
+	seq := atomic.AddUint64(&cluster.txSeq, 1)
+	return &tccTx{
+		cluster:  cluster,
+		c:        c,
+		id:       fmt.Sprintf("%s-%d-%d", cluster.self, time.Now().UnixNano(), seq),
+		deadline: time.Now().Add(txCommitDeadline),
+	}
+}
+
+// try executes commands on node, returns encoded result of each command
+// 同一个事务在每个节点上只能 try 一次
+func (t *tccTx) try(node string, cmdLines []CmdLine) ([][]byte, redis.Reply) {
+	t.tried = append(t.tried, node)
+	args := utils.ToCmdLine(relayTryTx, t.id)
+	for _, cmdLine := range cmdLines {
+		args = append(args, []byte(strconv.Itoa(len(cmdLine))))
+		args = append(args, cmdLine...)
+	}
+	result := t.cluster.relayInternal(node, t.c, execTryTx, args)
+	if reply.IsErrorReply(result) {
+		return nil, result
+	}
+	multiBulk, ok := result.(*reply.MultiBulkReply)
+	if !ok || len(multiBulk.Args) != len(cmdLines) {
+		return nil, reply.MakeErrReply("ERR unexpected reply of transaction from node " + node)
+	}
+	return multiBulk.Args, nil
+}
+
+// commit commits transaction on all nodes, returns error reply naming the failed nodes
+func (t *tccTx) commit() redis.Reply {
+	if time.Now().After(t.deadline) {
+		t.rollback()
+		return reply.MakeErrReply("ERR transaction timeout, rolled back")
+	}
+	var failures []string
+	for _, node := range t.tried {
+		result := t.cluster.relayInternal(node, t.c, execCommitTx, utils.ToCmdLine(relayCommitTx, t.id))
+		if reply.IsErrorReply(result) {
+			failures = append(failures, node+" ("+errMessage(result)+")")
+		}
+	}
+	if len(failures) > 0 {
+		logger.Warn(fmt.Sprintf("transaction %s partially committed, failed on %s", t.id, strings.Join(failures, ", ")))
+		return reply.MakeErrReply("ERR transaction partially committed, failed on node " + strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// rollback rolls back transaction on all tried nodes
+func (t *tccTx) rollback() {
+	for _, node := range t.tried {
+		result := t.cluster.relayInternal(node, t.c, execRollbackTx, utils.ToCmdLine(relayRollbackTx, t.id))
+		if reply.IsErrorReply(result) {
+			logger.Warn(fmt.Sprintf("rollback transaction %s on %s failed: %s", t.id, node, errMessage(result)))
+		}
+	}
+}
+
+// parseResult decodes result of command returned by try
+func parseResult(raw []byte) redis.Reply {
+	results, err := parser.ParseBytes(raw)
+	if err != nil || len(results) == 0 {
+		return &reply.UnknowErrReply{}
+	}
+	return results[0]
+}
+
+// isMigrating tells whether any of keys is being moved to other node
+func (cluster *ClusterDatabase) isMigrating(keys ...string) bool {
+	for _, key := range keys {
+		if prev, cur := cluster.pickOwners(key); prev != "" && prev != cur {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeCmdLines decodes commands encoded as: argc arg1 arg2 ... argc arg1 ...
+func decodeCmdLines(args [][]byte) ([]CmdLine, bool) {
+	var cmdLines []CmdLine
+	for i := 0; i < len(args); {
+		argc, err := strconv.Atoi(string(args[i]))
+		if err != nil || argc <= 0 || i+1+argc > len(args) {
+			return nil, false
+		}
+		cmdLines = append(cmdLines, args[i+1:i+1+argc])
+		i += 1 + argc
+	}
+	return cmdLines, len(cmdLines) > 0
+}
+
+// execTryTx executes _trytx <txid> <argc> <args...> [<argc> <args...> ...], keys stay locked until commit or rollback
+func execTryTx(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 4 {
+		return reply.MakeArgNumErrReply(relayTryTx)
+	}
+	id := string(args[1])
+	cmdLines, ok := decodeCmdLines(args[2:])
+	if !ok {
+		return reply.MakeSyntaxErrReply()
+	}
+	if cluster.isTxAborted(id) {
+		return reply.MakeErrReply("ERR transaction " + id + " has been rolled back")
+	}
+	tx, results, errReply := cluster.db.TryTx(c, cmdLines)
+	if errReply != nil {
+		return errReply
+	}
+	cluster.txMu.Lock()
+	_, aborted := cluster.abortedTxs[id]
+	_, exists := cluster.transactions[id]
+	if aborted || exists {
+		// 等待锁期间协调者已经放弃了事务
+		cluster.txMu.Unlock()
+		tx.Rollback()
+		return reply.MakeErrReply("ERR transaction " + id + " has been rolled back")
+	}
+	cluster.transactions[id] = &participantTx{
+		tx: tx,
+		timer: time.AfterFunc(txTimeout, func() {
+			if cluster.finishTx(id, false) {
+				logger.Warn("transaction " + id + " timeout, rolled back")
+			}
+		}),
+	}
+	cluster.txMu.Unlock()
+
+	encoded := make([][]byte, len(results))
+	for i, result := range results {
+		encoded[i] = result.ToBytes()
+	}
+	return reply.MakeMultiBulkReply(encoded)
+}
+
+// execCommitTx executes _committx <txid>
+func execCommitTx(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply(relayCommitTx)
+	}
+	if !cluster.finishTx(string(args[1]), true) {
+		return reply.MakeErrReply("ERR transaction " + string(args[1]) + " not found, it may have timed out")
+	}
+	return reply.MakeOkReply()
+}
+
+// execRollbackTx executes _rollbacktx <txid>, rolling back unknown transaction prevents it from being tried later
+func execRollbackTx(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply(relayRollbackTx)
+	}
+	cluster.finishTx(string(args[1]), false)
+	return reply.MakeOkReply()
+}
+
+// finishTx commits or rolls back transaction tried on current node, returns false if it's not found
+func (cluster *ClusterDatabase) finishTx(id string, commit bool) bool {
+	cluster.txMu.Lock()
+	ptx, ok := cluster.transactions[id]
+	if ok {
+		delete(cluster.transactions, id)
+	}
+	if !commit {
+		cluster.abortedTxs[id] = struct{}{}
+		time.AfterFunc(abortedTxTTL, func() {
+			cluster.txMu.Lock()
+			delete(cluster.abortedTxs, id)
+			cluster.txMu.Unlock()
+		})
+	}
+	cluster.txMu.Unlock()
+	if !ok {
+		return false
+	}
+	ptx.timer.Stop()
+	if commit {
+		ptx.tx.Commit()
+	} else {
+		ptx.tx.Rollback()
+	}
+	return true
+}
+
+func (cluster *ClusterDatabase) isTxAborted(id string) bool {
+	cluster.txMu.Lock()
+	defer cluster.txMu.Unlock()
+	_, ok := cluster.abortedTxs[id]
+	return ok
+}
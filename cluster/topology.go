@@ -0,0 +1,591 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"redisgo/config"
+	database2 "redisgo/database"
+	"redisgo/interface/redis"
+	"redisgo/lib/consistenthash"
+	"redisgo/lib/logger"
+	"redisgo/lib/utils"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pool "github.com/jolestar/go-commons-pool/v2"
+)
+
+// 节点加入或离开一致性哈希环时在线迁移 key, 由执行 CLUSTER ADDNODE/DELNODE 的节点协调:
+// 1. 向新旧环上的所有节点发送 _setring, 各节点切换到新环并保留旧环
+// 2. 旧环上的节点把负责节点发生变化的 key 以 RESTORE 命令发送给新的负责节点, 发送成功后删除
+// 3. 所有节点迁移完成后发送 _ringdone, 丢弃旧环
+// 迁移期间负责节点发生变化的 key 先发往旧节点尝试执行, key 已迁走时再发往新节点执行
+
+const (
+	relayTryExec    = "_tryexec"
+	relayImportExec = "_importexec"
+	relaySetRing    = "_setring"
+	relayMigrate    = "_migrate"
+	relayRingDone   = "_ringdone"
+)
+
+const (
+	// 部分 key 已迁走时等待迁移完成后重试
+	tryAgainRetries     = 20
+	tryAgainInterval    = 50 * time.Millisecond
+	migratePollInterval = 100 * time.Millisecond
+)
+
+var (
+	movedAwayErrReply = reply.MakeErrReply("MOVEDAWAY Keys have been migrated to new node")
+	tryAgainErrReply  = reply.MakeErrReply("TRYAGAIN Multiple keys request during resharding")
+)
+
+// migrationStatus records keys moved by current node during resharding
+type migrationStatus struct {
+	// 在旧节点上执行命令时持有读锁, 封存时持有写锁, 封存后新写入的 key 只能发往新节点
+	gate   sync.RWMutex
+	sealed bool
+
+	mu      sync.Mutex
+	moved   map[int]map[string]struct{}
+	started bool
+	done    bool
+	err     error
+}
+
+func makeMigrationStatus() *migrationStatus {
+	return &migrationStatus{
+		moved: make(map[int]map[string]struct{}),
+	}
+}
+
+func (m *migrationStatus) isMoved(dbIndex int, key string) bool {
+	if m.sealed {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.moved[dbIndex][key]
+	return ok
+}
+
+func (m *migrationStatus) markMoved(dbIndex int, key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys, ok := m.moved[dbIndex]
+	if !ok {
+		keys = make(map[string]struct{})
+		m.moved[dbIndex] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+// makePicker builds hash ring of nodes, caller should hold topologyMu
+func (cluster *ClusterDatabase) makePicker(nodes []string) *consistenthash.NodeMap {
+	picker := consistenthash.NewNodeMap(config.Properties.ClusterReplicas, cluster.hashFunc)
+	for _, node := range nodes {
+		picker.AddWeightedNode(node, cluster.weightOf(node))
+	}
+	return picker
+}
+
+func (cluster *ClusterDatabase) weightOf(node string) int {
+	if weight, ok := cluster.weights[node]; ok {
+		return weight
+	}
+	return 1
+}
+
+// parseNodeWeight parses <addr>=<weight>, weight is 1 if omitted
+func parseNodeWeight(token string) (string, int, error) {
+	pivot := strings.LastIndexByte(token, '=')
+	if pivot < 0 {
+		return token, 1, nil
+	}
+	weight, err := strconv.Atoi(token[pivot+1:])
+	if err != nil || weight <= 0 || pivot == 0 {
+		return "", 0, errors.New("invalid node weight " + token)
+	}
+	return token[:pivot], weight, nil
+}
+
+func containsNode(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// sameNodes tells whether the two node lists contain the same nodes regardless of order
+func sameNodes(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, node := range a {
+		if !containsNode(b, node) {
+			return false
+		}
+	}
+	return true
+}
+
+// unionNodes returns nodes in a or b, nodes in a come first
+func unionNodes(a []string, b []string) []string {
+	result := make([]string, 0, len(a)+len(b))
+	result = append(result, a...)
+	for _, node := range b {
+		if !containsNode(result, node) {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// pickNode returns the node serving the key
+func (cluster *ClusterDatabase) pickNode(key string) string {
+	cluster.topologyMu.RLock()
+	defer cluster.topologyMu.RUnlock()
+	return cluster.peerPicker.PickNode(key)
+}
+
+// pickOwners returns previous owner and current owner of key, previous owner is empty if no resharding is in progress
+func (cluster *ClusterDatabase) pickOwners(key string) (prev string, cur string) {
+	cluster.topologyMu.RLock()
+	defer cluster.topologyMu.RUnlock()
+	if cluster.prevPicker != nil {
+		prev = cluster.prevPicker.PickNode(key)
+	}
+	return prev, cluster.peerPicker.PickNode(key)
+}
+
+// getNodes returns all nodes, including nodes leaving the ring during resharding
+func (cluster *ClusterDatabase) getNodes() []string {
+	cluster.topologyMu.RLock()
+	defer cluster.topologyMu.RUnlock()
+	return unionNodes(cluster.nodes, cluster.prevNodes)
+}
+
+func (cluster *ClusterDatabase) getMigration() *migrationStatus {
+	cluster.topologyMu.RLock()
+	defer cluster.topologyMu.RUnlock()
+	return cluster.migration
+}
+
+// relayInternal relays internal command to peer, handler is called directly if the peer is current node
+func (cluster *ClusterDatabase) relayInternal(peer string, c redis.Connection, handler CmdFunc, args [][]byte) redis.Reply {
+	if peer == cluster.self {
+		return handler(cluster, c, args)
+	}
+	return cluster.relay(peer, c, args)
+}
+
+func isErrorOf(r redis.Reply, code string) bool {
+	return reply.IsErrorReply(r) && strings.HasPrefix(string(r.ToBytes()), "-"+code+" ")
+}
+
+func prependCmd(name string, args [][]byte) [][]byte {
+	result := make([][]byte, 0, len(args)+1)
+	result = append(result, []byte(name))
+	return append(result, args...)
+}
+
+// relayByKeys relays command to the node serving keys, all keys must be served by the same node
+// 迁移期间 key 先在旧节点上执行, 已迁走时再到新节点执行
+func (cluster *ClusterDatabase) relayByKeys(c redis.Connection, keys []string, args [][]byte) redis.Reply {
+	prev, peer := cluster.pickOwners(keys[0])
+	for _, key := range keys[1:] {
+		if p, _ := cluster.pickOwners(key); p != prev {
+			return tryAgainErrReply
+		}
+	}
+	if prev == "" || prev == peer {
+		return cluster.relay(peer, c, args)
+	}
+	tryArgs := prependCmd(relayTryExec, args)
+	for i := 0; ; i++ {
+		result := cluster.relayInternal(prev, c, execTryExec, tryArgs)
+		if isErrorOf(result, "MOVEDAWAY") {
+			return cluster.relayInternal(peer, c, execImportExec, prependCmd(relayImportExec, args))
+		}
+		if !isErrorOf(result, "TRYAGAIN") || i >= tryAgainRetries {
+			return result
+		}
+		time.Sleep(tryAgainInterval)
+	}
+}
+
+// execTryExec executes command on previous owner of keys, replies MOVEDAWAY if all keys have been migrated
+func execTryExec(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply(relayTryExec)
+	}
+	cmdLine := args[1:]
+	keys, ok := database2.GetRelatedKeys(cmdLine)
+	if !ok || len(keys) == 0 {
+		return cluster.db.Exec(c, cmdLine)
+	}
+	m := cluster.getMigration()
+	if m == nil {
+		// 迁移已经结束
+		if cluster.pickNode(keys[0]) == cluster.self {
+			return cluster.db.Exec(c, cmdLine)
+		}
+		return movedAwayErrReply
+	}
+	m.gate.RLock()
+	defer m.gate.RUnlock()
+	result, moved, total := cluster.db.ExecUnlessMoved(c, cmdLine, m.isMoved)
+	if moved == 0 {
+		return result
+	}
+	if moved < total {
+		return tryAgainErrReply
+	}
+	return movedAwayErrReply
+}
+
+// execImportExec executes command on new owner of keys without routing
+func execImportExec(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply(relayImportExec)
+	}
+	return cluster.db.Exec(c, args[1:])
+}
+
+// execClusterAdmin executes CLUSTER ADDNODE <addr> [weight], CLUSTER DELNODE <addr> or CLUSTER DISTRIBUTION,
+// current node coordinates the resharding
+func execClusterAdmin(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "addnode":
+		if len(args) != 3 && len(args) != 4 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'cluster|addnode' command")
+		}
+		weight := 0
+		if len(args) == 4 {
+			var err error
+			weight, err = strconv.Atoi(string(args[3]))
+			if err != nil || weight <= 0 {
+				return reply.MakeErrReply("ERR weight should be a positive integer")
+			}
+		}
+		return cluster.reshard(c, subCmd, string(args[2]), weight)
+	case "delnode":
+		if len(args) != 3 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'cluster|delnode' command")
+		}
+		return cluster.reshard(c, subCmd, string(args[2]), 0)
+	case "distribution":
+		if len(args) != 2 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'cluster|distribution' command")
+		}
+		return clusterDistribution(cluster)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLUSTER ADDNODE, DELNODE or DISTRIBUTION.")
+}
+
+// clusterDistribution replies weight, number of virtual nodes and share of hash ring of each node
+func clusterDistribution(cluster *ClusterDatabase) redis.Reply {
+	cluster.topologyMu.RLock()
+	stats := cluster.peerPicker.Stats()
+	cluster.topologyMu.RUnlock()
+	var buf strings.Builder
+	for _, stat := range stats {
+		buf.WriteString(fmt.Sprintf("%s weight:%d vnodes:%d share:%.2f%%\r\n", stat.Node, stat.Weight, stat.Points, stat.Share*100))
+	}
+	return reply.MakeBulkReply([]byte(buf.String()))
+}
+
+// reshard adds node to or removes node from the ring and migrates keys, weight 0 means using the recorded weight
+func (cluster *ClusterDatabase) reshard(c redis.Connection, action string, addr string, weight int) redis.Reply {
+	if !cluster.reshardMu.TryLock() {
+		return reply.MakeErrReply("ERR Resharding is in progress")
+	}
+	defer cluster.reshardMu.Unlock()
+
+	cluster.topologyMu.RLock()
+	oldNodes, curNodes := cluster.nodes, cluster.nodes
+	inTransition := cluster.prevNodes != nil
+	if inTransition {
+		oldNodes = cluster.prevNodes
+	}
+	weights := make(map[string]int)
+	for _, node := range unionNodes(oldNodes, []string{addr}) {
+		weights[node] = cluster.weightOf(node)
+	}
+	cluster.topologyMu.RUnlock()
+	if weight > 0 {
+		weights[addr] = weight
+	}
+	formatNode := func(node string) []byte {
+		return []byte(node + "=" + strconv.Itoa(weights[node]))
+	}
+
+	var newNodes []string
+	if action == "addnode" {
+		if containsNode(oldNodes, addr) {
+			return reply.MakeErrReply("ERR Node " + addr + " is already in the cluster")
+		}
+		newNodes = append(append(newNodes, oldNodes...), addr)
+	} else {
+		if !containsNode(oldNodes, addr) {
+			return reply.MakeErrReply("ERR Unknown node " + addr)
+		}
+		if len(oldNodes) == 1 {
+			return reply.MakeErrReply("ERR Can't remove the last node")
+		}
+		for _, node := range oldNodes {
+			if node != addr {
+				newNodes = append(newNodes, node)
+			}
+		}
+	}
+	// 上次重新分片未完成时, 只允许以相同的目标重试
+	if inTransition && !sameNodes(curNodes, newNodes) {
+		return reply.MakeErrReply("ERR Previous resharding is not finished, retry it first")
+	}
+
+	// 当前节点排在最前面, 先为新节点创建连接池
+	allNodes := unionNodes([]string{cluster.self}, unionNodes(oldNodes, newNodes))
+	ringArgs := utils.ToCmdLine(relaySetRing, strconv.Itoa(len(oldNodes)))
+	for _, node := range oldNodes {
+		ringArgs = append(ringArgs, formatNode(node))
+	}
+	for _, node := range newNodes {
+		ringArgs = append(ringArgs, formatNode(node))
+	}
+	for _, node := range allNodes {
+		result := cluster.relayInternal(node, c, execSetRing, ringArgs)
+		if reply.IsErrorReply(result) {
+			if !inTransition {
+				// 还没有开始迁移, 恢复已切换节点的旧环
+				cluster.finishRing(c, allNodes, true)
+			}
+			return reply.MakeErrReply("ERR Set ring on " + node + " failed: " + errMessage(result))
+		}
+	}
+
+	logger.Info("resharding: migrating keys from " + strings.Join(oldNodes, ",") + " to " + strings.Join(newNodes, ","))
+	startArgs := utils.ToCmdLine(relayMigrate, "start")
+	for _, node := range oldNodes {
+		result := cluster.relayInternal(node, c, execMigrate, startArgs)
+		if reply.IsErrorReply(result) {
+			return reply.MakeErrReply("ERR Migrate keys on " + node + " failed: " + errMessage(result))
+		}
+	}
+	statusArgs := utils.ToCmdLine(relayMigrate, "status")
+	pending := oldNodes
+	for len(pending) > 0 {
+		time.Sleep(migratePollInterval)
+		var running []string
+		for _, node := range pending {
+			result := cluster.relayInternal(node, c, execMigrate, statusArgs)
+			if reply.IsErrorReply(result) {
+				// 保持迁移状态, 读写仍然正确, 可以重新执行命令继续迁移
+				return reply.MakeErrReply("ERR Migrate keys on " + node + " failed: " + errMessage(result))
+			}
+			if string(result.ToBytes()) != "+done\r\n" {
+				running = append(running, node)
+			}
+		}
+		pending = running
+	}
+
+	cluster.finishRing(c, allNodes, false)
+	logger.Info("resharding finished, nodes: " + strings.Join(newNodes, ","))
+	return reply.MakeOkReply()
+}
+
+// finishRing sends _ringdone to nodes, abort restores the previous ring
+// 当前节点最后执行, 执行后会关闭离开节点的连接池
+func (cluster *ClusterDatabase) finishRing(c redis.Connection, nodes []string, abort bool) {
+	args := utils.ToCmdLine(relayRingDone)
+	if abort {
+		args = append(args, []byte("abort"))
+	}
+	ordered := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node != cluster.self {
+			ordered = append(ordered, node)
+		}
+	}
+	ordered = append(ordered, cluster.self)
+	for _, node := range ordered {
+		result := cluster.relayInternal(node, c, execRingDone, args)
+		if reply.IsErrorReply(result) {
+			logger.Warn("finish resharding on " + node + " failed: " + errMessage(result))
+		}
+	}
+}
+
+func errMessage(r redis.Reply) string {
+	return strings.TrimSuffix(strings.TrimPrefix(string(r.ToBytes()), "-"), "\r\n")
+}
+
+// execSetRing executes _setring <old-count> <old-node>... <new-node>..., current node uses the new ring and keeps the old one
+// 节点格式为 <addr>=<weight>, 所有节点使用发起节点记录的权重
+func execSetRing(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply(relaySetRing)
+	}
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil || count <= 0 || count >= len(args)-2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	var oldNodes, newNodes []string
+	weights := make(map[string]int)
+	for i, arg := range args[2:] {
+		node, weight, err := parseNodeWeight(string(arg))
+		if err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		weights[node] = weight
+		if i < count {
+			oldNodes = append(oldNodes, node)
+		} else {
+			newNodes = append(newNodes, node)
+		}
+	}
+
+	cluster.topologyMu.Lock()
+	defer cluster.topologyMu.Unlock()
+	if cluster.prevNodes != nil {
+		if sameNodes(cluster.prevNodes, oldNodes) && sameNodes(cluster.nodes, newNodes) {
+			return reply.MakeOkReply()
+		}
+		return reply.MakeErrReply("ERR Another resharding is in progress")
+	}
+	ctx := context.Background()
+	for _, node := range unionNodes(oldNodes, newNodes) {
+		if _, ok := cluster.peerConnection[node]; !ok && node != cluster.self {
+			cluster.peerConnection[node] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{
+				Peer: node,
+			})
+		}
+	}
+	for node, weight := range weights {
+		cluster.weights[node] = weight
+	}
+	cluster.prevNodes = oldNodes
+	cluster.prevPicker = cluster.makePicker(oldNodes)
+	cluster.nodes = newNodes
+	cluster.peerPicker = cluster.makePicker(newNodes)
+	cluster.migration = makeMigrationStatus()
+	return reply.MakeOkReply()
+}
+
+// execMigrate executes _migrate start|status, migration runs in background because it may take long
+func execMigrate(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply(relayMigrate)
+	}
+	m := cluster.getMigration()
+	if m == nil {
+		return reply.MakeErrReply("ERR No resharding in progress")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch strings.ToLower(string(args[1])) {
+	case "start":
+		// 失败后可以重新开始, 已迁走的 key 仍然记录在 moved 中
+		if m.started && (!m.done || m.err == nil) {
+			return reply.MakeOkReply()
+		}
+		m.started, m.done, m.err = true, false, nil
+		go cluster.migrate(m)
+		return reply.MakeOkReply()
+	case "status":
+		if !m.started || !m.done {
+			return reply.MakeStatusReply("running")
+		}
+		if m.err != nil {
+			return reply.MakeErrReply("ERR " + m.err.Error())
+		}
+		return reply.MakeStatusReply("done")
+	}
+	return reply.MakeSyntaxErrReply()
+}
+
+// migrate moves keys no longer served by current node to their new owners
+func (cluster *ClusterDatabase) migrate(m *migrationStatus) {
+	pick := func(key string) string {
+		prev, cur := cluster.pickOwners(key)
+		if prev == cluster.self && cur != cluster.self {
+			return cur
+		}
+		return ""
+	}
+	send := func(target string, dbIndex int, restoreCmd [][]byte) error {
+		peerClient, err := cluster.getPeerClient(target)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = cluster.returnPeerClient(target, peerClient)
+		}()
+		peerClient.Send(utils.ToCmdLine("select", strconv.Itoa(dbIndex)))
+		result := peerClient.Send(prependCmd(relayImportExec, restoreCmd))
+		if reply.IsErrorReply(result) {
+			return errors.New(target + ": " + errMessage(result))
+		}
+		m.markMoved(dbIndex, string(restoreCmd[1]))
+		return nil
+	}
+	err := cluster.db.MoveKeys(pick, send)
+	if err == nil {
+		// 封存后旧节点不再写入新的 key, 再遍历一次迁移封存前写入的 key
+		m.gate.Lock()
+		m.sealed = true
+		m.gate.Unlock()
+		err = cluster.db.MoveKeys(pick, send)
+	}
+	if err != nil {
+		logger.Error("migrate keys failed: " + err.Error())
+	}
+	m.mu.Lock()
+	m.done, m.err = true, err
+	m.mu.Unlock()
+}
+
+// execRingDone executes _ringdone [abort], current node drops the old ring, or restores it if aborted
+func execRingDone(cluster *ClusterDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	abort := len(args) == 2 && strings.ToLower(string(args[1])) == "abort"
+	if len(args) > 2 || len(args) == 2 && !abort {
+		return reply.MakeSyntaxErrReply()
+	}
+	cluster.topologyMu.Lock()
+	if cluster.prevNodes == nil {
+		cluster.topologyMu.Unlock()
+		return reply.MakeOkReply()
+	}
+	if abort {
+		cluster.nodes = cluster.prevNodes
+		cluster.peerPicker = cluster.prevPicker
+	}
+	cluster.prevNodes = nil
+	cluster.prevPicker = nil
+	cluster.migration = nil
+	// 关闭已离开集群的节点的连接池
+	var removed []*pool.ObjectPool
+	for node, p := range cluster.peerConnection {
+		if !containsNode(cluster.nodes, node) {
+			removed = append(removed, p)
+			delete(cluster.peerConnection, node)
+		}
+	}
+	cluster.topologyMu.Unlock()
+	for _, p := range removed {
+		p.Close(context.Background())
+	}
+	return reply.MakeOkReply()
+}
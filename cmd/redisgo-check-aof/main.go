@@ -0,0 +1,87 @@
+// redisgo-check-aof validates aof files and optionally truncates the invalid tail
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"redisgo/aof"
+	"strings"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [--fix] <file.manifest|file.aof>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the last aof file to the last valid command")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+
+	files := []string{filename}
+	if strings.HasSuffix(filename, ".manifest") {
+		var err error
+		files, err = aof.ManifestFiles(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read manifest: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for i, file := range files {
+		// 只有最后一个文件可能因崩溃而不完整, 其余文件出错无法修复
+		if !checkFile(file, *fix && i == len(files)-1) {
+			os.Exit(1)
+		}
+	}
+}
+
+// checkFile validates a single aof file, returns true if the file is valid or fixed
+func checkFile(filename string, fix bool) bool {
+	fmt.Printf("Checking %s\n", filename)
+	info, err := os.Stat(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot stat file: %v\n", err)
+		return false
+	}
+	size := info.Size()
+	var count int
+	err = aof.ReadFile(filename, func(cmdLine aof.CmdLine) bool {
+		count++
+		return true
+	})
+	if err == nil {
+		fmt.Printf("AOF analyzed: size=%d, ok_up_to=%d, diff=0, commands=%d\n", size, size, count)
+		fmt.Println("AOF is valid")
+		return true
+	}
+	formatErr, ok := err.(*aof.FormatError)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Failed to read file: %v\n", err)
+		return false
+	}
+
+	fmt.Println(formatErr.Error())
+	fmt.Printf("AOF analyzed: size=%d, ok_up_to=%d, diff=%d\n",
+		size, formatErr.ValidSize, size-formatErr.ValidSize)
+	if !fix {
+		fmt.Println("AOF is not valid. Use the --fix option to try fixing it.")
+		return false
+	}
+	if !formatErr.Truncated {
+		// 文件中间损坏时, 之后的所有命令都会丢失
+		fmt.Printf("This will discard %d bytes including commands after the corrupted position!\n",
+			size-formatErr.ValidSize)
+	}
+	if err := os.Truncate(filename, formatErr.ValidSize); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to truncate AOF: %v\n", err)
+		return false
+	}
+	fmt.Println("Successfully truncated AOF")
+	return true
+}
@@ -16,12 +16,49 @@ type ServerProperties struct {
     Port           int    `cfg:"port"`
     AppendOnly     bool   `cfg:"appendOnly"`
     AppendFilename string `cfg:"appendFilename"`
+    // AOF 的 base, incr 和 manifest 文件所在目录
+    AppendDirname  string `cfg:"appenddirname"`
+    // always, everysec or no
+    AppendFsync    string `cfg:"appendfsync"`
+    // 加载时 AOF 末尾命令不完整则截断, 否则拒绝启动
+    AofLoadTruncated bool `cfg:"aof-load-truncated"`
+    // 重写时 base 文件使用 RDB 格式, 加载更快
+    AofUseRdbPreamble bool `cfg:"aof-use-rdb-preamble"`
+    // AOF 比上次重写后增长超过该百分比且不小于最小大小时自动重写, 0 表示关闭
+    AutoAofRewritePercentage int `cfg:"auto-aof-rewrite-percentage"`
+    AutoAofRewriteMinSize    int `cfg:"auto-aof-rewrite-min-size"`
+    // RDB 快照文件名和自动保存规则, 如 "3600 1 300 100" 表示 3600 秒内至少1次修改或 300 秒内至少100次修改
+    DbFilename     string `cfg:"dbfilename"`
+    Save           string `cfg:"save"`
     MaxClients     int    `cfg:"maxclients"`
     RequirePass    string `cfg:"requirepass"`
+    AclFile        string `cfg:"aclfile"`
+    AclLogMaxLen   int    `cfg:"acllog-max-len"`
     Databases      int    `cfg:"databases"`
 
+    // 主从复制: replicaof 格式为 "<host> <port>", 连接主节点时使用 masteruser 和 masterauth 认证
+    ReplicaOf       string `cfg:"replicaof"`
+    MasterUser      string `cfg:"masteruser"`
+    MasterAuth      string `cfg:"masterauth"`
+    // 从节点拒绝客户端的写命令
+    ReplicaReadOnly bool   `cfg:"replica-read-only"`
+    // 复制积压缓冲区大小, 从节点断线重连时缺失的数据仍在其中则只需部分同步
+    ReplBacklogSize int    `cfg:"repl-backlog-size"`
+    // 延迟不超过 min-replicas-max-lag 秒的从节点少于 min-replicas-to-write 个时主节点拒绝写命令, 任一项为0表示关闭
+    MinReplicasToWrite int `cfg:"min-replicas-to-write"`
+    MinReplicasMaxLag  int `cfg:"min-replicas-max-lag"`
+
     Peers []string `cfg:"peers"`
     Self  string   `cfg:"self"`
+    // 使用 16384 个哈希槽分配 key, 返回 MOVED/ASK 由客户端重定向, 关闭时由节点转发命令
+    ClusterEnabled bool `cfg:"cluster-enabled"`
+    // 一致性哈希环上每个节点每单位权重的虚拟节点个数, 以及使用的哈希函数(crc32, fnv, murmur3), 集群中所有节点必须相同
+    ClusterReplicas int    `cfg:"cluster-replicas"`
+    ClusterHash     string `cfg:"cluster-hash"`
+    // 节点权重, 格式为 <addr>=<weight>, 多个之间用逗号分隔, 未配置的节点权重为1
+    NodeWeights []string `cfg:"node-weights"`
+    // 节点之间认证使用的共享密钥, 为空时节点之间不能执行内部命令, 开启 requirepass 或 aclfile 时也不能转发命令
+    ClusterSecret string `cfg:"cluster-secret"`
 }
 
 // Properties holds global config properties
@@ -33,11 +70,73 @@ func init() {
         Bind:       "127.0.0.1",
         Port:       6379,
         AppendOnly: false,
+        AppendFsync: defaultAppendFsync,
+        AppendFilename: defaultAppendFilename,
+        AppendDirname: defaultAppendDirname,
+        AofLoadTruncated: true,
+        AofUseRdbPreamble: true,
+        DbFilename: defaultDbFilename,
+        ReplicaReadOnly: true,
+        ReplBacklogSize: defaultReplBacklogSize,
+        MinReplicasMaxLag: defaultMinReplicasMaxLag,
+        ClusterReplicas: defaultClusterReplicas,
+        ClusterHash: defaultClusterHash,
+        AutoAofRewritePercentage: defaultAutoAofRewritePercentage,
+        AutoAofRewriteMinSize:    defaultAutoAofRewriteMinSize,
     }
 }
 
+const (
+    defaultAppendFilename           = "appendonly.aof"
+    defaultAppendDirname            = "appendonlydir"
+    defaultAppendFsync              = "everysec"
+    defaultDbFilename               = "dump.rdb"
+    defaultAutoAofRewritePercentage = 100
+    defaultAutoAofRewriteMinSize    = 64 << 20
+    defaultReplBacklogSize          = 1 << 20
+    defaultMinReplicasMaxLag        = 10
+    defaultClusterReplicas          = 160
+    defaultClusterHash              = "crc32"
+)
+
+// parseInt parses integer with optional unit, such as 64mb, 1gb, 100k
+func parseInt(value string) (int64, error) {
+    lower := strings.ToLower(value)
+    units := []struct {
+        suffix string
+        mul    int64
+    }{
+        {"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+        {"g", 1000 * 1000 * 1000}, {"m", 1000 * 1000}, {"k", 1000},
+    }
+    for _, unit := range units {
+        if strings.HasSuffix(lower, unit.suffix) {
+            n, err := strconv.ParseInt(lower[:len(lower)-len(unit.suffix)], 10, 64)
+            if err != nil {
+                return 0, err
+            }
+            return n * unit.mul, nil
+        }
+    }
+    return strconv.ParseInt(lower, 10, 64)
+}
+
 func parse(src io.Reader) *ServerProperties {
-    config := &ServerProperties{}
+    config := &ServerProperties{
+        AppendFsync:              defaultAppendFsync,
+        AppendFilename:           defaultAppendFilename,
+        AppendDirname:            defaultAppendDirname,
+        AofLoadTruncated:         true,
+        AofUseRdbPreamble:        true,
+        DbFilename:               defaultDbFilename,
+        ReplicaReadOnly:          true,
+        ReplBacklogSize:          defaultReplBacklogSize,
+        MinReplicasMaxLag:        defaultMinReplicasMaxLag,
+        ClusterReplicas:          defaultClusterReplicas,
+        ClusterHash:              defaultClusterHash,
+        AutoAofRewritePercentage: defaultAutoAofRewritePercentage,
+        AutoAofRewriteMinSize:    defaultAutoAofRewriteMinSize,
+    }
 
     // read config file
     rawMap := make(map[string]string)
@@ -51,7 +150,12 @@ func parse(src io.Reader) *ServerProperties {
         if pivot > 0 && pivot < len(line)-1 { // separator found
             key := line[0:pivot]
             value := strings.Trim(line[pivot+1:], " ")
-            rawMap[strings.ToLower(key)] = value
+            key = strings.ToLower(key)
+            if prev, ok := rawMap[key]; ok && key == "save" {
+                // 和 redis 一样允许多行 save 配置
+                value = prev + " " + value
+            }
+            rawMap[key] = value
         }
     }
     if err := scanner.Err(); err != nil {
@@ -76,7 +180,7 @@ func parse(src io.Reader) *ServerProperties {
             case reflect.String:
                 fieldVal.SetString(value)
             case reflect.Int:
-                intValue, err := strconv.ParseInt(value, 10, 64)
+                intValue, err := parseInt(value)
                 if err == nil {
                     fieldVal.SetInt(intValue)
                 }
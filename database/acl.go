@@ -0,0 +1,306 @@
+package database
+
+import (
+	"net"
+	"redisgo/acl"
+	"redisgo/config"
+	"redisgo/interface/redis"
+	"redisgo/lib/logger"
+	"redisgo/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func isCommand(name string) bool {
+	_, ok := cmdTable[name]
+	return ok
+}
+
+// makeACL creates ACL from config, users in aclfile replace the default user made from requirepass
+func makeACL() *acl.ACL {
+	a := acl.MakeACL(isCommand, config.Properties.RequirePass, config.Properties.AclLogMaxLen)
+	if config.Properties.AclFile != "" {
+		if config.Properties.RequirePass != "" {
+			logger.Warn("requirepass is ignored for users defined in aclfile")
+		}
+		if err := a.LoadFile(config.Properties.AclFile); err != nil {
+			logger.Warn("load aclfile failed: " + err.Error())
+		}
+	}
+	return a
+}
+
+func aclContext(c redis.Connection) string {
+	if c.InMultiState() {
+		return "multi"
+	}
+	return "toplevel"
+}
+
+func clientInfo(c redis.Connection) string {
+	if conn, ok := c.(interface{ RemoteAddr() net.Addr }); ok && conn.RemoteAddr() != nil {
+		return "addr=" + conn.RemoteAddr().String()
+	}
+	return ""
+}
+
+// channelsOf returns channels used by pub/sub commands, patterns of PSUBSCRIBE are returned separately
+func channelsOf(cmdName string, args [][]byte) (channels []string, patterns []string) {
+	switch cmdName {
+	case "subscribe", "ssubscribe":
+		for _, arg := range args {
+			channels = append(channels, string(arg))
+		}
+	case "publish", "spublish":
+		channels = []string{string(args[0])}
+	case "psubscribe":
+		for _, arg := range args {
+			patterns = append(patterns, string(arg))
+		}
+	}
+	return
+}
+
+// CheckAccess checks authentication and ACL permissions before executing command, returns nil if passed
+// 被拒绝的命令记录到 ACL LOG, 事务中被拒绝会导致 EXEC 放弃执行
+func (database *StandaloneDatabase) CheckAccess(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if cmdName == "auth" || cmdName == "hello" || cmdName == "quit" {
+		return nil
+	}
+	if !database.IsAuthenticated(c) {
+		return noAuthErrReply
+	}
+	// 集群节点转发的命令已经在入口节点检查过
+	if isInternalConn(c) || c.IsClusterPeer() {
+		return nil
+	}
+	// 不认识的命令默认拒绝, 内部命令也不能绕过检查
+	cmd, ok := cmdTable[cmdName]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		var errReply reply.ErrorReply = reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		if ok {
+			errReply = reply.MakeArgNumErrReply(cmdName)
+		}
+		if c.InMultiState() {
+			c.AddTxError(errReply)
+		}
+		return errReply
+	}
+	user := database.currentUser(c)
+	deny := func(reason string, object string, errReply redis.Reply) redis.Reply {
+		database.acl.Log().Add(reason, aclContext(c), object, user.Name, clientInfo(c))
+		if c.InMultiState() {
+			c.AddTxError(errReply.(reply.ErrorReply))
+		}
+		return errReply
+	}
+	if !user.CanExecute(cmdName, cmd.categories) {
+		return deny("command", cmdName, reply.MakeErrReply("NOPERM User "+user.Name+
+			" has no permissions to run the '"+cmdName+"' command"))
+	}
+
+	args := cmdLine[1:]
+	var keys []string
+	if cmdName == "watch" {
+		for _, arg := range args {
+			keys = append(keys, string(arg))
+		}
+	} else {
+		write, read := cmd.prepare(args)
+		keys = append(write, read...)
+	}
+	for _, key := range keys {
+		if !user.CanAccessKey(key) {
+			return deny("key", key, reply.MakeErrReply("NOPERM No permissions to access a key"))
+		}
+	}
+
+	channels, patterns := channelsOf(cmdName, args)
+	for _, channel := range channels {
+		if !user.CanAccessChannel(channel) {
+			return deny("channel", channel, reply.MakeErrReply("NOPERM No permissions to access a channel"))
+		}
+	}
+	for _, p := range patterns {
+		if !user.CanAccessPattern(p) {
+			return deny("channel", p, reply.MakeErrReply("NOPERM No permissions to access a channel"))
+		}
+	}
+	return nil
+}
+
+// execACL executes ACL SETUSER/GETUSER/DELUSER/LIST/USERS/WHOAMI/CAT/LOG/SAVE/LOAD
+func execACL(database *StandaloneDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch subCmd {
+	case "setuser":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply("acl|setuser")
+		}
+		rules := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			rules[i] = string(arg)
+		}
+		if err := database.acl.SetUser(string(args[0]), rules); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeOkReply()
+	case "getuser":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("acl|getuser")
+		}
+		user, ok := database.acl.GetUser(string(args[0]))
+		if !ok {
+			return reply.MakeNullMultiBulkReply()
+		}
+		return reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte("flags")),
+			reply.MakeMultiBulkReply(toBytesSlice(user.Flags())),
+			reply.MakeBulkReply([]byte("passwords")),
+			reply.MakeMultiBulkReply(toBytesSlice(user.PasswordHashes())),
+			reply.MakeBulkReply([]byte("commands")),
+			reply.MakeBulkReply([]byte(user.DescribeCommands())),
+			reply.MakeBulkReply([]byte("keys")),
+			reply.MakeBulkReply([]byte(user.DescribeKeys())),
+			reply.MakeBulkReply([]byte("channels")),
+			reply.MakeBulkReply([]byte(user.DescribeChannels())),
+		})
+	case "deluser":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply("acl|deluser")
+		}
+		count, err := database.acl.DelUsers(toStrings(args))
+		if err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeIntReply(int64(count))
+	case "list":
+		return reply.MakeMultiBulkReply(toBytesSlice(database.acl.List()))
+	case "users":
+		return reply.MakeMultiBulkReply(toBytesSlice(database.acl.Users()))
+	case "whoami":
+		name := acl.DefaultUser
+		if c.IsAuthenticated() && c.GetUserName() != "" {
+			name = c.GetUserName()
+		}
+		return reply.MakeBulkReply([]byte(name))
+	case "cat":
+		return execACLCat(args)
+	case "log":
+		return execACLLog(database, args)
+	case "save":
+		if config.Properties.AclFile == "" {
+			return reply.MakeErrReply("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		if err := database.acl.SaveFile(config.Properties.AclFile); err != nil {
+			return reply.MakeErrReply("ERR There was an error trying to save the ACLs. Please check the server logs for more information")
+		}
+		return reply.MakeOkReply()
+	case "load":
+		if config.Properties.AclFile == "" {
+			return reply.MakeErrReply("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		if err := database.acl.LoadFile(config.Properties.AclFile); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try ACL HELP.")
+}
+
+// execACLCat lists all categories, or commands in the given category
+func execACLCat(args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return reply.MakeMultiBulkReply(toBytesSlice(acl.Categories))
+	}
+	if len(args) > 1 {
+		return reply.MakeArgNumErrReply("acl|cat")
+	}
+	category := strings.ToLower(string(args[0]))
+	if !acl.IsCategory(category) || category == "all" {
+		return reply.MakeErrReply("ERR Unknown category '" + category + "'")
+	}
+	names := make([]string, 0)
+	for name, cmd := range cmdTable {
+		for _, cat := range cmd.categories {
+			if cat == category {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return reply.MakeMultiBulkReply(toBytesSlice(names))
+}
+
+// execACLLog executes ACL LOG [count | RESET]
+func execACLLog(database *StandaloneDatabase, args [][]byte) redis.Reply {
+	count := 10
+	if len(args) > 1 {
+		return reply.MakeArgNumErrReply("acl|log")
+	}
+	if len(args) == 1 {
+		if strings.ToLower(string(args[0])) == "reset" {
+			database.acl.Log().Reset()
+			return reply.MakeOkReply()
+		}
+		n, err := strconv.Atoi(string(args[0]))
+		if err != nil || n < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	now := time.Now()
+	entries := database.acl.Log().Entries(count)
+	result := make([]redis.Reply, len(entries))
+	for i, entry := range entries {
+		age := now.Sub(entry.Created).Seconds()
+		result[i] = reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte("count")),
+			reply.MakeIntReply(int64(entry.Count)),
+			reply.MakeBulkReply([]byte("reason")),
+			reply.MakeBulkReply([]byte(entry.Reason)),
+			reply.MakeBulkReply([]byte("context")),
+			reply.MakeBulkReply([]byte(entry.Context)),
+			reply.MakeBulkReply([]byte("object")),
+			reply.MakeBulkReply([]byte(entry.Object)),
+			reply.MakeBulkReply([]byte("username")),
+			reply.MakeBulkReply([]byte(entry.Username)),
+			reply.MakeBulkReply([]byte("age-seconds")),
+			reply.MakeBulkReply([]byte(strconv.FormatFloat(age, 'f', 3, 64))),
+			reply.MakeBulkReply([]byte("client-info")),
+			reply.MakeBulkReply([]byte(entry.ClientInfo)),
+			reply.MakeBulkReply([]byte("entry-id")),
+			reply.MakeIntReply(entry.EntryID),
+			reply.MakeBulkReply([]byte("timestamp-created")),
+			reply.MakeIntReply(entry.Created.UnixMilli()),
+			reply.MakeBulkReply([]byte("timestamp-last-updated")),
+			reply.MakeIntReply(entry.Updated.UnixMilli()),
+		})
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func toBytesSlice(strs []string) [][]byte {
+	result := make([][]byte, len(strs))
+	for i, s := range strs {
+		result[i] = []byte(s)
+	}
+	return result
+}
+
+func toStrings(args [][]byte) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = string(arg)
+	}
+	return result
+}
+
+func init() {
+	registerSpecialCommand("ACL", -2, "admin", "slow", "dangerous")
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"redisgo/acl"
+	"redisgo/interface/redis"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+)
+
+var noAuthErrReply = reply.MakeErrReply("NOAUTH Authentication required.")
+
+var wrongPassErrReply = reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+
+// isInternalConn tells whether the connection is created by server itself, such as loading aof
+// 内部连接已认证但没有用户名, 不做权限检查
+func isInternalConn(c redis.Connection) bool {
+	return c.IsAuthenticated() && c.GetUserName() == ""
+}
+
+// currentUser returns the ACL user of connection, nil if the user has been deleted
+// 未认证的连接使用 default 用户
+func (database *StandaloneDatabase) currentUser(c redis.Connection) *acl.User {
+	name := acl.DefaultUser
+	if c.IsAuthenticated() {
+		name = c.GetUserName()
+	}
+	user, ok := database.acl.GetUser(name)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+// IsAuthenticated tells whether the connection is allowed to execute commands
+// default 用户为 nopass 时所有连接都视为已认证
+func (database *StandaloneDatabase) IsAuthenticated(c redis.Connection) bool {
+	if isInternalConn(c) || c.IsClusterPeer() {
+		return true
+	}
+	user := database.currentUser(c)
+	if user == nil || !user.IsEnabled() {
+		return false
+	}
+	return c.IsAuthenticated() || user.NoPass()
+}
+
+// Auth validates password of client, supports AUTH password and AUTH username password
+func (database *StandaloneDatabase) Auth(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeArgNumErrReply("auth")
+	}
+	user := acl.DefaultUser
+	password := string(args[0])
+	if len(args) == 2 {
+		user = string(args[0])
+		password = string(args[1])
+	}
+	if len(args) == 1 {
+		if defaultUser, ok := database.acl.GetUser(acl.DefaultUser); ok && defaultUser.NoPass() {
+			return reply.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+	}
+	return database.authenticate(c, user, password)
+}
+
+// authenticate switches user of connection, the connection keeps its user if failed
+func (database *StandaloneDatabase) authenticate(c redis.Connection, user string, password string) redis.Reply {
+	if !database.acl.Authenticate(user, password) {
+		database.acl.Log().Add("auth", aclContext(c), "AUTH", user, clientInfo(c))
+		return wrongPassErrReply
+	}
+	c.SetAuthenticated(true)
+	c.SetUserName(user)
+	return reply.MakeOkReply()
+}
+
+// Hello executes HELLO [protover [AUTH username password] [SETNAME clientname]], only RESP2 is supported
+func (database *StandaloneDatabase) Hello(c redis.Connection, args [][]byte, mode string) redis.Reply {
+	if len(args) > 0 {
+		protoVer, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return reply.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if protoVer != 2 {
+			return reply.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		for i := 1; i < len(args); i++ {
+			opt := strings.ToLower(string(args[i]))
+			switch {
+			case opt == "auth" && i+2 < len(args):
+				r := database.authenticate(c, string(args[i+1]), string(args[i+2]))
+				if reply.IsErrorReply(r) {
+					return r
+				}
+				i += 2
+			case opt == "setname" && i+1 < len(args):
+				i++ // 暂不支持客户端名称, 忽略
+			default:
+				return reply.MakeSyntaxErrReply()
+			}
+		}
+	}
+	if !database.IsAuthenticated(c) {
+		return noAuthErrReply
+	}
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("server")),
+		reply.MakeBulkReply([]byte("redis")),
+		reply.MakeBulkReply([]byte("proto")),
+		reply.MakeIntReply(2),
+		reply.MakeBulkReply([]byte("mode")),
+		reply.MakeBulkReply([]byte(mode)),
+		reply.MakeBulkReply([]byte("role")),
+		reply.MakeBulkReply([]byte("master")),
+	})
+}
+
+func init() {
+	registerSpecialCommand("Auth", -2, "connection", "fast")
+	registerSpecialCommand("Hello", -1, "connection", "fast")
+	registerSpecialCommand("Quit", 1, "connection", "fast")
+}
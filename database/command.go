@@ -1,18 +1,124 @@
 package database
 
-import "strings"
+import (
+	"redisgo/interface/redis"
+	"strings"
+)
 
 var cmdTable = make(map[string]*command)
 
 type command struct {
 	executor ExecFunc
+	prepare  PreFunc // 返回命令涉及的读写key, 执行前加锁
 	arity    int
+	// ACL 分类, 如 read, write, string, fast
+	categories []string
 }
 
-func RegisterCommand(name string, executor ExecFunc, arity int) {
+func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, arity int, categories ...string) {
 	name = strings.ToLower(name)
 	cmdTable[name] = &command{
-		executor: executor,
-		arity: arity,
+		executor:   executor,
+		prepare:    prepare,
+		arity:      arity,
+		categories: categories,
 	}
-}
\ No newline at end of file
+}
+
+// registerSpecialCommand registers commands which are executed by StandaloneDatabase rather than DB,
+// such as MULTI and SUBSCRIBE, only arity and categories are recorded for ACL
+func registerSpecialCommand(name string, arity int, categories ...string) {
+	RegisterCommand(name, nil, noPrepare, arity, categories...)
+}
+
+// isSpecialCommand tells whether the command can't be executed by DB
+func isSpecialCommand(cmd *command) bool {
+	return cmd.executor == nil
+}
+
+// isExclusiveCommand tells whether the command modifies keys without locking them,
+// it must not run concurrently with other write commands
+func isExclusiveCommand(name string) bool {
+	return name == "flushdb"
+}
+
+// isWriteCommand tells whether the command may modify data
+func isWriteCommand(name string) bool {
+	cmd, ok := cmdTable[name]
+	if !ok {
+		return false
+	}
+	for _, category := range cmd.categories {
+		if category == "write" {
+			return true
+		}
+	}
+	return false
+}
+
+// writesData tells whether executing the command modifies data, EXEC writes if any queued command writes
+func writesData(c redis.Connection, cmdName string) bool {
+	if cmdName == "exec" && c.InMultiState() {
+		for _, cmdLine := range c.GetQueuedCmdLine() {
+			if isWriteCommand(strings.ToLower(string(cmdLine[0]))) {
+				return true
+			}
+		}
+		return false
+	}
+	return isWriteCommand(cmdName)
+}
+
+// GetRelatedKeys returns keys accessed by the command, channels of sharded pub/sub are regarded as keys
+// 命令不存在或参数个数错误时 ok 为 false
+func GetRelatedKeys(cmdLine [][]byte) (keys []string, ok bool) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		return nil, false
+	}
+	args := cmdLine[1:]
+	switch cmdName {
+	case "watch", "ssubscribe", "sunsubscribe":
+		for _, arg := range args {
+			keys = append(keys, string(arg))
+		}
+		return keys, true
+	case "spublish":
+		return []string{string(args[0])}, true
+	}
+	write, read := cmd.prepare(args)
+	return append(write, read...), true
+}
+
+/* ---- prepare functions ---- */
+
+func noPrepare(args [][]byte) ([]string, []string) {
+	return nil, nil
+}
+
+func writeFirstKey(args [][]byte) ([]string, []string) {
+	key := string(args[0])
+	return []string{key}, nil
+}
+
+func readFirstKey(args [][]byte) ([]string, []string) {
+	key := string(args[0])
+	return nil, []string{key}
+}
+
+func writeAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return keys, nil
+}
+
+func readAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return nil, keys
+}
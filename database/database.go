@@ -1,50 +1,129 @@
 package database
 
 import (
+	"redisgo/acl"
 	"redisgo/aof"
 	"redisgo/config"
+	dbinterface "redisgo/interface/database"
 	"redisgo/interface/redis"
 	"redisgo/lib/logger"
+	"redisgo/pubsub"
 	"redisgo/redis/reply"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type StandaloneDatabase struct { // 核心
 	dbSet      []*DB
 	aofHandler *aof.AofHandler
+	hub        *pubsub.Hub
+	acl        *acl.ACL
+
+	saveRules []saveRule
+	// 保存RDB期间持有, 同一时间只允许一个保存任务
+	savingMu sync.Mutex
+	// unix time of last successful save
+	lastSave int64
+	// unix time of last failed background save, 0 if it succeeded
+	lastBgSaveFailed int64
+	stopSaveCron     chan struct{}
+
+	// 写命令执行期间持有读锁, 开始快照时短暂地持有写锁, 保证快照是同一时刻的数据并且和复制偏移量一致
+	writeBarrier sync.RWMutex
+	// 同一时间只有一个快照, 保存 RDB 和全量同步共用
+	snapshotMu sync.Mutex
+	// TCC 事务从 try 到提交或回滚期间持有读锁, FLUSHDB 持有写锁, 不会清空还没结束的事务
+	txBarrier sync.RWMutex
+	master    *masterStatus
+	replMu    sync.Mutex
+	// 作为从节点时的复制状态, nil 表示是主节点
+	slave *slaveStatus
 }
 
 func NewStandaloneDataBase() *StandaloneDatabase {
 	database := &StandaloneDatabase{}
+	database.hub = pubsub.MakeHub()
+	database.acl = makeACL()
 	if config.Properties.Databases == 0 {
 		config.Properties.Databases = 16
 	}
 
-	database.dbSet = make([]*DB, config.Properties.Databases)
-	// 初始化DB
-	for i := range database.dbSet {
-		db := makeDB()
-		db.index = i
-		database.dbSet[i] = db
-	}
+	database.dbSet = makeDBSet(false)
+	database.master = makeMasterStatus()
 
 	if config.Properties.AppendOnly {
-		aofHandler, err := aof.NewAOFHandler(database)
+		aofHandler, err := aof.NewAOFHandler(database, func() dbinterface.DBEngine {
+			return makeTmpDatabase()
+		})
 		if err != nil {
 			panic(err)
 		}
 		database.aofHandler = aofHandler
-		for _, db := range database.dbSet {
-			singleDB := db // 局部变量，避免闭包
-			singleDB.addAof = func(cmdline CmdLine) {
-				database.aofHandler.AddAof(singleDB.index, cmdline)
+	} else if err := database.loadRDB(config.Properties.DbFilename); err != nil {
+		panic(err)
+	}
+	// 加载完成后再设置, 加载的数据不会重复写入AOF, 也不计入修改次数
+	for _, db := range database.dbSet {
+		singleDB := db // 局部变量，避免闭包
+		singleDB.addAof = func(cmdLines ...CmdLine) {
+			singleDB.addDirty(len(cmdLines))
+			if database.aofHandler != nil {
+				database.aofHandler.AddAof(singleDB.index, cmdLines...)
 			}
+			database.master.propagate(singleDB.index, cmdLines...)
+		}
+	}
+	database.lastSave = time.Now().Unix()
+	database.saveRules = parseSaveRules(config.Properties.Save)
+	if len(database.saveRules) > 0 {
+		database.startSaveCron()
+	}
+	database.master.startReplCron()
+	if config.Properties.ReplicaOf != "" {
+		if host, port, ok := parseReplicaOf(config.Properties.ReplicaOf); ok {
+			database.startReplication(host, port)
+		} else {
+			logger.Warn("invalid replicaof config: " + config.Properties.ReplicaOf)
 		}
 	}
 	return database
 }
 
+// makeDBSet creates DBs according to config, passive DBs don't register time wheel tasks
+func makeDBSet(passive bool) []*DB {
+	dbSet := make([]*DB, config.Properties.Databases)
+	// 初始化DB
+	for i := range dbSet {
+		db := makeDB()
+		db.index = i
+		db.passive = passive
+		dbSet[i] = db
+	}
+	return dbSet
+}
+
+// makeTmpDatabase creates a database without aof and active expiration, such as rebuilding data in aof rewrite
+func makeTmpDatabase() *StandaloneDatabase {
+	return &StandaloneDatabase{
+		dbSet: makeDBSet(true),
+		hub:   pubsub.MakeHub(),
+		acl:   acl.MakeACL(isCommand, "", 0),
+	}
+}
+
+// ForEach traverses all keys in the given DB
+func (database *StandaloneDatabase) ForEach(dbIndex int, cb func(key string, entity *dbinterface.DataEntity, expiration *time.Time) bool) {
+	database.dbSet[dbIndex].ForEach(cb)
+}
+
+// KeyExists tells whether the key exists and not expired in the given DB
+func (database *StandaloneDatabase) KeyExists(dbIndex int, key string) bool {
+	_, ok := database.dbSet[dbIndex].GetEntity(key)
+	return ok
+}
+
 func (database *StandaloneDatabase) Exec(client redis.Connection, args [][]byte) redis.Reply {
 	defer func() {
 		if err := recover(); err != nil {
@@ -53,24 +132,255 @@ func (database *StandaloneDatabase) Exec(client redis.Connection, args [][]byte)
 	}()
 
 	cmdName := strings.ToLower(string(args[0]))
-	if cmdName == "select" {
-		if len(args) != 2 {
+	switch cmdName {
+	case "auth":
+		return database.Auth(client, args[1:])
+	case "hello":
+		return database.Hello(client, args[1:], "standalone")
+	}
+	if errReply := database.CheckAccess(client, args); errReply != nil {
+		return errReply
+	}
+	if isWriteCommand(cmdName) && database.isReadOnlyFor(client) {
+		errReply := reply.MakeErrReply("READONLY You can't write against a read only replica.")
+		if client.InMultiState() {
+			client.AddTxError(errReply)
+		}
+		return errReply
+	}
+	if !isInternalConn(client) && writesData(client, cmdName) && !database.hasEnoughReplicas() {
+		errReply := reply.MakeErrReply("NOREPLICAS Not enough good replicas to write.")
+		if cmdName == "exec" {
+			DiscardMulti(client)
+		} else if client.InMultiState() {
+			client.AddTxError(errReply)
+		}
+		return errReply
+	}
+	// 订阅模式下只允许执行订阅相关的命令
+	if client.SubsCount()+client.PSubsCount()+client.SSubsCount() > 0 {
+		return execInSubscribeMode(database, client, cmdName, args)
+	}
+	switch cmdName {
+	case "subscribe":
+		if len(args) < 2 {
 			return reply.MakeArgNumErrReply(cmdName)
 		}
-		return execSelect(client, database, args[1:])
+		return pubsub.Subscribe(database.hub, client, args[1:])
+	case "psubscribe":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return pubsub.PSubscribe(database.hub, client, args[1:])
+	case "unsubscribe":
+		return pubsub.UnSubscribe(database.hub, client, args[1:])
+	case "punsubscribe":
+		return pubsub.PUnSubscribe(database.hub, client, args[1:])
+	case "ssubscribe":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return pubsub.SSubscribe(database.hub, client, args[1:])
+	case "sunsubscribe":
+		return pubsub.SUnSubscribe(database.hub, client, args[1:])
+	case "publish":
+		return pubsub.Publish(database.hub, args[1:])
+	case "spublish":
+		return pubsub.SPublish(database.hub, args[1:])
+	case "pubsub":
+		return pubsub.PubSub(database.hub, args[1:])
+	case "bgrewriteaof":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execBGRewriteAOF(database)
+	case "save":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execSave(database)
+	case "bgsave":
+		return execBGSave(database, args[1:])
+	case "lastsave":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execLastSave(database)
+	case "replicaof", "slaveof":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execReplicaOf(database, args[1:])
+	case "psync":
+		if len(args) < 3 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execPSync(database, client, args[1:])
+	case "sync":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execSync(database, client)
+	case "replconf":
+		return execReplConf(database, client, args[1:])
+	case "wait":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execWait(database, args[1:])
+	case "role":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execRole(database)
+	case "cluster", "asking", "readonly", "readwrite":
+		return reply.MakeErrReply("ERR This instance has cluster support disabled")
+	case "acl":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execACL(database, client, args[1:])
 	}
 
 	i := client.GetDBIndex()
 	db := database.dbSet[i]
+	// 事务相关的命令不进入命令表, 直接在这里处理
+	switch cmdName {
+	case "multi":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return StartMulti(client)
+	case "discard":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return DiscardMulti(client)
+	case "exec":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		if hasExclusiveCommand(client.GetQueuedCmdLine()) {
+			defer database.lockExclusive()()
+		} else {
+			database.writeBarrier.RLock()
+			defer database.writeBarrier.RUnlock()
+		}
+		return execMulti(db, client)
+	case "watch":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		if client.InMultiState() {
+			return reply.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+		}
+		return Watch(db, client, args[1:])
+	case "unwatch":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		if client.InMultiState() { // 和redis一样, 事务中的 UNWATCH 没有实际作用
+			return reply.MakeQueuedReply()
+		}
+		return UnWatch(client)
+	case "select":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		if client.InMultiState() {
+			errReply := reply.MakeErrReply("ERR SELECT inside MULTI is not supported")
+			client.AddTxError(errReply)
+			return errReply
+		}
+		return execSelect(client, database, args[1:])
+	}
+
+	if client.InMultiState() {
+		return EnqueueCmd(client, args)
+	}
+	if isExclusiveCommand(cmdName) {
+		// FLUSHDB 不锁定 key, 独占写屏障, 避免和其它写命令以及 EXEC 的版本检查交错执行
+		defer database.lockExclusive()()
+	} else if isWriteCommand(cmdName) {
+		database.writeBarrier.RLock()
+		defer database.writeBarrier.RUnlock()
+	}
 	return db.Exec(client, args)
 }
 
-func (database *StandaloneDatabase) Close() {
+// lockExclusive waits for in-flight TCC transactions and write commands, returns the function to unlock
+func (database *StandaloneDatabase) lockExclusive() func() {
+	database.txBarrier.Lock()
+	database.writeBarrier.Lock()
+	return func() {
+		database.writeBarrier.Unlock()
+		database.txBarrier.Unlock()
+	}
+}
 
+func hasExclusiveCommand(cmdLines []CmdLine) bool {
+	for _, cmdLine := range cmdLines {
+		if isExclusiveCommand(strings.ToLower(string(cmdLine[0]))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (database *StandaloneDatabase) Close() {
+	if s := database.getSlave(); s != nil {
+		s.stop()
+	}
+	if database.master != nil {
+		database.master.close()
+	}
+	database.closeRDB()
+	if database.aofHandler != nil {
+		database.aofHandler.Close()
+	}
 }
 
 func (database *StandaloneDatabase) AfterClientClose(c redis.Connection) {
+	pubsub.UnsubscribeAll(database.hub, c)
+	if database.master != nil {
+		database.master.removeReplica(c)
+	}
+}
 
+// execInSubscribeMode executes commands of connection which subscribed channels or patterns
+func execInSubscribeMode(database *StandaloneDatabase, c redis.Connection, cmdName string, args [][]byte) redis.Reply {
+	switch cmdName {
+	case "subscribe", "psubscribe", "ssubscribe":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+	}
+	switch cmdName {
+	case "subscribe":
+		return pubsub.Subscribe(database.hub, c, args[1:])
+	case "psubscribe":
+		return pubsub.PSubscribe(database.hub, c, args[1:])
+	case "unsubscribe":
+		return pubsub.UnSubscribe(database.hub, c, args[1:])
+	case "punsubscribe":
+		return pubsub.PUnSubscribe(database.hub, c, args[1:])
+	case "ssubscribe":
+		return pubsub.SSubscribe(database.hub, c, args[1:])
+	case "sunsubscribe":
+		return pubsub.SUnSubscribe(database.hub, c, args[1:])
+	case "ping":
+		// 订阅模式下 PING 以数组形式回复
+		if len(args) > 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		msg := []byte("")
+		if len(args) == 2 {
+			msg = args[1]
+		}
+		return reply.MakeMultiBulkReply([][]byte{[]byte("pong"), msg})
+	}
+	return reply.MakeErrReply("ERR Can't execute '" + cmdName +
+		"': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context")
 }
 
 // 执行select命令
@@ -85,3 +395,33 @@ func execSelect(c redis.Connection, database *StandaloneDatabase, args [][]byte)
 	c.SelectDB(dbIndex)
 	return reply.MakeOkReply()
 }
+
+// execBGRewriteAOF starts aof rewrite in background
+func execBGRewriteAOF(database *StandaloneDatabase) redis.Reply {
+	if database.aofHandler == nil {
+		return reply.MakeErrReply("ERR Append only file is not enabled")
+	}
+	if err := database.aofHandler.BGRewrite(); err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeStatusReply("Background append only file rewriting started")
+}
+
+func init() {
+	registerSpecialCommand("BGRewriteAOF", 1, "admin", "slow", "dangerous")
+	// 集群命令由 ClusterDatabase 处理, 这里只登记用于权限检查
+	registerSpecialCommand("Cluster", -2, "admin", "slow", "dangerous")
+	registerSpecialCommand("Asking", 1, "fast")
+	registerSpecialCommand("ReadOnly", 1, "connection", "fast")
+	registerSpecialCommand("ReadWrite", 1, "connection", "fast")
+	registerSpecialCommand("Select", 2, "connection", "fast")
+	registerSpecialCommand("Subscribe", -2, "pubsub", "slow")
+	registerSpecialCommand("PSubscribe", -2, "pubsub", "slow")
+	registerSpecialCommand("Unsubscribe", -1, "pubsub", "slow")
+	registerSpecialCommand("PUnsubscribe", -1, "pubsub", "slow")
+	registerSpecialCommand("SSubscribe", -2, "pubsub", "slow")
+	registerSpecialCommand("SUnsubscribe", -1, "pubsub", "slow")
+	registerSpecialCommand("Publish", 3, "pubsub", "fast")
+	registerSpecialCommand("SPublish", 3, "pubsub", "fast")
+	registerSpecialCommand("PubSub", -2, "pubsub", "slow")
+}
@@ -4,27 +4,60 @@ import (
 	"redisgo/datastruct/dict"
 	"redisgo/interface/database"
 	"redisgo/interface/redis"
+	"redisgo/lib/sync/lock"
+	"redisgo/lib/timewheel"
 	"redisgo/redis/reply"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dataDictSize = 1 << 16
+	ttlDictSize  = 1 << 10
+	versionDictSize = 1 << 10
+	lockerSize   = 1024
 )
 
 // DB stores data and execute user's commands
 type DB struct {
 	index int
 	data  dict.Dict
-	addAof func(CmdLine)
+	// key -> expireTime (time.Time)
+	ttlMap dict.Dict
+	// key -> version(uint32), 写命令修改数据时递增, 用于 WATCH
+	versionMap dict.Dict
+	// 执行命令前对涉及的key加锁, 保证读-改-写的原子性
+	locker *lock.Locks
+	// 一次调用传入多条命令时保证它们在AOF中连续写入
+	addAof func(...CmdLine)
+	// 上次保存RDB后的修改次数, 用于触发自动保存
+	dirty int64
+	// 临时DB(如重写AOF时使用)不注册时间轮任务, 过期key只做惰性删除
+	// 否则会和正式DB中同名key的任务冲突
+	passive bool
+	// 正在进行的快照, 修改 key 之前需要保存快照时刻的值
+	snapshot *snapshotSlot
 }
 
 // CmdLine is alias for [][]byte, represents a command line
 type ExecFunc func(db *DB, args [][]byte) redis.Reply
 
+// PreFunc analyses command line and returns related write keys and read keys
+type PreFunc func(args [][]byte) ([]string, []string)
+
 type CmdLine = [][]byte
 
 // makeDB create DB instance
 func makeDB() *DB {
 	db := &DB{
-		data: dict.MakeSyncDict(),
-		addAof: func(line CmdLine){},
+		data: dict.MakeConcurrent(dataDictSize),
+		ttlMap: dict.MakeConcurrent(ttlDictSize),
+		versionMap: dict.MakeConcurrent(versionDictSize),
+		locker: lock.Make(lockerSize),
+		addAof: func(lines ...CmdLine){},
+		snapshot: &snapshotSlot{},
 	}
 	return db
 }
@@ -33,15 +66,37 @@ func (db *DB) Exec(c redis.Connection, cmdLine CmdLine) redis.Reply {
 	// SET GET PING...
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	cmd, ok := cmdTable[cmdName]
-	if !ok { //命令表中没有
+	if !ok || isSpecialCommand(cmd) { //命令表中没有
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
 	}
 	if !validateArity(cmd.arity, cmdLine) {
 		return reply.MakeArgNumErrReply(cmdName)
 	}
-	fun := cmd.executor
-	return fun(db, cmdLine[1:])
+	prepare := cmd.prepare
+	write, read := prepare(cmdLine[1:])
+	db.RWLocks(write, read)
+	defer db.RWUnLocks(write, read)
+	return db.execWithVersion(cmd, cmdLine, write)
+}
 
+// execWithVersion executes command whose keys have been locked, versions of write keys increase only if data changed
+// 修改了数据的命令都会写 AOF, 以此判断; 失败或没有修改数据的写命令不会让 WATCH 这些 key 的事务失败
+func (db *DB) execWithVersion(cmd *command, cmdLine CmdLine, write []string) redis.Reply {
+	if len(write) == 0 {
+		return cmd.executor(db, cmdLine[1:])
+	}
+	db.preserve(write...)
+	changed := false
+	cmdDB := *db
+	cmdDB.addAof = func(lines ...CmdLine) {
+		changed = true
+		db.addAof(lines...)
+	}
+	result := cmd.executor(&cmdDB, cmdLine[1:])
+	if changed {
+		db.addVersion(write...)
+	}
+	return result
 }
 
 // 校验参数个数
@@ -61,6 +116,9 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 	if !exists {
 		return nil, false
 	}
+	if db.IsExpired(key) { // 惰性删除
+		return nil, false
+	}
 	entity, _ := val.(*database.DataEntity)
 	return entity, true
 }
@@ -84,13 +142,17 @@ func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
 // Remove removes the given key from db
 func (db *DB) Remove(key string) {
 	db.data.Remove(key)
+	db.ttlMap.Remove(key)
+	if !db.passive {
+		timewheel.Cancel(genExpireTask(db.index, key))
+	}
 }
 
 // Removes removes the given keys from db
 func (db *DB) Removes(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
-		_, exists := db.data.Get(key)
+		_, exists := db.GetEntity(key)
 		if exists {
 			db.Remove(key)
 			deleted ++
@@ -99,7 +161,146 @@ func (db *DB) Removes(keys ...string) (deleted int) {
 	return deleted
 }
 
-// Flush cleans the database
+// Flush cleans the database, caller must make sure no other command is writing
 func (db *DB) Flush() {
+	db.preserveAll()
+	db.addVersion(db.data.Keys()...) // 让WATCH了这些key的事务失败
 	db.data.Clear()
+	db.ttlMap.Clear()
+}
+
+// ForEach traverses all keys and their expiration in the DB, expired keys are skipped
+func (db *DB) ForEach(cb func(key string, entity *database.DataEntity, expiration *time.Time) bool) {
+	// 先取key快照, 再逐个加读锁访问, 不在持有dict内部锁时获取key锁
+	for _, key := range db.data.Keys() {
+		if !db.visit(key, cb) {
+			break
+		}
+	}
+}
+
+func (db *DB) visit(key string, cb func(key string, entity *database.DataEntity, expiration *time.Time) bool) bool {
+	keys := []string{key}
+	db.RWLocks(nil, keys)
+	defer db.RWUnLocks(nil, keys)
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return true
+	}
+	var expiration *time.Time
+	if expireTime, ok := db.TTL(key); ok {
+		expiration = &expireTime
+	}
+	return cb(key, entity, expiration)
+}
+
+/* ---- Lock Function ----- */
+
+// RWLocks lock keys for writing and reading
+func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWLocks(writeKeys, readKeys)
+}
+
+// RWUnLocks unlock keys for writing and reading
+func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWUnLocks(writeKeys, readKeys)
+}
+
+/* ---- Dirty Counter ---- */
+
+// addDirty records changes since last save
+func (db *DB) addDirty(n int) {
+	atomic.AddInt64(&db.dirty, int64(n))
+}
+
+// getDirty returns changes since last save
+func (db *DB) getDirty() int64 {
+	return atomic.LoadInt64(&db.dirty)
+}
+
+/* ---- Version Functions ---- */
+
+// addVersion increases version of the given keys, caller should hold the write locks of keys
+func (db *DB) addVersion(keys ...string) {
+	for _, key := range keys {
+		db.versionMap.Put(key, db.GetVersion(key)+1)
+	}
+}
+
+// GetVersion returns version of the given key
+func (db *DB) GetVersion(key string) uint32 {
+	raw, ok := db.versionMap.Get(key)
+	if !ok {
+		return 0
+	}
+	return raw.(uint32)
+}
+
+/* ---- TTL Functions ---- */
+
+func genExpireTask(index int, key string) string {
+	return "expire:" + strconv.Itoa(index) + ":" + key
+}
+
+// Expire sets ttlCmd of key
+// 到期后由时间轮主动删除
+func (db *DB) Expire(key string, expireTime time.Time) {
+	db.ttlMap.Put(key, expireTime)
+	if db.passive {
+		return
+	}
+	timewheel.At(expireTime, genExpireTask(db.index, key), db.makeExpireJob(key))
+}
+
+// makeExpireJob returns the job removing key once it expires
+func (db *DB) makeExpireJob(key string) func() {
+	return func() {
+		keys := []string{key}
+		db.RWLocks(keys, nil)
+		defer db.RWUnLocks(keys, nil)
+		rawExpireTime, ok := db.ttlMap.Get(key)
+		if !ok {
+			return
+		}
+		expireTime, _ := rawExpireTime.(time.Time)
+		if !time.Now().After(expireTime) {
+			// 期间可能被重新设置过过期时间, 也可能提前触发, 重新安排任务, 否则不被访问的 key 不会被删除
+			timewheel.At(expireTime, genExpireTask(db.index, key), db.makeExpireJob(key))
+			return
+		}
+		db.Remove(key)
+		db.addVersion(key)
+	}
+}
+
+// Persist cancel ttlCmd of key
+func (db *DB) Persist(key string) {
+	db.ttlMap.Remove(key)
+	if !db.passive {
+		timewheel.Cancel(genExpireTask(db.index, key))
+	}
+}
+
+// TTL returns the expire time of key and whether the key has an expire time
+func (db *DB) TTL(key string) (time.Time, bool) {
+	raw, ok := db.ttlMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	expireTime, _ := raw.(time.Time)
+	return expireTime, true
+}
+
+// IsExpired check whether a key is expired, expired key will be removed
+func (db *DB) IsExpired(key string) bool {
+	expireTime, ok := db.TTL(key)
+	if !ok {
+		return false
+	}
+	expired := time.Now().After(expireTime)
+	if expired {
+		db.Remove(key)
+		db.addVersion(key)
+	}
+	return expired
 }
\ No newline at end of file
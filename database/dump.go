@@ -0,0 +1,114 @@
+package database
+
+import (
+	"redisgo/interface/redis"
+	"redisgo/lib/utils"
+	"redisgo/rdb"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execDump serializes value of key in RDB format, TTL is not included
+func execDump(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return reply.MakeNullBulkReply()
+	}
+	payload, err := rdb.Dump(entity)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeBulkReply(payload)
+}
+
+// execRestore executes RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+// ttl 为0表示不过期, ABSTTL 时为毫秒级时间戳; IDLETIME 和 FREQ 只做校验, 没有淘汰策略所以不记录
+func execRestore(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return reply.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace, absTTL := false, false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		case "idletime", "freq":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			if n, err := strconv.ParseInt(string(args[i+1]), 10, 64); err != nil || n < 0 {
+				return reply.MakeErrReply("ERR Invalid IDLETIME or FREQ value")
+			}
+			i++
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if _, exists := db.GetEntity(key); exists && !replace {
+		return reply.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	entity, err := rdb.Restore(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR DUMP payload version or checksum are wrong")
+	}
+
+	var expireAt time.Time
+	if ttl > 0 {
+		if absTTL {
+			expireAt = time.UnixMilli(ttl)
+		} else {
+			expireAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		if !expireAt.After(time.Now()) {
+			// 已经过期的 key 不需要创建, 和 redis 一样只删除旧值
+			if db.Removes(key) > 0 {
+				db.addAof(utils.ToCmdLine("del", key))
+			}
+			return reply.MakeOkReply()
+		}
+	}
+	db.Remove(key)
+	db.PutEntity(key, entity)
+	// 使用绝对时间记录 AOF, 重放时过期时间不变
+	cmdLine := utils.ToCmdLine2("restore", args[0], []byte("0"), args[2], []byte("REPLACE"))
+	if ttl > 0 {
+		db.Expire(key, expireAt)
+		cmdLine[2] = []byte(strconv.FormatInt(expireAt.UnixMilli(), 10))
+		cmdLine = append(cmdLine, []byte("ABSTTL"))
+	}
+	db.addAof(cmdLine)
+	return reply.MakeOkReply()
+}
+
+// makeRestoreCmd makes a RESTORE command with absolute TTL which recreates current value of key,
+// returns false if the key doesn't exist
+func (db *DB) makeRestoreCmd(key string) (CmdLine, bool, error) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, false, nil
+	}
+	payload, err := rdb.Dump(entity)
+	if err != nil {
+		return nil, false, err
+	}
+	ttl := "0"
+	if expireAt, ok := db.TTL(key); ok {
+		ttl = strconv.FormatInt(expireAt.UnixMilli(), 10)
+	}
+	return utils.ToCmdLine2("restore", []byte(key), []byte(ttl), payload, []byte("REPLACE"), []byte("ABSTTL")), true, nil
+}
+
+func init() {
+	RegisterCommand("Dump", execDump, readFirstKey, 2, "read", "keyspace", "slow")
+	RegisterCommand("Restore", execRestore, writeFirstKey, -4, "write", "keyspace", "slow", "dangerous")
+}
@@ -0,0 +1,515 @@
+package database
+
+import (
+	"math"
+	Dict "redisgo/datastruct/dict"
+	"redisgo/interface/database"
+	"redisgo/interface/redis"
+	"redisgo/lib/utils"
+	"redisgo/lib/wildcard"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsDict(key string) (Dict.Dict, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	dict, ok := entity.Data.(Dict.Dict)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return dict, nil
+}
+
+func (db *DB) getOrInitDict(key string) (dict Dict.Dict, inited bool, errReply reply.ErrorReply) {
+	dict, errReply = db.getAsDict(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if dict == nil {
+		dict = Dict.MakeSimpleDict()
+		db.PutEntity(key, &database.DataEntity{
+			Data: dict,
+		})
+		inited = true
+	}
+	return dict, inited, nil
+}
+
+// execHSet sets field in hash table
+// HSET key field value [field value ...]
+func execHSet(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hset")
+	}
+	key := string(args[0])
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := 0
+	for i := 1; i < len(args); i += 2 {
+		field := string(args[i])
+		value := args[i+1]
+		result += dict.Put(field, value)
+	}
+	db.addAof(utils.ToCmdLine2("hset", args...))
+	return reply.MakeIntReply(int64(result))
+}
+
+// execHMSet sets multi fields in hash table
+func execHMSet(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hmset")
+	}
+	key := string(args[0])
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	for i := 1; i < len(args); i += 2 {
+		dict.Put(string(args[i]), args[i+1])
+	}
+	db.addAof(utils.ToCmdLine2("hmset", args...))
+	return &reply.OKReply{}
+}
+
+// execHSetNX sets field in hash table only if field not exists
+func execHSetNX(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	value := args[2]
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := dict.PutIfAbsent(field, value)
+	if result > 0 {
+		db.addAof(utils.ToCmdLine2("hsetnx", args...))
+	}
+	return reply.MakeIntReply(int64(result))
+}
+
+// execHGet gets field value of hash table
+func execHGet(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	raw, exists := dict.Get(field)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	value, _ := raw.([]byte)
+	return reply.MakeBulkReply(value)
+}
+
+// execHExists checks if a hash field exists
+func execHExists(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	_, exists := dict.Get(field)
+	if exists {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+// execHDel deletes a hash field
+func execHDel(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	deleted := 0
+	for _, field := range args[1:] {
+		deleted += dict.Remove(string(field))
+	}
+	if dict.Len() == 0 {
+		db.Remove(key)
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine2("hdel", args...))
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+// execHLen gets number of fields in hash table
+func execHLen(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(dict.Len()))
+}
+
+// execHStrlen gets string length of field value in hash table
+func execHStrlen(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	raw, exists := dict.Get(field)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	value, _ := raw.([]byte)
+	return reply.MakeIntReply(int64(len(value)))
+}
+
+// execHMGet gets multi fields in hash table
+func execHMGet(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	size := len(args) - 1
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, size)
+	if dict == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+
+	for i, field := range args[1:] {
+		raw, exists := dict.Get(string(field))
+		if !exists {
+			result[i] = nil
+		} else {
+			result[i], _ = raw.([]byte)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execHKeys gets all field names in hash table
+func execHKeys(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+
+	fields := make([][]byte, 0, dict.Len())
+	dict.ForEach(func(key string, val interface{}) bool {
+		fields = append(fields, []byte(key))
+		return true
+	})
+	return reply.MakeMultiBulkReply(fields)
+}
+
+// execHVals gets all field value in hash table
+func execHVals(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+
+	values := make([][]byte, 0, dict.Len())
+	dict.ForEach(func(key string, val interface{}) bool {
+		value, _ := val.([]byte)
+		values = append(values, value)
+		return true
+	})
+	return reply.MakeMultiBulkReply(values)
+}
+
+// execHGetAll gets all key-value entries in hash table
+func execHGetAll(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+
+	result := make([][]byte, 0, dict.Len()*2)
+	dict.ForEach(func(key string, val interface{}) bool {
+		value, _ := val.([]byte)
+		result = append(result, []byte(key), value)
+		return true
+	})
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execHIncrBy increments the integer value of a hash field by the given number
+func execHIncrBy(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	value, exists := dict.Get(field)
+	if !exists {
+		dict.Put(field, []byte(strconv.FormatInt(delta, 10)))
+		db.addAof(utils.ToCmdLine2("hincrby", args...))
+		return reply.MakeIntReply(delta)
+	}
+	val, err := strconv.ParseInt(string(value.([]byte)), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR hash value is not an integer")
+	}
+	if (delta > 0 && val > 0 && val+delta < 0) || (delta < 0 && val < 0 && val+delta >= 0) {
+		return reply.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	val += delta
+	dict.Put(field, []byte(strconv.FormatInt(val, 10)))
+	db.addAof(utils.ToCmdLine2("hincrby", args...))
+	return reply.MakeIntReply(val)
+}
+
+// execHIncrByFloat increments the float value of a hash field by the given number
+func execHIncrByFloat(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	val := float64(0)
+	value, exists := dict.Get(field)
+	if exists {
+		val, err = strconv.ParseFloat(string(value.([]byte)), 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR hash value is not a float")
+		}
+	}
+	result := []byte(strconv.FormatFloat(val+delta, 'f', -1, 64))
+	dict.Put(field, result)
+	// 浮点运算结果以HSET的形式写入AOF, 避免重放时精度不一致
+	db.addAof(utils.ToCmdLine2("hset", args[0], args[1], result))
+	return reply.MakeBulkReply(result)
+}
+
+// execHRandField returns random fields of hash table
+// HRANDFIELD key [count [WITHVALUES]]
+func execHRandField(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	withCount := len(args) >= 2
+	withValues := false
+	count := int64(1)
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHVALUES" {
+			return reply.MakeSyntaxErrReply()
+		}
+		withValues = true
+	} else if len(args) > 3 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if withCount {
+		var errReply reply.ErrorReply
+		count, errReply = parseRandomCount(args[1], withValues)
+		if errReply != nil {
+			return errReply
+		}
+	}
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		if withCount {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		return &reply.NullBulkReply{}
+	}
+
+	if !withCount {
+		fields := dict.RandomKeys(1)
+		return reply.MakeBulkReply([]byte(fields[0]))
+	}
+	var fields []string
+	if count >= 0 { // 正数返回不重复的field
+		fields = dict.RandomDistinctKeys(int(count))
+	} else { // 负数允许重复
+		fields = dict.RandomKeys(int(-count))
+	}
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		result = append(result, []byte(field))
+		if withValues {
+			raw, _ := dict.Get(field)
+			value, _ := raw.([]byte)
+			result = append(result, value)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// maxRandomCount limits how many elements HRANDFIELD and SRANDMEMBER return for a negative count,
+// the reply is built in memory before being sent
+const maxRandomCount = 1 << 20
+
+// parseRandomCount parses count of HRANDFIELD and SRANDMEMBER
+func parseRandomCount(arg []byte, withValues bool) (int64, reply.ErrorReply) {
+	count, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	// 与 Redis 相同, 不接受 math.MinInt64, 返回键值对时数量不能超过一半
+	limit := int64(math.MaxInt64)
+	if withValues {
+		limit /= 2
+	}
+	if count < -limit || count > limit || -count > maxRandomCount {
+		return 0, reply.MakeErrReply("ERR value is out of range")
+	}
+	return count, nil
+}
+
+// parseScanArgs parses [MATCH pattern] [COUNT count] of SCAN family
+func parseScanArgs(args [][]byte) (cursor uint64, count int, pattern *wildcard.Pattern, errReply reply.ErrorReply) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return 0, 0, nil, reply.MakeErrReply("ERR invalid cursor")
+	}
+	count = 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, 0, nil, reply.MakeSyntaxErrReply()
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = wildcard.CompilePattern(string(args[i+1]))
+		case "COUNT":
+			count64, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return 0, 0, nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count64 < 1 {
+				return 0, 0, nil, reply.MakeSyntaxErrReply()
+			}
+			count = int(count64)
+		default:
+			return 0, 0, nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return cursor, count, pattern, nil
+}
+
+// makeScanReply makes reply of SCAN family: [next cursor, [elements...]]
+func makeScanReply(cursor uint64, elements [][]byte) redis.Reply {
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(cursor, 10))),
+		reply.MakeMultiBulkReply(elements),
+	})
+}
+
+// execHScan incrementally iterates fields of hash table
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func execHScan(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	cursor, count, pattern, errReply := parseScanArgs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return makeScanReply(0, [][]byte{})
+	}
+
+	var match func(string) bool
+	if pattern != nil {
+		match = pattern.IsMatch
+	}
+	fields, next := Dict.Scan(dict, cursor, count, match)
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		raw, _ := dict.Get(field)
+		value, _ := raw.([]byte)
+		result = append(result, []byte(field), value)
+	}
+	return makeScanReply(next, result)
+}
+
+func init() {
+	RegisterCommand("HSet", execHSet, writeFirstKey, -4, "write", "hash", "fast")
+	RegisterCommand("HMSet", execHMSet, writeFirstKey, -4, "write", "hash", "fast")
+	RegisterCommand("HSetNX", execHSetNX, writeFirstKey, 4, "write", "hash", "fast")
+	RegisterCommand("HGet", execHGet, readFirstKey, 3, "read", "hash", "fast")
+	RegisterCommand("HExists", execHExists, readFirstKey, 3, "read", "hash", "fast")
+	RegisterCommand("HDel", execHDel, writeFirstKey, -3, "write", "hash", "fast")
+	RegisterCommand("HLen", execHLen, readFirstKey, 2, "read", "hash", "fast")
+	RegisterCommand("HStrlen", execHStrlen, readFirstKey, 3, "read", "hash", "fast")
+	RegisterCommand("HMGet", execHMGet, readFirstKey, -3, "read", "hash", "fast")
+	RegisterCommand("HKeys", execHKeys, readFirstKey, 2, "read", "hash", "slow")
+	RegisterCommand("HVals", execHVals, readFirstKey, 2, "read", "hash", "slow")
+	RegisterCommand("HGetAll", execHGetAll, readFirstKey, 2, "read", "hash", "slow")
+	RegisterCommand("HIncrBy", execHIncrBy, writeFirstKey, 4, "write", "hash", "fast")
+	RegisterCommand("HIncrByFloat", execHIncrByFloat, writeFirstKey, 4, "write", "hash", "fast")
+	RegisterCommand("HRandField", execHRandField, readFirstKey, -2, "read", "hash", "slow")
+	RegisterCommand("HScan", execHScan, readFirstKey, -3, "read", "hash", "slow")
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"redisgo/lib/utils"
+	"redisgo/redis/connection"
+	"redisgo/redis/reply"
+	"testing"
+)
+
+func TestHRandFieldCountRange(t *testing.T) {
+	db := makeDB()
+	c := &connection.Connection{}
+	db.Exec(c, utils.ToCmdLine("hset", "h", "f", "v"))
+
+	for _, args := range [][]string{
+		{"hrandfield", "h", "-9223372036854775808"},
+		{"hrandfield", "h", "-9223372036854775807"},
+		{"hrandfield", "h", "4611686018427387904", "withvalues"},
+	} {
+		result := db.Exec(c, utils.ToCmdLine(args...))
+		if !reply.IsErrorReply(result) {
+			t.Errorf("%v: expected error, got %s", args, result.ToBytes())
+		}
+	}
+
+	result := db.Exec(c, utils.ToCmdLine("hrandfield", "h", "-3", "withvalues"))
+	if multiBulk, ok := result.(*reply.MultiBulkReply); !ok || len(multiBulk.Args) != 6 {
+		t.Errorf("expected 3 field-value pairs, got %s", result.ToBytes())
+	}
+}
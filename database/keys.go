@@ -1,10 +1,19 @@
 package database
 
 import (
+	"math"
+	"redisgo/aof"
+	"redisgo/datastruct/dict"
+	"redisgo/datastruct/set"
+	"redisgo/datastruct/sortedset"
+	List "redisgo/datastruct/list"
 	"redisgo/interface/redis"
 	"redisgo/lib/utils"
 	"redisgo/lib/wildcard"
 	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // execDel removes a key from db
@@ -50,11 +59,25 @@ func execType(db *DB, args [][]byte) redis.Reply {
 	switch entity.Data.(type) {
 	case []byte:
 		return reply.MakeStatusReply("string")
-		// TODO: others type
+	case List.List:
+		return reply.MakeStatusReply("list")
+	case dict.Dict:
+		return reply.MakeStatusReply("hash")
+	case *set.Set:
+		return reply.MakeStatusReply("set")
+	case *sortedset.SortedSet:
+		return reply.MakeStatusReply("zset")
 	}
 	return &reply.UnknowErrReply{}
 }
 
+// prepareRename locks both source and destination, also used by SMOVE
+func prepareRename(args [][]byte) ([]string, []string) {
+	src := string(args[0])
+	dest := string(args[1])
+	return []string{src, dest}, nil
+}
+
 // execRename renames a key, 可能覆盖已有的kv
 func execRename(db *DB, args [][]byte) redis.Reply {
 
@@ -65,8 +88,17 @@ func execRename(db *DB, args [][]byte) redis.Reply {
 	if !ok {
 		return reply.MakeErrReply("no such key")
 	}
+	if src == dest { // 和 redis 一样, 重命名为自身时不做修改
+		return &reply.OKReply{}
+	}
+	expireTime, hasTTL := db.TTL(src)
 	db.PutEntity(dest, entity)
 	db.Remove(src)
+	if hasTTL { // 过期时间随key一起转移
+		db.Expire(dest, expireTime)
+	} else {
+		db.Persist(dest)
+	}
 	db.addAof(utils.ToCmdLine2("rename", args...))
 	return &reply.OKReply{}
 }
@@ -76,16 +108,22 @@ func execRenameNx(db *DB, args [][]byte) redis.Reply {
 	src := string(args[0])
 	dest := string(args[1])
 
-	_, exists := db.GetEntity(dest)
-	if exists {
-		return reply.MakeIntReply(0)
-	}
 	entity, exists := db.GetEntity(src)
 	if !exists {
 		return reply.MakeErrReply("no such key")
 	}
+	if src == dest {
+		return reply.MakeIntReply(0)
+	}
+	if _, exists := db.GetEntity(dest); exists {
+		return reply.MakeIntReply(0)
+	}
+	expireTime, hasTTL := db.TTL(src)
 	db.Remove(src)
 	db.PutEntity(dest, entity)
+	if hasTTL {
+		db.Expire(dest, expireTime)
+	}
 	db.addAof(utils.ToCmdLine2("renamenx", args...))
 	return reply.MakeIntReply(1)
 }
@@ -93,22 +131,215 @@ func execRenameNx(db *DB, args [][]byte) redis.Reply {
 // execKeys returns all keys matching the given pattern
 func execKeys(db *DB, args [][]byte) redis.Reply {
 	pattern := wildcard.CompilePattern(string(args[0]))
-	result := make([][]byte, 0)
+	matched := make([]string, 0)
 	db.data.ForEach(func(key string, val interface{}) bool {
 		if pattern.IsMatch(key) {
-			result = append(result, []byte(key))
+			matched = append(matched, key)
 		}
 		return true
 	})
+	result := make([][]byte, 0, len(matched))
+	for _, key := range matched {
+		if db.IsExpired(key) { // 遍历结束后再删除过期的key
+			continue
+		}
+		result = append(result, []byte(key))
+	}
 	return reply.MakeMultiBulkReply(result)
 }
 
+/* ---- TTL commands ---- */
+
+// options of EXPIRE family
+const (
+	expireAlways = iota
+	expireNX     // 仅当key没有过期时间时设置
+	expireXX     // 仅当key已有过期时间时设置
+	expireGT     // 仅当新的过期时间大于当前过期时间时设置
+	expireLT     // 仅当新的过期时间小于当前过期时间时设置
+)
+
+func parseExpireOption(args [][]byte) (int, reply.ErrorReply) {
+	nx, xx, gt, lt := false, false, false, false
+	for _, arg := range args {
+		switch strings.ToUpper(string(arg)) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return 0, reply.MakeErrReply("ERR Unsupported option " + string(arg))
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return 0, reply.MakeErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return 0, reply.MakeErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	switch {
+	case nx:
+		return expireNX, nil
+	case gt:
+		return expireGT, nil
+	case lt:
+		return expireLT, nil
+	case xx:
+		return expireXX, nil
+	}
+	return expireAlways, nil
+}
+
+// parseExpireTime converts the argument of EXPIRE family to absolute unix milliseconds
+func parseExpireTime(cmdName string, arg []byte, unit time.Duration, absolute bool) (int64, reply.ErrorReply) {
+	raw, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	invalid := reply.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	factor := int64(unit / time.Millisecond)
+	if raw > math.MaxInt64/factor || raw < math.MinInt64/factor {
+		return 0, invalid
+	}
+	ms := raw * factor
+	if !absolute {
+		now := time.Now().UnixMilli()
+		if ms > 0 && now > math.MaxInt64-ms {
+			return 0, invalid
+		}
+		ms += now
+	}
+	return ms, nil
+}
+
+func expireGeneric(db *DB, cmdName string, args [][]byte, unit time.Duration, absolute bool) redis.Reply {
+	key := string(args[0])
+	ms, errReply := parseExpireTime(cmdName, args[1], unit, absolute)
+	if errReply != nil {
+		return errReply
+	}
+	option, errReply := parseExpireOption(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+
+	expireTime := time.UnixMilli(ms)
+	current, hasTTL := db.TTL(key)
+	switch option {
+	case expireNX:
+		if hasTTL {
+			return reply.MakeIntReply(0)
+		}
+	case expireXX:
+		if !hasTTL {
+			return reply.MakeIntReply(0)
+		}
+	case expireGT: // 没有过期时间视为无穷大
+		if !hasTTL || !expireTime.After(current) {
+			return reply.MakeIntReply(0)
+		}
+	case expireLT:
+		if hasTTL && !expireTime.Before(current) {
+			return reply.MakeIntReply(0)
+		}
+	}
+
+	if !expireTime.After(time.Now()) { // 已过期直接删除
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine2("del", args[0]))
+		return reply.MakeIntReply(1)
+	}
+	db.Expire(key, expireTime)
+	db.addAof(aof.MakeExpireCmd(key, expireTime))
+	return reply.MakeIntReply(1)
+}
+
+// execExpire sets a key's time to live in seconds
+func execExpire(db *DB, args [][]byte) redis.Reply {
+	return expireGeneric(db, "expire", args, time.Second, false)
+}
+
+// execPExpire sets a key's time to live in milliseconds
+func execPExpire(db *DB, args [][]byte) redis.Reply {
+	return expireGeneric(db, "pexpire", args, time.Millisecond, false)
+}
+
+// execExpireAt sets the expiration for a key as a UNIX timestamp in seconds
+func execExpireAt(db *DB, args [][]byte) redis.Reply {
+	return expireGeneric(db, "expireat", args, time.Second, true)
+}
+
+// execPExpireAt sets the expiration for a key as a UNIX timestamp in milliseconds
+func execPExpireAt(db *DB, args [][]byte) redis.Reply {
+	return expireGeneric(db, "pexpireat", args, time.Millisecond, true)
+}
+
+// ttlGeneric returns the remaining time to live of a key, -2 if key not exists, -1 if key has no ttl
+func ttlGeneric(db *DB, args [][]byte, unit time.Duration) redis.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, hasTTL := db.TTL(key)
+	if !hasTTL {
+		return reply.MakeIntReply(-1)
+	}
+	ttl := time.Until(expireTime)
+	result := int64(ttl / unit)
+	if ttl%unit >= unit/2 { // 四舍五入
+		result++
+	}
+	return reply.MakeIntReply(result)
+}
+
+// execTTL returns a key's time to live in seconds
+func execTTL(db *DB, args [][]byte) redis.Reply {
+	return ttlGeneric(db, args, time.Second)
+}
+
+// execPTTL returns a key's time to live in milliseconds
+func execPTTL(db *DB, args [][]byte) redis.Reply {
+	return ttlGeneric(db, args, time.Millisecond)
+}
+
+// execPersist removes expiration from a key
+func execPersist(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	_, hasTTL := db.TTL(key)
+	if !hasTTL {
+		return reply.MakeIntReply(0)
+	}
+	db.Persist(key)
+	db.addAof(utils.ToCmdLine2("persist", args...))
+	return reply.MakeIntReply(1)
+}
+
 func init() {
-	RegisterCommand("Del", execDel, -2)
-	RegisterCommand("Exists", execExists, -2)
-	RegisterCommand("Keys", execKeys, 2)
-	RegisterCommand("FlushDB", execFlushDB, -1) // flushdb a b c 忽略后面的，只执行flushdb
-	RegisterCommand("Type", execType, 2)
-	RegisterCommand("Rename", execRename, 3)
-	RegisterCommand("RenameNx", execRenameNx, 3)
+	RegisterCommand("Del", execDel, writeAllKeys, -2, "write", "keyspace", "slow")
+	RegisterCommand("Exists", execExists, readAllKeys, -2, "read", "keyspace", "fast")
+	RegisterCommand("Keys", execKeys, noPrepare, 2, "read", "keyspace", "slow", "dangerous")
+	RegisterCommand("FlushDB", execFlushDB, noPrepare, -1, "write", "keyspace", "slow", "dangerous") // flushdb a b c 忽略后面的，只执行flushdb
+	RegisterCommand("Type", execType, readFirstKey, 2, "read", "keyspace", "fast")
+	RegisterCommand("Rename", execRename, prepareRename, 3, "write", "keyspace", "slow")
+	RegisterCommand("RenameNx", execRenameNx, prepareRename, 3, "write", "keyspace", "fast")
+	RegisterCommand("Expire", execExpire, writeFirstKey, -3, "write", "keyspace", "fast")
+	RegisterCommand("PExpire", execPExpire, writeFirstKey, -3, "write", "keyspace", "fast")
+	RegisterCommand("ExpireAt", execExpireAt, writeFirstKey, -3, "write", "keyspace", "fast")
+	RegisterCommand("PExpireAt", execPExpireAt, writeFirstKey, -3, "write", "keyspace", "fast")
+	RegisterCommand("TTL", execTTL, readFirstKey, 2, "read", "keyspace", "fast")
+	RegisterCommand("PTTL", execPTTL, readFirstKey, 2, "read", "keyspace", "fast")
+	RegisterCommand("Persist", execPersist, writeFirstKey, 2, "write", "keyspace", "fast")
 }
\ No newline at end of file
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"redisgo/lib/utils"
+	"redisgo/redis/connection"
+	"testing"
+	"time"
+)
+
+// 不读取 key, 只靠时间轮删除
+func TestActiveExpire(t *testing.T) {
+	db := makeDB()
+	c := &connection.Connection{}
+	db.Exec(c, utils.ToCmdLine("set", "k1", "v"))
+	db.Exec(c, utils.ToCmdLine("pexpire", "k1", "300"))
+	db.Exec(c, utils.ToCmdLine("set", "k2", "v"))
+	db.Exec(c, utils.ToCmdLine("pexpire", "k2", "1500"))
+
+	time.Sleep(3 * time.Second)
+	for _, key := range []string{"k1", "k2"} {
+		if _, ok := db.data.Get(key); ok {
+			t.Errorf("%s should have been removed by time wheel", key)
+		}
+		if _, ok := db.ttlMap.Get(key); ok {
+			t.Errorf("ttl of %s should have been removed", key)
+		}
+	}
+}
+
+// 惰性删除同样会让 WATCH 了这个 key 的事务失败
+func TestLazyExpireAddsVersion(t *testing.T) {
+	db := makeDB()
+	db.passive = true
+	c := &connection.Connection{}
+	db.Exec(c, utils.ToCmdLine("set", "k", "v", "px", "50"))
+	version := db.GetVersion("k")
+	time.Sleep(100 * time.Millisecond)
+
+	db.Exec(c, utils.ToCmdLine("get", "k"))
+	if db.GetVersion("k") == version {
+		t.Error("version should change when expired key is removed")
+	}
+}
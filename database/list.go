@@ -0,0 +1,429 @@
+package database
+
+import (
+	List "redisgo/datastruct/list"
+	"redisgo/interface/database"
+	"redisgo/interface/redis"
+	"redisgo/lib/utils"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsList(key string) (List.List, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	list, ok := entity.Data.(List.List)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return list, nil
+}
+
+func (db *DB) getOrInitList(key string) (list List.List, isNew bool, errReply reply.ErrorReply) {
+	list, errReply = db.getAsList(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	isNew = false
+	if list == nil {
+		list = List.NewQuickList()
+		db.PutEntity(key, &database.DataEntity{
+			Data: list,
+		})
+		isNew = true
+	}
+	return list, isNew, nil
+}
+
+// normalizeRange converts redis-style index range [start, stop] to [start, stop) of the given size
+// returns false if the range is empty
+func normalizeRange(start, stop int64, size int) (int, int, bool) {
+	size64 := int64(size)
+	if start < -size64 {
+		start = 0
+	} else if start < 0 {
+		start = size64 + start
+	}
+	if stop < -size64 {
+		return 0, 0, false
+	} else if stop < 0 {
+		stop = size64 + stop + 1
+	} else if stop < size64 {
+		stop = stop + 1
+	} else {
+		stop = size64
+	}
+	if start >= size64 || stop <= start {
+		return 0, 0, false
+	}
+	return int(start), int(stop), true
+}
+
+// execLIndex gets element of list at given list
+func execLIndex(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	index64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	size := int64(list.Len())
+	if index64 < -size || index64 >= size {
+		return &reply.NullBulkReply{}
+	}
+	if index64 < 0 {
+		index64 = size + index64
+	}
+	val, _ := list.Get(int(index64)).([]byte)
+	return reply.MakeBulkReply(val)
+}
+
+// execLLen gets length of list
+func execLLen(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// popGeneric removes elements from the head or tail of list
+// LPOP key [count]
+func popGeneric(db *DB, cmdName string, args [][]byte, fromHead bool) redis.Reply {
+	key := string(args[0])
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	withCount := len(args) == 2
+	count := 1
+	if withCount {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = int(count64)
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		if withCount {
+			return reply.MakeNullMultiBulkReply()
+		}
+		return &reply.NullBulkReply{}
+	}
+
+	if count > list.Len() {
+		count = list.Len()
+	}
+	result := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		var val interface{}
+		if fromHead {
+			val = list.Remove(0)
+		} else {
+			val = list.RemoveLast()
+		}
+		result = append(result, val.([]byte))
+	}
+	if list.Len() == 0 {
+		db.Remove(key)
+	}
+	if count > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+	}
+	if !withCount {
+		return reply.MakeBulkReply(result[0])
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execLPop removes the first element of list, and return it
+func execLPop(db *DB, args [][]byte) redis.Reply {
+	return popGeneric(db, "lpop", args, true)
+}
+
+// execRPop removes last element of list then return it
+func execRPop(db *DB, args [][]byte) redis.Reply {
+	return popGeneric(db, "rpop", args, false)
+}
+
+// execLPush inserts element at head of list
+func execLPush(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
+	for _, value := range values {
+		list.Insert(0, value)
+	}
+
+	db.addAof(utils.ToCmdLine2("lpush", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execLPushX inserts element at head of list, only if list exists
+func execLPushX(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	for _, value := range values {
+		list.Insert(0, value)
+	}
+	db.addAof(utils.ToCmdLine2("lpushx", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execRPush inserts element at last of list
+func execRPush(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
+	for _, value := range values {
+		list.Add(value)
+	}
+
+	db.addAof(utils.ToCmdLine2("rpush", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execRPushX inserts element at last of list only if list exists
+func execRPushX(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	for _, value := range values {
+		list.Add(value)
+	}
+	db.addAof(utils.ToCmdLine2("rpushx", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// execLRange gets elements of list in given range
+func execLRange(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	start64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop64, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+
+	start, stop, ok := normalizeRange(start64, stop64, list.Len())
+	if !ok {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	slice := list.Range(start, stop)
+	result := make([][]byte, len(slice))
+	for i, raw := range slice {
+		result[i] = raw.([]byte)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execLRem removes element of list at specified index
+// count > 0: 从头到尾删除; count < 0: 从尾到头删除; count = 0: 全部删除
+func execLRem(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	count := int(count64)
+	value := args[2]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	expected := func(a interface{}) bool {
+		return utils.BytesEquals(a.([]byte), value)
+	}
+	var removed int
+	if count == 0 {
+		removed = list.RemoveAllByVal(expected)
+	} else if count > 0 {
+		removed = list.RemoveByVal(expected, count)
+	} else {
+		removed = list.ReverseRemoveByVal(expected, -count)
+	}
+
+	if list.Len() == 0 {
+		db.Remove(key)
+	}
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2("lrem", args...))
+	}
+	return reply.MakeIntReply(int64(removed))
+}
+
+// execLSet puts element at specified index of list
+func execLSet(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	index64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	value := args[2]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+
+	size := int64(list.Len())
+	if index64 < -size || index64 >= size {
+		return reply.MakeErrReply("ERR index out of range")
+	}
+	if index64 < 0 {
+		index64 = size + index64
+	}
+	list.Set(int(index64), value)
+	db.addAof(utils.ToCmdLine2("lset", args...))
+	return &reply.OKReply{}
+}
+
+// execLTrim trims the list so that it contains only the specified range of elements
+func execLTrim(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	start64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop64, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return &reply.OKReply{}
+	}
+
+	start, stop, ok := normalizeRange(start64, stop64, list.Len())
+	if !ok {
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine2("ltrim", args...))
+		return &reply.OKReply{}
+	}
+	list.Trim(start, stop)
+	db.addAof(utils.ToCmdLine2("ltrim", args...))
+	return &reply.OKReply{}
+}
+
+// execLInsert inserts element before or after the reference value pivot
+// LINSERT key BEFORE|AFTER pivot element
+func execLInsert(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	var before bool
+	switch strings.ToUpper(string(args[1])) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	pivot := args[2]
+	value := args[3]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	index := -1
+	list.ForEach(func(i int, v interface{}) bool {
+		if utils.BytesEquals(v.([]byte), pivot) {
+			index = i
+			return false
+		}
+		return true
+	})
+	if index < 0 {
+		return reply.MakeIntReply(-1)
+	}
+	if !before {
+		index++
+	}
+	list.Insert(index, value)
+	db.addAof(utils.ToCmdLine2("linsert", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+func init() {
+	RegisterCommand("LPush", execLPush, writeFirstKey, -3, "write", "list", "fast")
+	RegisterCommand("LPushX", execLPushX, writeFirstKey, -3, "write", "list", "fast")
+	RegisterCommand("RPush", execRPush, writeFirstKey, -3, "write", "list", "fast")
+	RegisterCommand("RPushX", execRPushX, writeFirstKey, -3, "write", "list", "fast")
+	RegisterCommand("LPop", execLPop, writeFirstKey, -2, "write", "list", "fast")
+	RegisterCommand("RPop", execRPop, writeFirstKey, -2, "write", "list", "fast")
+	RegisterCommand("LRem", execLRem, writeFirstKey, 4, "write", "list", "slow")
+	RegisterCommand("LLen", execLLen, readFirstKey, 2, "read", "list", "fast")
+	RegisterCommand("LIndex", execLIndex, readFirstKey, 3, "read", "list", "slow")
+	RegisterCommand("LSet", execLSet, writeFirstKey, 4, "write", "list", "slow")
+	RegisterCommand("LRange", execLRange, readFirstKey, 4, "read", "list", "slow")
+	RegisterCommand("LTrim", execLTrim, writeFirstKey, 4, "write", "list", "slow")
+	RegisterCommand("LInsert", execLInsert, writeFirstKey, 5, "write", "list", "slow")
+}
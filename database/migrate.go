@@ -0,0 +1,107 @@
+package database
+
+import (
+	dbinterface "redisgo/interface/database"
+	"redisgo/interface/redis"
+	"redisgo/lib/utils"
+	"strings"
+	"time"
+)
+
+// 集群重新分片时使用: 源节点把 key 以 RESTORE 命令的形式发送给新的负责节点, 迁移期间的请求先在源节点上尝试执行
+// send 在持有 key 的锁时调用, 调用方可以在其中记录已迁走的 key
+
+// ExecUnlessMoved executes command if none of its keys has been moved to other node,
+// returns number of moved keys and total keys, reply is nil if any key has been moved
+// 检查和执行持有相同的锁, 执行期间 key 不会被迁走
+func (database *StandaloneDatabase) ExecUnlessMoved(c redis.Connection, cmdLine [][]byte,
+	moved func(dbIndex int, key string) bool) (result redis.Reply, movedCount int, total int) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok || isSpecialCommand(cmd) || !validateArity(cmd.arity, cmdLine) {
+		return database.Exec(c, cmdLine), 0, 0
+	}
+	write, read := cmd.prepare(cmdLine[1:])
+	keys := make(map[string]struct{}, len(write)+len(read))
+	for _, key := range write {
+		keys[key] = struct{}{}
+	}
+	for _, key := range read {
+		keys[key] = struct{}{}
+	}
+	if len(keys) == 0 || isWriteCommand(cmdName) && database.isReadOnlyFor(c) {
+		return database.Exec(c, cmdLine), 0, len(keys)
+	}
+	if isWriteCommand(cmdName) {
+		database.writeBarrier.RLock()
+		defer database.writeBarrier.RUnlock()
+	}
+	dbIndex := c.GetDBIndex()
+	db := database.dbSet[dbIndex]
+	db.RWLocks(write, read)
+	defer db.RWUnLocks(write, read)
+	for key := range keys {
+		if moved(dbIndex, key) {
+			movedCount++
+		}
+	}
+	if movedCount > 0 {
+		return nil, movedCount, len(keys)
+	}
+	return db.execWithVersion(cmd, cmdLine, write), 0, len(keys)
+}
+
+// MoveKeys moves keys in all DBs to other nodes, pick returns target node of key or empty string to keep it,
+// send delivers the RESTORE command to target node, the key is removed locally only after it succeeds
+// 迁移期间可能有新的写入, 重复遍历直到没有需要迁移的 key
+func (database *StandaloneDatabase) MoveKeys(pick func(key string) string,
+	send func(target string, dbIndex int, restoreCmd [][]byte) error) error {
+	for _, db := range database.dbSet {
+		for {
+			var keys []string
+			db.ForEach(func(key string, _ *dbinterface.DataEntity, _ *time.Time) bool {
+				keys = append(keys, key)
+				return true
+			})
+			moved := 0
+			for _, key := range keys {
+				target := pick(key)
+				if target == "" {
+					continue
+				}
+				ok, err := database.moveKey(db, key, target, send)
+				if err != nil {
+					return err
+				}
+				if ok {
+					moved++
+				}
+			}
+			if moved == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// moveKey sends one key with its TTL to target node, returns false if the key no longer exists
+func (database *StandaloneDatabase) moveKey(db *DB, key string, target string,
+	send func(target string, dbIndex int, restoreCmd [][]byte) error) (bool, error) {
+	database.writeBarrier.RLock()
+	defer database.writeBarrier.RUnlock()
+	db.RWLocks([]string{key}, nil)
+	defer db.RWUnLocks([]string{key}, nil)
+	restoreCmd, ok, err := db.makeRestoreCmd(key)
+	if !ok || err != nil {
+		return false, err
+	}
+	if err := send(target, db.index, restoreCmd); err != nil {
+		return false, err
+	}
+	db.preserve(key)
+	db.Remove(key)
+	db.addVersion(key)
+	db.addAof(utils.ToCmdLine("del", key))
+	return true, nil
+}
@@ -10,5 +10,5 @@ func Ping(db *DB, args [][]byte) redis.Reply {
 }
 
 func init() {
-	RegisterCommand("ping", Ping, 1)
+	RegisterCommand("ping", Ping, noPrepare, 1, "connection", "fast")
 }
\ No newline at end of file
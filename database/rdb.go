@@ -0,0 +1,282 @@
+package database
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"redisgo/config"
+	"redisgo/interface/redis"
+	"redisgo/lib/logger"
+	"redisgo/rdb"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// bgSaveRetryDelay is the minimal interval to retry after a failed background save
+const bgSaveRetryDelay = 5 * time.Second
+
+var errSaveInProgress = errors.New("Background save already in progress")
+
+// saveRule triggers background save if there are at least changes in seconds
+type saveRule struct {
+	seconds int64
+	changes int64
+}
+
+// parseSaveRules parses save config such as "3600 1 300 100", empty string disables auto save
+func parseSaveRules(raw string) []saveRule {
+	var fields []string
+	for _, f := range strings.Fields(raw) {
+		if f != `""` {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields)%2 != 0 {
+		logger.Warn("invalid save config: " + raw)
+		return nil
+	}
+	rules := make([]saveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds <= 0 || changes < 0 {
+			logger.Warn("invalid save config: " + raw)
+			return nil
+		}
+		rules = append(rules, saveRule{seconds: seconds, changes: changes})
+	}
+	return rules
+}
+
+// dirty returns changes of all DBs since last save
+func (database *StandaloneDatabase) dirty() int64 {
+	var sum int64
+	for _, db := range database.dbSet {
+		sum += db.getDirty()
+	}
+	return sum
+}
+
+// loadRDB loads snapshot file into DBs, expired keys are dropped
+func (database *StandaloneDatabase) loadRDB(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	logger.Info("Loading RDB...")
+	start := time.Now()
+	now := time.Now()
+	count := 0
+	var indexErr error
+	dec := rdb.NewDecoder(file)
+	err = dec.Parse(func(o *rdb.Object) bool {
+		if o.DBIndex >= len(database.dbSet) {
+			indexErr = errors.New("DB index " + strconv.Itoa(o.DBIndex) + " in rdb is out of range")
+			return false
+		}
+		if o.Expiration != nil && o.Expiration.Before(now) {
+			return true
+		}
+		db := database.dbSet[o.DBIndex]
+		db.PutEntity(o.Key, o.Entity)
+		if o.Expiration != nil {
+			db.Expire(o.Key, *o.Expiration)
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if indexErr != nil {
+		return indexErr
+	}
+	logger.Info("RDB loaded " + strconv.Itoa(count) + " keys in " + time.Since(start).String())
+	return nil
+}
+
+// saveRDB writes snapshot of all DBs into dbfilename, caller should hold savingMu
+// 快照是开始保存时刻的数据, 事务不会只保存一部分, 编码期间不阻塞写命令
+func (database *StandaloneDatabase) saveRDB() error {
+	start := time.Now()
+	filename := config.Properties.DbFilename
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	var dirty []int64
+	err = database.writeSnapshot(tmpFile, func() {
+		dirty = make([]int64, len(database.dbSet))
+		for i, db := range database.dbSet {
+			dirty[i] = db.getDirty()
+		}
+	}, nil)
+	if err != nil {
+		return fail(err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpFile.Name(), filename); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	// 保存期间新的修改不清零
+	for i, db := range database.dbSet {
+		db.addDirty(int(-dirty[i]))
+	}
+	atomic.StoreInt64(&database.lastSave, time.Now().Unix())
+	logger.Info("DB saved on disk in " + time.Since(start).String())
+	return nil
+}
+
+// writeSnapshot encodes all DBs as they were when it's called into w,
+// at is called at the time snapshot starts, writeAux writes aux fields after header if not nil
+func (database *StandaloneDatabase) writeSnapshot(w io.Writer, at func(), writeAux func(enc *rdb.Encoder) error) error {
+	database.snapshotMu.Lock()
+	defer database.snapshotMu.Unlock()
+	engine := database.startSnapshot(at)
+	writer := bufio.NewWriter(w)
+	enc := rdb.NewEncoder(writer)
+	err := enc.WriteHeader()
+	if err == nil && writeAux != nil {
+		err = writeAux(enc)
+	}
+	if err == nil {
+		err = enc.WriteDBEngine(engine, len(database.dbSet))
+	}
+	if stopErr := engine.stop(); err == nil {
+		err = stopErr
+	}
+	if err == nil {
+		err = enc.WriteEnd()
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	return err
+}
+
+// Save saves snapshot and blocks until finished
+func (database *StandaloneDatabase) Save() error {
+	if !database.savingMu.TryLock() {
+		return errSaveInProgress
+	}
+	defer database.savingMu.Unlock()
+	return database.saveRDB()
+}
+
+// BGSave starts saving snapshot in background
+func (database *StandaloneDatabase) BGSave() error {
+	if !database.savingMu.TryLock() {
+		return errSaveInProgress
+	}
+	go func() {
+		defer database.savingMu.Unlock()
+		if err := database.saveRDB(); err != nil {
+			atomic.StoreInt64(&database.lastBgSaveFailed, time.Now().Unix())
+			logger.Error("background saving failed: " + err.Error())
+			return
+		}
+		atomic.StoreInt64(&database.lastBgSaveFailed, 0)
+	}()
+	return nil
+}
+
+// startSaveCron checks save rules every second and starts background saving if any rule matched
+func (database *StandaloneDatabase) startSaveCron() {
+	stop := make(chan struct{})
+	database.stopSaveCron = stop
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if database.needSave() {
+					if err := database.BGSave(); err != nil && err != errSaveInProgress {
+						logger.Error("background saving failed: " + err.Error())
+					}
+				}
+			case <-stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (database *StandaloneDatabase) needSave() bool {
+	now := time.Now().Unix()
+	if failed := atomic.LoadInt64(&database.lastBgSaveFailed); failed > 0 &&
+		now-failed <= int64(bgSaveRetryDelay/time.Second) {
+		return false
+	}
+	dirty := database.dirty()
+	lastSave := atomic.LoadInt64(&database.lastSave)
+	for _, rule := range database.saveRules {
+		if dirty >= rule.changes && now-lastSave >= rule.seconds {
+			return true
+		}
+	}
+	return false
+}
+
+// closeRDB stops auto saving and saves snapshot before shutdown if save rules configured
+func (database *StandaloneDatabase) closeRDB() {
+	if database.stopSaveCron == nil {
+		return
+	}
+	close(database.stopSaveCron)
+	database.stopSaveCron = nil
+	// 等待正在进行的后台保存结束
+	database.savingMu.Lock()
+	defer database.savingMu.Unlock()
+	if err := database.saveRDB(); err != nil {
+		logger.Error("saving before shutdown failed: " + err.Error())
+	}
+}
+
+func execSave(database *StandaloneDatabase) redis.Reply {
+	if err := database.Save(); err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// execBGSave executes BGSAVE [SCHEDULE], SCHEDULE makes no difference since saving never conflicts with aof rewrite
+func execBGSave(database *StandaloneDatabase, args [][]byte) redis.Reply {
+	if len(args) > 1 || len(args) == 1 && strings.ToLower(string(args[0])) != "schedule" {
+		return reply.MakeSyntaxErrReply()
+	}
+	if err := database.BGSave(); err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeStatusReply("Background saving started")
+}
+
+func execLastSave(database *StandaloneDatabase) redis.Reply {
+	return reply.MakeIntReply(atomic.LoadInt64(&database.lastSave))
+}
+
+func init() {
+	registerSpecialCommand("Save", 1, "admin", "slow", "dangerous")
+	registerSpecialCommand("BGSave", -1, "admin", "slow", "dangerous")
+	registerSpecialCommand("LastSave", 1, "admin", "fast", "dangerous")
+}
@@ -0,0 +1,490 @@
+package database
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"redisgo/aof"
+	"redisgo/config"
+	"redisgo/interface/redis"
+	"redisgo/lib/logger"
+	"redisgo/lib/utils"
+	"redisgo/redis/connection"
+	"redisgo/redis/parser"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 从节点的复制流程:
+// 1. 连接主节点, 依次发送 PING, AUTH, REPLCONF, PSYNC
+// 2. 全量同步时接收 RDB 保存到临时文件, 清空所有DB后通过内部连接重放, 数据同时写入本地的 AOF
+// 3. 之后持续读取复制流, 通过同一个内部连接执行, 每秒发送 REPLCONF ACK 上报已处理的偏移量
+// 4. 断线后重连, 使用主节点的 replid 和偏移量尝试部分同步
+
+const (
+	replStateConnect    = "connect"
+	replStateConnecting = "connecting"
+	replStateSync       = "sync"
+	replStateConnected  = "connected"
+
+	replRetryDelay = time.Second
+	replAckPeriod  = time.Second
+)
+
+var errReplStopped = errors.New("replication stopped")
+
+// slaveStatus holds replication state as a replica
+type slaveStatus struct {
+	host string
+	port int
+
+	mu    sync.Mutex
+	state string
+	// 当前与主节点的连接, 停止复制时关闭以中断阻塞的读取
+	conn    net.Conn
+	stopped bool
+	// masterReplID 和 offset 用于断线重连后的部分同步, offset 为 -1 表示还没有同步过
+	masterReplID string
+	offset       int64
+	// 执行复制流的内部连接, 部分同步时保留已选择的DB
+	fakeConn *connection.Connection
+	// 复制协程和 ACK 协程都会向主节点写入
+	writeMu sync.Mutex
+}
+
+func makeSlaveStatus(host string, port int) *slaveStatus {
+	return &slaveStatus{
+		host:   host,
+		port:   port,
+		state:  replStateConnect,
+		offset: -1,
+	}
+}
+
+func (s *slaveStatus) setState(state string) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+}
+
+func (s *slaveStatus) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// stop stops replication and closes connection to master
+func (s *slaveStatus) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+}
+
+func (s *slaveStatus) getOffset() int64 {
+	return atomic.LoadInt64(&s.offset)
+}
+
+func (s *slaveStatus) addOffset(n int64) {
+	atomic.AddInt64(&s.offset, n)
+}
+
+func (s *slaveStatus) send(args ...string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := s.conn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes())
+	return err
+}
+
+func (s *slaveStatus) sendAck() error {
+	return s.send("REPLCONF", "ACK", strconv.FormatInt(s.getOffset(), 10))
+}
+
+func (s *slaveStatus) role() redis.Reply {
+	s.mu.Lock()
+	state := s.state
+	s.mu.Unlock()
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("slave")),
+		reply.MakeBulkReply([]byte(s.host)),
+		reply.MakeIntReply(int64(s.port)),
+		reply.MakeBulkReply([]byte(state)),
+		reply.MakeIntReply(s.getOffset()),
+	})
+}
+
+// getSlave returns replication state as a replica, nil if this server is a master
+func (database *StandaloneDatabase) getSlave() *slaveStatus {
+	database.replMu.Lock()
+	defer database.replMu.Unlock()
+	return database.slave
+}
+
+func (database *StandaloneDatabase) isReplica() bool {
+	return database.getSlave() != nil
+}
+
+// isReadOnlyFor tells whether the connection is not allowed to write, commands from master are executed by internal connection
+func (database *StandaloneDatabase) isReadOnlyFor(c redis.Connection) bool {
+	return config.Properties.ReplicaReadOnly && !isInternalConn(c) && database.isReplica()
+}
+
+// startReplication makes this server a replica of the given master
+func (database *StandaloneDatabase) startReplication(host string, port int) {
+	database.replMu.Lock()
+	old := database.slave
+	s := makeSlaveStatus(host, port)
+	database.slave = s
+	database.replMu.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	// 不支持级联复制, 成为从节点后断开自己的从节点
+	database.master.disconnectReplicas()
+	logger.Info("start replication with master " + net.JoinHostPort(host, strconv.Itoa(port)))
+	go database.replicationLoop(s)
+}
+
+// stopReplication makes this server a master, data synchronized from the old master is kept
+func (database *StandaloneDatabase) stopReplication() {
+	database.replMu.Lock()
+	s := database.slave
+	database.slave = nil
+	database.replMu.Unlock()
+	if s == nil {
+		return
+	}
+	s.stop()
+	// 数据与之前的主节点不再相同, 使用新的 replid, 之前的从节点需要全量同步
+	database.master.reset()
+	logger.Info("replication stopped, this server is a master now")
+}
+
+// replicationLoop keeps synchronizing with master until replication stopped
+func (database *StandaloneDatabase) replicationLoop(s *slaveStatus) {
+	for !s.isStopped() {
+		err := database.syncWithMaster(s)
+		if s.isStopped() {
+			return
+		}
+		s.setState(replStateConnect)
+		if err != nil {
+			logger.Warn("replication with master failed: " + err.Error())
+		}
+		time.Sleep(replRetryDelay)
+	}
+}
+
+// syncWithMaster does handshake with master and applies replication stream until connection broken
+func (database *StandaloneDatabase) syncWithMaster(s *slaveStatus) error {
+	s.setState(replStateConnecting)
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	conn, err := net.DialTimeout("tcp", addr, replTimeout)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		_ = conn.Close()
+		return errReplStopped
+	}
+	s.conn = conn
+	s.mu.Unlock()
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	if err := database.handshake(s, reader); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	s.setState(replStateConnected)
+	logger.Info("master <-> replica sync finished, streaming from offset " + strconv.FormatInt(s.getOffset(), 10))
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.ackLoop(done)
+	return database.applyStream(s, conn, reader)
+}
+
+// handshake authenticates with master and synchronizes data with PSYNC
+func (database *StandaloneDatabase) handshake(s *slaveStatus, reader *bufio.Reader) error {
+	_ = s.conn.SetDeadline(time.Now().Add(replTimeout))
+	if err := s.send("PING"); err != nil {
+		return err
+	}
+	// 主节点需要认证时 PING 返回 NOAUTH, 同样说明主节点可用
+	if _, err := readReplLine(reader); err != nil && !strings.HasPrefix(err.Error(), "NOAUTH") {
+		return err
+	}
+	if config.Properties.MasterAuth != "" {
+		args := []string{"AUTH", config.Properties.MasterAuth}
+		if config.Properties.MasterUser != "" {
+			args = []string{"AUTH", config.Properties.MasterUser, config.Properties.MasterAuth}
+		}
+		if err := s.send(args...); err != nil {
+			return err
+		}
+		if _, err := readReplLine(reader); err != nil {
+			return errors.New("unable to AUTH to master: " + err.Error())
+		}
+	}
+	if err := s.send("REPLCONF", "listening-port", strconv.Itoa(config.Properties.Port)); err != nil {
+		return err
+	}
+	if _, err := readReplLine(reader); err != nil {
+		// 旧版本的主节点不支持, 可以忽略
+		logger.Warn("master does not understand REPLCONF listening-port: " + err.Error())
+	}
+	if err := s.send("REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+	if _, err := readReplLine(reader); err != nil {
+		logger.Warn("master does not understand REPLCONF capa: " + err.Error())
+	}
+
+	s.mu.Lock()
+	replID, offset := s.masterReplID, s.offset
+	s.mu.Unlock()
+	if replID == "" || offset < 0 {
+		replID, offset = "?", -2
+	}
+	if err := s.send("PSYNC", replID, strconv.FormatInt(offset+1, 10)); err != nil {
+		return err
+	}
+	line, err := readReplLine(reader)
+	if err != nil {
+		return errors.New("PSYNC failed: " + err.Error())
+	}
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		// 主节点的 replid 可能已经改变, 之后的部分同步使用新的 replid
+		if len(fields) >= 2 {
+			s.mu.Lock()
+			s.masterReplID = fields[1]
+			s.mu.Unlock()
+		}
+		logger.Info("partial resynchronization succeeded")
+		return nil
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return errors.New("invalid FULLRESYNC reply: " + line)
+		}
+		s.setState(replStateSync)
+		if err := database.loadFromMaster(s, reader); err != nil {
+			// 数据可能已被部分清空, 下次必须全量同步
+			s.mu.Lock()
+			s.masterReplID, s.offset = "", -1
+			s.mu.Unlock()
+			return err
+		}
+		s.mu.Lock()
+		s.masterReplID = fields[1]
+		atomic.StoreInt64(&s.offset, masterOffset)
+		// 加载快照后处于 DB 0, 主节点会在第一条命令前发送 SELECT
+		s.fakeConn = &connection.Connection{}
+		s.fakeConn.SetAuthenticated(true)
+		s.mu.Unlock()
+		return nil
+	}
+	return errors.New("unexpected reply to PSYNC: " + line)
+}
+
+// readReplLine reads a single line reply, error reply is returned as error
+// 主节点准备快照期间可能发送空行保持连接, 直接跳过
+func readReplLine(reader *bufio.Reader) (string, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+		if line[0] == '-' {
+			return "", errors.New(line[1:])
+		}
+		if line[0] == '+' {
+			return line[1:], nil
+		}
+		return line, nil
+	}
+}
+
+// loadFromMaster receives RDB of full synchronization into a temp file, then replaces all data with it
+func (database *StandaloneDatabase) loadFromMaster(s *slaveStatus, reader *bufio.Reader) error {
+	header, err := readReplLine(reader)
+	if err != nil {
+		return err
+	}
+	if len(header) < 2 || header[0] != '$' {
+		return errors.New("bad protocol from master, expect RDB bulk: " + header)
+	}
+	size, err := strconv.ParseInt(header[1:], 10, 64)
+	if err != nil || size < 0 {
+		return errors.New("bad protocol from master, invalid RDB size: " + header)
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(config.Properties.DbFilename), "temp-repl-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	// RDB 可能很大, 每收到一块数据就延长超时时间
+	buf := make([]byte, 64<<10)
+	for remain := size; remain > 0; {
+		_ = s.conn.SetReadDeadline(time.Now().Add(replTimeout))
+		chunk := buf
+		if remain < int64(len(chunk)) {
+			chunk = chunk[:remain]
+		}
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil {
+			_ = tmpFile.Close()
+			return err
+		}
+		if _, err := tmpFile.Write(chunk[:n]); err != nil {
+			_ = tmpFile.Close()
+			return err
+		}
+		remain -= int64(n)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	logger.Info("received " + strconv.FormatInt(size, 10) + " bytes RDB from master, loading")
+
+	// 清空和加载都通过内部连接执行, 本地开启 AOF 时同样会记录下来
+	start := time.Now()
+	loadingConn := &connection.Connection{}
+	loadingConn.SetAuthenticated(true)
+	for i := range database.dbSet {
+		database.Exec(loadingConn, utils.ToCmdLine("select", strconv.Itoa(i)))
+		database.Exec(loadingConn, utils.ToCmdLine("flushdb"))
+	}
+	database.Exec(loadingConn, utils.ToCmdLine("select", "0"))
+	err = aof.ReadFile(tmpFile.Name(), func(cmdLine aof.CmdLine) bool {
+		if s.isStopped() {
+			return false
+		}
+		database.Exec(loadingConn, cmdLine)
+		return true
+	})
+	if err != nil {
+		return errors.New("load RDB from master failed: " + err.Error())
+	}
+	if s.isStopped() {
+		return errReplStopped
+	}
+	logger.Info("RDB from master loaded in " + time.Since(start).String())
+	return nil
+}
+
+// applyStream executes commands from master until connection broken
+func (database *StandaloneDatabase) applyStream(s *slaveStatus, conn net.Conn, reader *bufio.Reader) error {
+	_ = conn.SetReadDeadline(time.Now().Add(replTimeout))
+	ch := parser.ParseStream(reader)
+	defer func() {
+		// 连接关闭后解析协程还会发送最后一个错误, 读完避免协程泄漏
+		_ = conn.Close()
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+	for payload := range ch {
+		if payload.Err != nil {
+			if payload.Err == io.EOF {
+				return errors.New("connection closed by master")
+			}
+			return payload.Err
+		}
+		if s.isStopped() {
+			return errReplStopped
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(replTimeout))
+		size := int64(len(payload.Data.ToBytes()))
+		cmd, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok || len(cmd.Args) == 0 {
+			s.addOffset(size)
+			continue
+		}
+		cmdName := strings.ToLower(string(cmd.Args[0]))
+		if cmdName == "replconf" && len(cmd.Args) >= 2 && strings.ToLower(string(cmd.Args[1])) == "getack" {
+			// 和 redis 一样, 上报的偏移量不包括 GETACK 本身
+			if err := s.sendAck(); err != nil {
+				return err
+			}
+			s.addOffset(size)
+			continue
+		}
+		if cmdName != "ping" {
+			database.Exec(s.fakeConn, cmd.Args)
+		}
+		s.addOffset(size)
+	}
+	return errors.New("connection closed by master")
+}
+
+// ackLoop reports processed offset to master every second until done closed
+func (s *slaveStatus) ackLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(replAckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.sendAck(); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// execReplicaOf executes REPLICAOF host port or REPLICAOF NO ONE
+func execReplicaOf(database *StandaloneDatabase, args [][]byte) redis.Reply {
+	host := string(args[0])
+	if strings.ToLower(host) == "no" && strings.ToLower(string(args[1])) == "one" {
+		database.stopReplication()
+		return reply.MakeOkReply()
+	}
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return reply.MakeErrReply("ERR Invalid master port")
+	}
+	if s := database.getSlave(); s != nil && s.host == host && s.port == port {
+		return reply.MakeStatusReply("OK Already connected to specified master")
+	}
+	database.startReplication(host, port)
+	return reply.MakeOkReply()
+}
+
+// parseReplicaOf parses replicaof config such as "127.0.0.1 6379"
+func parseReplicaOf(raw string) (string, int, bool) {
+	fields := strings.Fields(raw)
+	if len(fields) != 2 {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return fields[0], port, true
+}
+
+func init() {
+	registerSpecialCommand("ReplicaOf", 3, "admin", "slow", "dangerous")
+	registerSpecialCommand("SlaveOf", 3, "admin", "slow", "dangerous")
+}
@@ -0,0 +1,638 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"redisgo/config"
+	"redisgo/interface/redis"
+	"redisgo/lib/logger"
+	"redisgo/lib/utils"
+	"redisgo/rdb"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 主节点的复制流程:
+// 1. 从节点发送 PSYNC <replid> <offset>, replid 相同且 offset 之后的数据仍在积压缓冲区中时回复 +CONTINUE, 只发送缺失的部分
+// 2. 否则回复 +FULLRESYNC <replid> <offset>, 然后发送 RDB 快照, 之后从 offset 开始发送积压缓冲区中的命令
+// 3. 写命令和 AOF 使用相同的入口写入积压缓冲区, 每个从节点有一个发送协程从缓冲区读取
+// 偏移量是复制流的字节数, 从节点定期通过 REPLCONF ACK <offset> 上报已处理的位置
+
+const (
+	// replPingPeriod is the interval of PING sent to replicas, keeps replicas knowing master is alive
+	replPingPeriod = 10 * time.Second
+	// replTimeout disconnects replicas which don't ack for a long time
+	replTimeout = 60 * time.Second
+	// replSendChunk limits bytes sent to replica in a single write
+	replSendChunk = 64 << 10
+)
+
+// replBacklog is a ring buffer holds the latest bytes of replication stream
+type replBacklog struct {
+	buf []byte
+	// end 是复制流的总字节数, 即主节点的复制偏移量, 缓冲区中保存 [end-len(buf), end) 的数据
+	end int64
+}
+
+func makeReplBacklog(size int) *replBacklog {
+	if size <= 0 {
+		size = 1 << 20
+	}
+	return &replBacklog{buf: make([]byte, size)}
+}
+
+// start returns the offset of the oldest byte in backlog
+func (b *replBacklog) start() int64 {
+	if b.end < int64(len(b.buf)) {
+		return 0
+	}
+	return b.end - int64(len(b.buf))
+}
+
+func (b *replBacklog) write(data []byte) {
+	size := int64(len(b.buf))
+	if int64(len(data)) > size {
+		b.end += int64(len(data)) - size
+		data = data[int64(len(data))-size:]
+	}
+	for len(data) > 0 {
+		pos := b.end % size
+		n := copy(b.buf[pos:], data)
+		data = data[n:]
+		b.end += int64(n)
+	}
+}
+
+// read returns at most max bytes from offset, returns false if the data has been overwritten
+func (b *replBacklog) read(offset int64, max int) ([]byte, bool) {
+	if offset < b.start() || offset > b.end {
+		return nil, false
+	}
+	n := b.end - offset
+	if n > int64(max) {
+		n = int64(max)
+	}
+	result := make([]byte, n)
+	size := int64(len(b.buf))
+	copied := 0
+	for int64(copied) < n {
+		pos := (offset + int64(copied)) % size
+		copied += copy(result[copied:n], b.buf[pos:])
+	}
+	return result, true
+}
+
+// replicaInfo is a replica connected to this server
+type replicaInfo struct {
+	conn redis.Connection
+	// 从节点通过 REPLCONF listening-port 告知的端口
+	listeningPort int
+	ackOffset     int64
+	ackTime       time.Time
+	// 发送协程读取该标志, 连接关闭后退出
+	closed bool
+}
+
+// addr returns ip and listening port of replica
+func (r *replicaInfo) addr() (string, int) {
+	ip := ""
+	if c, ok := r.conn.(interface{ RemoteAddr() net.Addr }); ok && c.RemoteAddr() != nil {
+		ip, _, _ = net.SplitHostPort(c.RemoteAddr().String())
+	}
+	return ip, r.listeningPort
+}
+
+// masterStatus holds replication state as a master, replicas of replicas are not supported
+type masterStatus struct {
+	mu sync.Mutex
+	// 积压缓冲区有新数据或从节点断开时唤醒发送协程
+	cond   *sync.Cond
+	replID string
+	// 第一个从节点连接时创建, 之前的写命令不需要记录
+	backlog *replBacklog
+	// 复制流中最后一次 SELECT 的DB, -1 表示下一条命令前必须发送 SELECT
+	selectedDB int
+	replicas   map[redis.Connection]*replicaInfo
+	// 已发送 REPLCONF 但还未开始同步的从节点的监听端口
+	listeningPorts map[redis.Connection]int
+	lastPing       time.Time
+	stopCron       chan struct{}
+	// 关闭后阻塞中的 WAIT 立即返回
+	closed bool
+}
+
+func makeMasterStatus() *masterStatus {
+	m := &masterStatus{
+		replID:         makeReplID(),
+		selectedDB:     -1,
+		replicas:       make(map[redis.Connection]*replicaInfo),
+		listeningPorts: make(map[redis.Connection]int),
+	}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+// makeReplID generates a random replication id of 40 hex characters
+func makeReplID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		// 随机数不可用时退化为时间戳, 只要和之前的不同即可
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// offset returns the master replication offset
+func (m *masterStatus) offset() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.backlog == nil {
+		return 0
+	}
+	return m.backlog.end
+}
+
+// propagate writes commands into the replication stream, it's called with commands written to aof
+func (m *masterStatus) propagate(dbIndex int, cmdLines ...CmdLine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.backlog == nil || len(cmdLines) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	if dbIndex != m.selectedDB {
+		buf.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex))).ToBytes())
+		m.selectedDB = dbIndex
+	}
+	for _, cmdLine := range cmdLines {
+		buf.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+	}
+	m.backlog.write(buf.Bytes())
+	m.cond.Broadcast()
+}
+
+// feed writes commands which don't depend on DB into the replication stream, such as PING
+func (m *masterStatus) feed(cmdLine CmdLine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.backlog == nil {
+		return
+	}
+	m.backlog.write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+	m.cond.Broadcast()
+}
+
+// replicaCount returns the number of replicas in sync
+func (m *masterStatus) replicaCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.replicas)
+}
+
+// addReplica registers a replica which will receive stream from offset
+func (m *masterStatus) addReplica(c redis.Connection, offset int64) {
+	m.mu.Lock()
+	r := &replicaInfo{
+		conn:          c,
+		listeningPort: m.listeningPorts[c],
+		ackOffset:     offset,
+		ackTime:       time.Now(),
+	}
+	delete(m.listeningPorts, c)
+	if old, ok := m.replicas[c]; ok {
+		old.closed = true
+	}
+	m.replicas[c] = r
+	m.cond.Broadcast()
+	m.mu.Unlock()
+	go m.sendToReplica(r, offset)
+}
+
+// removeReplica stops sending stream to the replica
+func (m *masterStatus) removeReplica(c redis.Connection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.listeningPorts, c)
+	r, ok := m.replicas[c]
+	if !ok {
+		return
+	}
+	r.closed = true
+	delete(m.replicas, c)
+	m.cond.Broadcast()
+}
+
+// sendToReplica sends replication stream to replica from offset until connection closed
+// 从节点落后太多, 需要的数据已被覆盖时断开连接, 从节点重连后全量同步
+func (m *masterStatus) sendToReplica(r *replicaInfo, offset int64) {
+	for {
+		m.mu.Lock()
+		for !r.closed && m.backlog.end <= offset {
+			m.cond.Wait()
+		}
+		if r.closed {
+			m.mu.Unlock()
+			return
+		}
+		data, ok := m.backlog.read(offset, replSendChunk)
+		m.mu.Unlock()
+		if !ok {
+			logger.Warn("replica is too far behind, its data has been overwritten in backlog")
+			closeReplicaConn(r.conn)
+			return
+		}
+		if err := r.conn.Write(data); err != nil {
+			closeReplicaConn(r.conn)
+			return
+		}
+		offset += int64(len(data))
+	}
+}
+
+func closeReplicaConn(c redis.Connection) {
+	if closer, ok := c.(interface{ Close() error }); ok {
+		_ = closer.Close()
+	}
+}
+
+// startReplCron sends PING to replicas periodically and disconnects replicas which timed out
+func (m *masterStatus) startReplCron() {
+	stop := make(chan struct{})
+	m.stopCron = stop
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				m.replCron()
+			case <-stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (m *masterStatus) replCron() {
+	now := time.Now()
+	var timeout []redis.Connection
+	m.mu.Lock()
+	for c, r := range m.replicas {
+		if now.Sub(r.ackTime) > replTimeout {
+			timeout = append(timeout, c)
+		}
+	}
+	needPing := len(m.replicas) > 0 && now.Sub(m.lastPing) >= replPingPeriod
+	if needPing {
+		m.lastPing = now
+	}
+	m.mu.Unlock()
+	for _, c := range timeout {
+		logger.Warn("disconnecting timed out replica")
+		closeReplicaConn(c)
+	}
+	if needPing {
+		m.feed(utils.ToCmdLine("PING"))
+	}
+}
+
+// disconnectReplicas closes connections of all replicas
+func (m *masterStatus) disconnectReplicas() {
+	m.mu.Lock()
+	conns := make([]redis.Connection, 0, len(m.replicas))
+	for c := range m.replicas {
+		conns = append(conns, c)
+	}
+	m.mu.Unlock()
+	for _, c := range conns {
+		closeReplicaConn(c)
+	}
+}
+
+// reset changes replication id and drops backlog, replicas have to do full synchronization
+func (m *masterStatus) reset() {
+	m.disconnectReplicas()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replID = makeReplID()
+	m.backlog = nil
+	m.selectedDB = -1
+}
+
+func (m *masterStatus) close() {
+	if m.stopCron != nil {
+		close(m.stopCron)
+		m.stopCron = nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for c, r := range m.replicas {
+		r.closed = true
+		delete(m.replicas, c)
+	}
+	m.cond.Broadcast()
+}
+
+// countAcked returns the number of replicas which have processed stream until offset, caller should hold mu
+func (m *masterStatus) countAcked(offset int64) int {
+	count := 0
+	for _, r := range m.replicas {
+		if r.ackOffset >= offset {
+			count++
+		}
+	}
+	return count
+}
+
+// waitForReplicas blocks until numReplicas replicas acked current offset or timeout, 0 means wait forever
+// 返回已确认的从节点数量, 超时时可能小于 numReplicas
+func (m *masterStatus) waitForReplicas(numReplicas int, timeout time.Duration) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var target int64
+	if m.backlog != nil {
+		target = m.backlog.end
+	}
+	acked := m.countAcked(target)
+	if acked >= numReplicas {
+		return acked
+	}
+	if m.backlog != nil {
+		// 让从节点立即上报偏移量, 不必等待下一次定时 ACK
+		m.backlog.write(reply.MakeMultiBulkReply(utils.ToCmdLine("REPLCONF", "GETACK", "*")).ToBytes())
+		m.cond.Broadcast()
+	}
+	timedOut := false
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			m.mu.Lock()
+			timedOut = true
+			m.cond.Broadcast()
+			m.mu.Unlock()
+		})
+		defer timer.Stop()
+	}
+	for !timedOut && !m.closed && acked < numReplicas {
+		m.cond.Wait()
+		acked = m.countAcked(target)
+	}
+	return acked
+}
+
+// goodReplicas returns the number of replicas whose last ack is not older than maxLag seconds
+func (m *masterStatus) goodReplicas(maxLag int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().Unix()
+	count := 0
+	for _, r := range m.replicas {
+		if now-r.ackTime.Unix() <= int64(maxLag) {
+			count++
+		}
+	}
+	return count
+}
+
+// hasEnoughReplicas checks min-replicas-to-write, always true for replicas or if the feature is disabled
+func (database *StandaloneDatabase) hasEnoughReplicas() bool {
+	minReplicas, maxLag := config.Properties.MinReplicasToWrite, config.Properties.MinReplicasMaxLag
+	if minReplicas <= 0 || maxLag <= 0 || database.isReplica() {
+		return true
+	}
+	return database.master.goodReplicas(maxLag) >= minReplicas
+}
+
+// execWait executes WAIT numreplicas timeout, timeout is in milliseconds
+func execWait(database *StandaloneDatabase, args [][]byte) redis.Reply {
+	if database.isReplica() {
+		return reply.MakeErrReply("ERR WAIT cannot be used with replica instances")
+	}
+	numReplicas, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return reply.MakeErrReply("ERR timeout is negative")
+	}
+	acked := database.master.waitForReplicas(numReplicas, time.Duration(timeout)*time.Millisecond)
+	return reply.MakeIntReply(int64(acked))
+}
+
+// makeSnapshot encodes all DBs into a temp RDB file and returns the replication offset at the time of snapshot,
+// caller should close and remove the file
+// 开始快照时没有写命令执行, 快照和偏移量是一致的
+func (database *StandaloneDatabase) makeSnapshot() (*os.File, string, int64, error) {
+	file, err := os.CreateTemp(filepath.Dir(config.Properties.DbFilename), "temp-repl-*.rdb")
+	if err != nil {
+		return nil, "", 0, err
+	}
+	var replID string
+	var offset int64
+	err = database.writeSnapshot(file, func() {
+		m := database.master
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.backlog == nil {
+			m.backlog = makeReplBacklog(config.Properties.ReplBacklogSize)
+		}
+		// 从节点加载快照后在 DB 0, 复制流中的第一条命令需要 SELECT
+		m.selectedDB = -1
+		replID, offset = m.replID, m.backlog.end
+	}, func(enc *rdb.Encoder) error {
+		if err := enc.WriteAux("repl-id", replID); err != nil {
+			return err
+		}
+		return enc.WriteAux("repl-offset", strconv.FormatInt(offset, 10))
+	})
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, "", 0, err
+	}
+	return file, replID, offset, nil
+}
+
+// tryPartialSync returns true if the replica can continue from offset
+func (m *masterStatus) tryPartialSync(replID string, offset int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if replID != m.replID || m.backlog == nil {
+		return false
+	}
+	return offset >= m.backlog.start() && offset <= m.backlog.end
+}
+
+// execPSync executes PSYNC <replid> <offset>, the reply is written directly and NoReply returned
+func execPSync(database *StandaloneDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if database.isReplica() {
+		return reply.MakeErrReply("ERR Replica can't accept PSYNC, chained replication is not supported")
+	}
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR Replica can't interact with the keyspace")
+	}
+	replID := string(args[0])
+	// 从节点发送的是期望的下一个字节的偏移量
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	m := database.master
+	if replID != "?" && database.master.tryPartialSync(replID, offset-1) {
+		if err := c.Write([]byte("+CONTINUE " + m.replID + "\r\n")); err != nil {
+			return &reply.NoReply{}
+		}
+		logger.Info("partial resynchronization accepted, continue from offset " + strconv.FormatInt(offset-1, 10))
+		m.addReplica(c, offset-1)
+		return &reply.NoReply{}
+	}
+	return fullSync(database, c, true)
+}
+
+// execSync executes SYNC of old protocol which only supports full synchronization
+func execSync(database *StandaloneDatabase, c redis.Connection) redis.Reply {
+	if database.isReplica() {
+		return reply.MakeErrReply("ERR Replica can't accept SYNC, chained replication is not supported")
+	}
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR Replica can't interact with the keyspace")
+	}
+	return fullSync(database, c, false)
+}
+
+func fullSync(database *StandaloneDatabase, c redis.Connection, psync bool) redis.Reply {
+	start := time.Now()
+	snapshot, replID, offset, err := database.makeSnapshot()
+	if err != nil {
+		logger.Error("make snapshot for replica failed: " + err.Error())
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	defer func() {
+		_ = snapshot.Close()
+		_ = os.Remove(snapshot.Name())
+	}()
+	info, err := snapshot.Stat()
+	if err != nil {
+		logger.Error("make snapshot for replica failed: " + err.Error())
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	size := info.Size()
+	var header []byte
+	if psync {
+		header = []byte("+FULLRESYNC " + replID + " " + strconv.FormatInt(offset, 10) + "\r\n")
+	}
+	header = append(header, []byte("$"+strconv.FormatInt(size, 10)+"\r\n")...)
+	if err := c.Write(header); err != nil {
+		return &reply.NoReply{}
+	}
+	// 分块发送, 不把整个快照读入内存
+	buf := make([]byte, replSendChunk)
+	for sent := int64(0); sent < size; {
+		n, err := snapshot.Read(buf)
+		if n > 0 {
+			if err := c.Write(buf[:n]); err != nil {
+				return &reply.NoReply{}
+			}
+			sent += int64(n)
+		}
+		if err != nil && sent < size {
+			logger.Error("read snapshot for replica failed: " + err.Error())
+			return &reply.NoReply{}
+		}
+	}
+	logger.Info("full resynchronization finished, rdb size " + strconv.FormatInt(size, 10) +
+		" in " + time.Since(start).String())
+	database.master.addReplica(c, offset)
+	return &reply.NoReply{}
+}
+
+// execReplConf executes REPLCONF sent by replicas
+// ACK 不需要回复, 其余选项回复 OK
+func execReplConf(database *StandaloneDatabase, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	m := database.master
+	for i := 0; i < len(args); i += 2 {
+		option := strings.ToLower(string(args[i]))
+		value := string(args[i+1])
+		switch option {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			m.mu.Lock()
+			m.listeningPorts[c] = port
+			m.mu.Unlock()
+		case "ack":
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return &reply.NoReply{}
+			}
+			m.mu.Lock()
+			if r, ok := m.replicas[c]; ok {
+				if offset > r.ackOffset {
+					r.ackOffset = offset
+				}
+				r.ackTime = time.Now()
+			}
+			// 唤醒等待 ACK 的 WAIT 命令
+			m.cond.Broadcast()
+			m.mu.Unlock()
+			return &reply.NoReply{}
+		case "getack":
+			// 只在复制流中有意义, 由从节点的复制协程处理
+			return &reply.NoReply{}
+		case "capa", "ip-address":
+		default:
+			return reply.MakeErrReply("ERR Unrecognized REPLCONF option: " + option)
+		}
+	}
+	return reply.MakeOkReply()
+}
+
+// execRole executes ROLE
+func execRole(database *StandaloneDatabase) redis.Reply {
+	if slave := database.getSlave(); slave != nil {
+		return slave.role()
+	}
+	m := database.master
+	offset := m.offset()
+	m.mu.Lock()
+	replicas := make([]redis.Reply, 0, len(m.replicas))
+	for _, r := range m.replicas {
+		ip, port := r.addr()
+		replicas = append(replicas, reply.MakeMultiBulkReply(utils.ToCmdLine(
+			ip, strconv.Itoa(port), strconv.FormatInt(r.ackOffset, 10))))
+	}
+	m.mu.Unlock()
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("master")),
+		reply.MakeIntReply(offset),
+		reply.MakeMultiRawReply(replicas),
+	})
+}
+
+func init() {
+	registerSpecialCommand("PSync", -3, "admin", "slow", "dangerous")
+	registerSpecialCommand("Sync", 1, "admin", "slow", "dangerous")
+	registerSpecialCommand("ReplConf", -1, "admin", "slow", "dangerous")
+	registerSpecialCommand("Role", 1, "admin", "fast", "dangerous")
+	registerSpecialCommand("Wait", 3, "connection", "slow")
+}
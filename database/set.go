@@ -0,0 +1,504 @@
+package database
+
+import (
+	Dict "redisgo/datastruct/dict"
+	HashSet "redisgo/datastruct/set"
+	"redisgo/interface/database"
+	"redisgo/interface/redis"
+	"redisgo/lib/utils"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsSet(key string) (*HashSet.Set, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	set, ok := entity.Data.(*HashSet.Set)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return set, nil
+}
+
+func (db *DB) getOrInitSet(key string) (set *HashSet.Set, inited bool, errReply reply.ErrorReply) {
+	set, errReply = db.getAsSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if set == nil {
+		set = HashSet.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: set,
+		})
+		inited = true
+	}
+	return set, inited, nil
+}
+
+func membersToReply(members []string) redis.Reply {
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execSAdd adds members into set
+func execSAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	set, _, errReply := db.getOrInitSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	counter := 0
+	for _, member := range members {
+		counter += set.Add(string(member))
+	}
+	db.addAof(utils.ToCmdLine2("sadd", args...))
+	return reply.MakeIntReply(int64(counter))
+}
+
+// execSIsMember checks if the given value is member of set
+func execSIsMember(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	if set.Has(member) {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+// execSMIsMember checks if the given values are members of set
+func execSMIsMember(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := make([]redis.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		if set.Has(string(member)) {
+			result[i] = reply.MakeIntReply(1)
+		} else {
+			result[i] = reply.MakeIntReply(0)
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execSRem removes a member from set
+func execSRem(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeIntReply(0)
+	}
+	counter := 0
+	for _, member := range members {
+		counter += set.Remove(string(member))
+	}
+	if set.Len() == 0 {
+		db.Remove(key)
+	}
+	if counter > 0 {
+		db.addAof(utils.ToCmdLine2("srem", args...))
+	}
+	return reply.MakeIntReply(int64(counter))
+}
+
+// execSPop removes and returns random members from set
+// SPOP key [count]
+func execSPop(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+	withCount := len(args) == 2
+	count := 1
+	if withCount {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = int(count64)
+	}
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		if withCount {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		return &reply.NullBulkReply{}
+	}
+
+	members := set.RandomDistinctMembers(count)
+	for _, member := range members {
+		set.Remove(member)
+	}
+	if set.Len() == 0 {
+		db.Remove(key)
+	}
+	if len(members) > 0 {
+		// 随机结果以SREM的形式写入AOF, 保证重放结果一致
+		line := utils.ToCmdLine("srem", key)
+		for _, member := range members {
+			line = append(line, []byte(member))
+		}
+		db.addAof(line)
+	}
+	if !withCount {
+		return reply.MakeBulkReply([]byte(members[0]))
+	}
+	return membersToReply(members)
+}
+
+// execSCard gets the number of members in a set
+func execSCard(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(set.Len()))
+}
+
+// execSMembers gets all members in a set
+func execSMembers(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return membersToReply(set.ToSlice())
+}
+
+// execSMove moves a member from one set to another
+// SMOVE source destination member
+func execSMove(db *DB, args [][]byte) redis.Reply {
+	src := string(args[0])
+	dest := string(args[1])
+	member := string(args[2])
+
+	srcSet, errReply := db.getAsSet(src)
+	if errReply != nil {
+		return errReply
+	}
+	destSet, errReply := db.getAsSet(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if srcSet == nil || !srcSet.Has(member) {
+		return reply.MakeIntReply(0)
+	}
+
+	if src == dest { // 数据没有变化, 不写入AOF
+		return reply.MakeIntReply(1)
+	}
+	srcSet.Remove(member)
+	if srcSet.Len() == 0 {
+		db.Remove(src)
+	}
+	if destSet == nil {
+		destSet, _, _ = db.getOrInitSet(dest)
+	}
+	destSet.Add(member)
+	db.addAof(utils.ToCmdLine2("smove", args...))
+	return reply.MakeIntReply(1)
+}
+
+// getSets returns sets of the given keys, a nil set means the key does not exist
+func (db *DB) getSets(keys [][]byte) ([]*HashSet.Set, reply.ErrorReply) {
+	sets := make([]*HashSet.Set, len(keys))
+	for i, key := range keys {
+		set, errReply := db.getAsSet(string(key))
+		if errReply != nil {
+			return nil, errReply
+		}
+		sets[i] = set
+	}
+	return sets, nil
+}
+
+func intersectSets(sets []*HashSet.Set) *HashSet.Set {
+	var result *HashSet.Set
+	for _, set := range sets {
+		if set == nil { // 任意一个集合为空则交集为空
+			return HashSet.Make()
+		}
+		if result == nil {
+			result = HashSet.Make(set.ToSlice()...)
+		} else {
+			result = result.Intersect(set)
+		}
+		if result.Len() == 0 {
+			break
+		}
+	}
+	return result
+}
+
+func unionSets(sets []*HashSet.Set) *HashSet.Set {
+	result := HashSet.Make()
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		result = result.Union(set)
+	}
+	return result
+}
+
+func diffSets(sets []*HashSet.Set) *HashSet.Set {
+	if sets[0] == nil {
+		return HashSet.Make()
+	}
+	result := HashSet.Make(sets[0].ToSlice()...)
+	for _, set := range sets[1:] {
+		if set == nil {
+			continue
+		}
+		result = result.Diff(set)
+		if result.Len() == 0 {
+			break
+		}
+	}
+	return result
+}
+
+// setOpGeneric computes the result of the given set operation on keys
+func setOpGeneric(db *DB, keys [][]byte, op func([]*HashSet.Set) *HashSet.Set) redis.Reply {
+	sets, errReply := db.getSets(keys)
+	if errReply != nil {
+		return errReply
+	}
+	result := op(sets)
+	if result.Len() == 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return membersToReply(result.ToSlice())
+}
+
+// setOpStoreGeneric stores the result of the given set operation into dest
+func setOpStoreGeneric(db *DB, cmdName string, args [][]byte, op func([]*HashSet.Set) *HashSet.Set) redis.Reply {
+	dest := string(args[0])
+	sets, errReply := db.getSets(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	result := op(sets)
+
+	db.Remove(dest) // 覆盖原有的key, 无论其类型
+	if result.Len() > 0 {
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+	}
+	db.addAof(utils.ToCmdLine2(cmdName, args...))
+	return reply.MakeIntReply(int64(result.Len()))
+}
+
+// prepareSetCalculateStore locks destination for writing and source sets for reading
+func prepareSetCalculateStore(args [][]byte) ([]string, []string) {
+	dest := string(args[0])
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+	return []string{dest}, keys
+}
+
+// execSInter intersect multiple sets
+func execSInter(db *DB, args [][]byte) redis.Reply {
+	return setOpGeneric(db, args, intersectSets)
+}
+
+// execSInterStore intersects multiple sets and store the result in a key
+func execSInterStore(db *DB, args [][]byte) redis.Reply {
+	return setOpStoreGeneric(db, "sinterstore", args, intersectSets)
+}
+
+// execSUnion adds multiple sets
+func execSUnion(db *DB, args [][]byte) redis.Reply {
+	return setOpGeneric(db, args, unionSets)
+}
+
+// execSUnionStore adds multiple sets and store the result in a key
+func execSUnionStore(db *DB, args [][]byte) redis.Reply {
+	return setOpStoreGeneric(db, "sunionstore", args, unionSets)
+}
+
+// execSDiff subtracts multiple sets
+func execSDiff(db *DB, args [][]byte) redis.Reply {
+	return setOpGeneric(db, args, diffSets)
+}
+
+// execSDiffStore subtracts multiple sets and store the result in a key
+func execSDiffStore(db *DB, args [][]byte) redis.Reply {
+	return setOpStoreGeneric(db, "sdiffstore", args, diffSets)
+}
+
+// parseSInterCardArgs parses numkeys key [key ...] [LIMIT limit], returns keys and limit (0 means unlimited)
+func parseSInterCardArgs(args [][]byte) ([][]byte, int, reply.ErrorReply) {
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil || numKeys <= 0 {
+		return nil, 0, reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-1) {
+		return nil, 0, reply.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys := args[1 : numKeys+1]
+	rest := args[numKeys+1:]
+	limit := 0
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "LIMIT" {
+			return nil, 0, reply.MakeSyntaxErrReply()
+		}
+		limit64, err := strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil || limit64 < 0 {
+			return nil, 0, reply.MakeErrReply("ERR LIMIT can't be negative")
+		}
+		limit = int(limit64)
+	}
+	return keys, limit, nil
+}
+
+func prepareSInterCard(args [][]byte) ([]string, []string) {
+	keys, _, errReply := parseSInterCardArgs(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return readAllKeys(keys)
+}
+
+// execSInterCard returns the cardinality of the intersection
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func execSInterCard(db *DB, args [][]byte) redis.Reply {
+	keys, limit, errReply := parseSInterCardArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	sets, errReply := db.getSets(keys)
+	if errReply != nil {
+		return errReply
+	}
+	card := intersectSets(sets).Len()
+	if limit > 0 && card > limit {
+		card = limit
+	}
+	return reply.MakeIntReply(int64(card))
+}
+
+// execSRandMember gets random members from set
+// SRANDMEMBER key [count]
+func execSRandMember(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if set == nil {
+			return &reply.NullBulkReply{}
+		}
+		members := set.RandomMembers(1)
+		return reply.MakeBulkReply([]byte(members[0]))
+	}
+
+	count64, errReply := parseRandomCount(args[1], false)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil || count64 == 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	if count64 > 0 { // 正数返回不重复的成员
+		return membersToReply(set.RandomDistinctMembers(int(count64)))
+	}
+	return membersToReply(set.RandomMembers(int(-count64))) // 负数允许重复
+}
+
+// execSScan incrementally iterates members of set
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func execSScan(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	cursor, count, pattern, errReply := parseScanArgs(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return makeScanReply(0, [][]byte{})
+	}
+
+	var match func(string) bool
+	if pattern != nil {
+		match = pattern.IsMatch
+	}
+	members, next := Dict.Scan(set.Dict(), cursor, count, match)
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
+	}
+	return makeScanReply(next, result)
+}
+
+func init() {
+	RegisterCommand("SAdd", execSAdd, writeFirstKey, -3, "write", "set", "fast")
+	RegisterCommand("SIsMember", execSIsMember, readFirstKey, 3, "read", "set", "fast")
+	RegisterCommand("SMIsMember", execSMIsMember, readFirstKey, -3, "read", "set", "fast")
+	RegisterCommand("SRem", execSRem, writeFirstKey, -3, "write", "set", "fast")
+	RegisterCommand("SPop", execSPop, writeFirstKey, -2, "write", "set", "fast")
+	RegisterCommand("SCard", execSCard, readFirstKey, 2, "read", "set", "fast")
+	RegisterCommand("SMembers", execSMembers, readFirstKey, 2, "read", "set", "slow")
+	RegisterCommand("SMove", execSMove, prepareRename, 4, "write", "set", "fast")
+	RegisterCommand("SInter", execSInter, readAllKeys, -2, "read", "set", "slow")
+	RegisterCommand("SInterStore", execSInterStore, prepareSetCalculateStore, -3, "write", "set", "slow")
+	RegisterCommand("SInterCard", execSInterCard, prepareSInterCard, -3, "read", "set", "slow")
+	RegisterCommand("SUnion", execSUnion, readAllKeys, -2, "read", "set", "slow")
+	RegisterCommand("SUnionStore", execSUnionStore, prepareSetCalculateStore, -3, "write", "set", "slow")
+	RegisterCommand("SDiff", execSDiff, readAllKeys, -2, "read", "set", "slow")
+	RegisterCommand("SDiffStore", execSDiffStore, prepareSetCalculateStore, -3, "write", "set", "slow")
+	RegisterCommand("SRandMember", execSRandMember, readFirstKey, -2, "read", "set", "slow")
+	RegisterCommand("SScan", execSScan, readFirstKey, -3, "read", "set", "slow")
+}
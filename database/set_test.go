@@ -0,0 +1,43 @@
+package database
+
+import (
+	"redisgo/lib/utils"
+	"redisgo/redis/connection"
+	"redisgo/redis/reply"
+	"testing"
+)
+
+func TestSRandMemberCountRange(t *testing.T) {
+	db := makeDB()
+	c := &connection.Connection{}
+	db.Exec(c, utils.ToCmdLine("sadd", "s", "a"))
+
+	for _, count := range []string{"-9223372036854775808", "-9223372036854775807"} {
+		result := db.Exec(c, utils.ToCmdLine("srandmember", "s", count))
+		if !reply.IsErrorReply(result) {
+			t.Errorf("count %s: expected error, got %s", count, result.ToBytes())
+		}
+	}
+	result := db.Exec(c, utils.ToCmdLine("srandmember", "s", "9223372036854775807"))
+	if multiBulk, ok := result.(*reply.MultiBulkReply); !ok || len(multiBulk.Args) != 1 {
+		t.Errorf("expected 1 member, got %s", result.ToBytes())
+	}
+}
+
+func TestSMoveToSameKey(t *testing.T) {
+	db := makeDB()
+	c := &connection.Connection{}
+	db.Exec(c, utils.ToCmdLine("sadd", "s", "a"))
+	var aofLines []CmdLine
+	db.addAof = func(lines ...CmdLine) {
+		aofLines = append(aofLines, lines...)
+	}
+
+	result := db.Exec(c, utils.ToCmdLine("smove", "s", "s", "a"))
+	if intReply, ok := result.(*reply.IntReply); !ok || intReply.Code != 1 {
+		t.Errorf("expected 1, got %s", result.ToBytes())
+	}
+	if len(aofLines) != 0 {
+		t.Errorf("SMOVE to the same key should not be written into AOF")
+	}
+}
@@ -0,0 +1,247 @@
+package database
+
+import (
+	"errors"
+	"redisgo/interface/database"
+	"redisgo/rdb"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 生成快照使用写时复制, 不在整个编码期间阻塞写命令:
+// 1. 持有写屏障的写锁, 在没有写命令执行的时刻记录复制偏移量等状态, 然后为每个 DB 开启快照
+// 2. 释放写屏障后逐个 key 加读锁编码, 编码过的 key 记为已访问
+// 3. 快照期间写命令在修改还没访问过的 key 之前先保存它当前的值, 编码器遇到这些 key 时使用保存的值
+// 快照只在开始时短暂地等待正在执行的写命令结束, 保存的值占用的内存和快照期间修改的 key 成正比
+// 仍然可能等待较久的情况: 写命令在等待 TCC 事务锁定的 key(最多到事务超时), 迁移 key 时发送 RESTORE 的网络往返
+
+// savedEntry is the value of key at the time snapshot started, nil entity means the key didn't exist
+type savedEntry struct {
+	entity     *database.DataEntity
+	expiration *time.Time
+}
+
+// dbSnapshot keeps keys of a DB unchanged for the encoder until they are visited
+type dbSnapshot struct {
+	mu      sync.Mutex
+	visited map[string]struct{}
+	saved   map[string]*savedEntry
+	err     error
+}
+
+// snapshotSlot holds the snapshot in progress of a DB, it's shared by copies of the DB
+type snapshotSlot struct {
+	v atomic.Value // *dbSnapshot
+}
+
+func (db *DB) currentSnapshot() *dbSnapshot {
+	s, _ := db.snapshot.v.Load().(*dbSnapshot)
+	return s
+}
+
+// startSnapshot freezes current data of DB, caller should make sure no write command is executing
+func (db *DB) startSnapshot() *dbSnapshot {
+	s := &dbSnapshot{
+		visited: make(map[string]struct{}),
+		saved:   make(map[string]*savedEntry),
+	}
+	db.snapshot.v.Store(s)
+	return s
+}
+
+func (db *DB) stopSnapshot() {
+	db.snapshot.v.Store((*dbSnapshot)(nil))
+}
+
+// preserve saves values of keys which haven't been visited by snapshot encoder before they are modified,
+// caller should hold write locks of keys
+func (db *DB) preserve(keys ...string) {
+	s := db.currentSnapshot()
+	if s == nil {
+		return
+	}
+	for _, key := range keys {
+		s.mu.Lock()
+		_, visited := s.visited[key]
+		_, saved := s.saved[key]
+		s.mu.Unlock()
+		if visited || saved {
+			continue
+		}
+		// 持有 key 的写锁, 编码器和其它写命令都不会访问这个 key, 可以在锁外复制
+		entry := &savedEntry{}
+		if entity, ok := db.GetEntity(key); ok {
+			copied, err := copyEntity(entity)
+			if err != nil {
+				s.fail(err)
+				continue
+			}
+			entry.entity = copied
+			if expireTime, ok := db.TTL(key); ok {
+				entry.expiration = &expireTime
+			}
+		}
+		s.mu.Lock()
+		if _, saved := s.saved[key]; !saved {
+			s.saved[key] = entry
+		}
+		s.mu.Unlock()
+	}
+}
+
+// preserveAll saves all keys which haven't been visited before the DB is cleared
+// 清空后旧的值不会再被修改, 只需要保留引用
+func (db *DB) preserveAll() {
+	s := db.currentSnapshot()
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db.data.ForEach(func(key string, val interface{}) bool {
+		if _, visited := s.visited[key]; visited {
+			return true
+		}
+		if _, saved := s.saved[key]; saved {
+			return true
+		}
+		entry := &savedEntry{}
+		expireTime, hasTTL := db.TTL(key)
+		if !hasTTL || !now.After(expireTime) {
+			entry.entity, _ = val.(*database.DataEntity)
+			if hasTTL {
+				entry.expiration = &expireTime
+			}
+		}
+		s.saved[key] = entry
+		return true
+	})
+}
+
+func (s *dbSnapshot) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+}
+
+// copyEntity makes a deep copy of entity by serializing it
+func copyEntity(entity *database.DataEntity) (*database.DataEntity, error) {
+	payload, err := rdb.Dump(entity)
+	if err != nil {
+		return nil, err
+	}
+	return rdb.Restore(payload)
+}
+
+// forEach traverses keys of DB as they were when snapshot started, expired keys are skipped
+func (s *dbSnapshot) forEach(db *DB, cb func(key string, entity *database.DataEntity, expiration *time.Time) bool) error {
+	for _, key := range db.data.Keys() {
+		if !s.visit(db, key, cb) {
+			return nil
+		}
+	}
+	// 开始快照之后 Keys 中的 key 要么访问过, 要么已经保存, 之后保存的都是快照时不存在的 key
+	s.mu.Lock()
+	saved := make(map[string]*savedEntry, len(s.saved))
+	for key, entry := range s.saved {
+		saved[key] = entry
+	}
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for key, entry := range saved {
+		if entry.entity == nil || entry.expiration != nil && now.After(*entry.expiration) {
+			continue
+		}
+		if !cb(key, entry.entity, entry.expiration) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *dbSnapshot) visit(db *DB, key string, cb func(key string, entity *database.DataEntity, expiration *time.Time) bool) bool {
+	keys := []string{key}
+	db.RWLocks(nil, keys)
+	defer db.RWUnLocks(nil, keys)
+	s.mu.Lock()
+	_, saved := s.saved[key]
+	if !saved {
+		s.visited[key] = struct{}{}
+	}
+	s.mu.Unlock()
+	if saved {
+		return true
+	}
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return true
+	}
+	var expiration *time.Time
+	if expireTime, ok := db.TTL(key); ok {
+		expiration = &expireTime
+	}
+	return cb(key, entity, expiration)
+}
+
+var errSnapshotStopped = errors.New("snapshot stopped")
+
+// snapshotEngine exposes data of all DBs at the time snapshot started, each DB can be traversed only once
+// 遍历完一个 DB 后立即停止它的快照, 释放保存的值
+type snapshotEngine struct {
+	*StandaloneDatabase
+	snapshots []*dbSnapshot
+	err       error
+}
+
+// startSnapshot starts snapshot of all DBs, at is called at the time snapshot starts
+// 先执行 at 再开启快照: 没有持有写屏障的 TCC 回滚命令如果在开启之前执行, 它在复制流中也一定在记录的偏移量之后,
+// 回滚命令是幂等的, 从节点重复执行不影响结果
+func (database *StandaloneDatabase) startSnapshot(at func()) *snapshotEngine {
+	database.writeBarrier.Lock()
+	defer database.writeBarrier.Unlock()
+	if at != nil {
+		at()
+	}
+	engine := &snapshotEngine{
+		StandaloneDatabase: database,
+		snapshots:          make([]*dbSnapshot, len(database.dbSet)),
+	}
+	for i, db := range database.dbSet {
+		engine.snapshots[i] = db.startSnapshot()
+	}
+	return engine
+}
+
+// ForEach traverses all keys of the given DB at the time snapshot started
+func (engine *snapshotEngine) ForEach(dbIndex int, cb func(key string, entity *database.DataEntity, expiration *time.Time) bool) {
+	s := engine.snapshots[dbIndex]
+	if s == nil {
+		engine.err = errSnapshotStopped
+		return
+	}
+	err := s.forEach(engine.dbSet[dbIndex], cb)
+	engine.dbSet[dbIndex].stopSnapshot()
+	engine.snapshots[dbIndex] = nil
+	if err != nil && engine.err == nil {
+		engine.err = err
+	}
+}
+
+// stop stops snapshot of DBs which haven't been traversed and returns the error occurred during traversal
+func (engine *snapshotEngine) stop() error {
+	for i, s := range engine.snapshots {
+		if s != nil {
+			engine.dbSet[i].stopSnapshot()
+			engine.snapshots[i] = nil
+		}
+	}
+	return engine.err
+}
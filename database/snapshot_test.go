@@ -0,0 +1,93 @@
+package database
+
+import (
+	"redisgo/datastruct/list"
+	"redisgo/interface/database"
+	"redisgo/lib/utils"
+	"redisgo/redis/connection"
+	"testing"
+	"time"
+)
+
+// 快照开始后的修改、删除和新建都不影响遍历结果
+func TestSnapshotIgnoresLaterWrites(t *testing.T) {
+	db := makeDB()
+	db.passive = true
+	c := &connection.Connection{}
+	db.Exec(c, utils.ToCmdLine("set", "str", "v1"))
+	db.Exec(c, utils.ToCmdLine("rpush", "list", "a", "b"))
+	db.Exec(c, utils.ToCmdLine("set", "del", "v"))
+	db.Exec(c, utils.ToCmdLine("set", "ttl", "v", "ex", "100"))
+
+	s := db.startSnapshot()
+	db.Exec(c, utils.ToCmdLine("set", "str", "v2"))
+	db.Exec(c, utils.ToCmdLine("rpush", "list", "c"))
+	db.Exec(c, utils.ToCmdLine("del", "del"))
+	db.Exec(c, utils.ToCmdLine("persist", "ttl"))
+	db.Exec(c, utils.ToCmdLine("set", "new", "v"))
+
+	entities := make(map[string]*database.DataEntity)
+	expirations := make(map[string]*time.Time)
+	err := s.forEach(db, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		// 遍历期间的修改同样不影响结果
+		db.Exec(c, utils.ToCmdLine("rpush", "list", "d"))
+		entities[key] = entity
+		expirations[key] = expiration
+		return true
+	})
+	db.stopSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entities) != 4 {
+		t.Fatalf("expected 4 keys, got %d", len(entities))
+	}
+	if _, ok := entities["new"]; ok {
+		t.Error("key created after snapshot should not be included")
+	}
+	if entity, ok := entities["str"]; !ok || string(entity.Data.([]byte)) != "v1" {
+		t.Error("expected old value of str")
+	}
+	if entity, ok := entities["list"]; !ok || entity.Data.(list.List).Len() != 2 {
+		t.Error("expected old value of list")
+	}
+	if _, ok := entities["del"]; !ok {
+		t.Error("key deleted after snapshot should be included")
+	}
+	if expirations["ttl"] == nil {
+		t.Error("expected old expiration of ttl")
+	}
+	if db.currentSnapshot() != nil {
+		t.Error("snapshot should be stopped")
+	}
+}
+
+// 清空之前没有遍历的 key 仍然会被遍历
+func TestSnapshotBeforeFlush(t *testing.T) {
+	db := makeDB()
+	db.passive = true
+	c := &connection.Connection{}
+	db.Exec(c, utils.ToCmdLine("set", "k1", "v"))
+	db.Exec(c, utils.ToCmdLine("set", "k2", "v"))
+
+	s := db.startSnapshot()
+	db.Flush()
+	db.Exec(c, utils.ToCmdLine("set", "k3", "v"))
+
+	count := 0
+	err := s.forEach(db, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		if key == "k3" {
+			t.Error("key created after snapshot should not be included")
+		}
+		count++
+		return true
+	})
+	db.stopSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 keys, got %d", count)
+	}
+}
@@ -0,0 +1,890 @@
+package database
+
+import (
+	"math"
+	HashSet "redisgo/datastruct/set"
+	SortedSet "redisgo/datastruct/sortedset"
+	"redisgo/interface/database"
+	"redisgo/interface/redis"
+	"redisgo/lib/utils"
+	"redisgo/redis/reply"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsSortedSet(key string) (*SortedSet.SortedSet, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	sortedSet, ok := entity.Data.(*SortedSet.SortedSet)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return sortedSet, nil
+}
+
+func (db *DB) getOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, inited bool, errReply reply.ErrorReply) {
+	sortedSet, errReply = db.getAsSortedSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if sortedSet == nil {
+		sortedSet = SortedSet.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: sortedSet,
+		})
+		inited = true
+	}
+	return sortedSet, inited, nil
+}
+
+func formatScore(score float64) []byte {
+	if math.IsInf(score, 1) {
+		return []byte("inf")
+	} else if math.IsInf(score, -1) {
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'g', -1, 64))
+}
+
+func parseScore(arg []byte) (float64, reply.ErrorReply) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, reply.MakeErrReply("ERR value is not a valid float")
+	}
+	return score, nil
+}
+
+func elementsToReply(elements []*SortedSet.Element, withScores bool) redis.Reply {
+	size := len(elements)
+	if withScores {
+		size *= 2
+	}
+	result := make([][]byte, 0, size)
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, formatScore(element.Score))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execZAdd adds members into sorted set
+// ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...]
+func execZAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	nx, xx, gt, lt, ch, incr := false, false, false, false, false, false
+	i := 1
+loop:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break loop
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if nx && xx {
+		return reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) != 2 {
+		return reply.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+	elements := make([]*SortedSet.Element, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, errReply := parseScore(pairs[j])
+		if errReply != nil {
+			return errReply
+		}
+		elements[j/2] = &SortedSet.Element{
+			Member: string(pairs[j+1]),
+			Score:  score,
+		}
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		if xx { // XX不会创建新的key
+			if incr {
+				return &reply.NullBulkReply{}
+			}
+			return reply.MakeIntReply(0)
+		}
+		sortedSet, _, _ = db.getOrInitSortedSet(key)
+	}
+
+	added, changed := 0, 0
+	var incrResult *float64
+	for _, e := range elements {
+		old, exists := sortedSet.Get(e.Member)
+		if (exists && nx) || (!exists && xx) {
+			continue
+		}
+		score := e.Score
+		if exists {
+			if incr {
+				score = old.Score + e.Score
+				if math.IsNaN(score) {
+					return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+				}
+			}
+			if (gt && score <= old.Score) || (lt && score >= old.Score) {
+				continue
+			}
+			if score != old.Score {
+				sortedSet.Add(e.Member, score)
+				changed++
+			}
+		} else {
+			sortedSet.Add(e.Member, score)
+			added++
+		}
+		incrResult = &score
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if added+changed > 0 {
+		db.addAof(utils.ToCmdLine2("zadd", args...))
+	}
+
+	if incr {
+		if incrResult == nil {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply(formatScore(*incrResult))
+	}
+	if ch {
+		return reply.MakeIntReply(int64(added + changed))
+	}
+	return reply.MakeIntReply(int64(added))
+}
+
+// execZScore gets score of a member in sorted set
+func execZScore(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	element, exists := sortedSet.Get(member)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeBulkReply(formatScore(element.Score))
+}
+
+// execZMScore gets scores of multiple members in sorted set
+func execZMScore(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(args)-1)
+	if sortedSet == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+	for i, member := range args[1:] {
+		element, exists := sortedSet.Get(string(member))
+		if exists {
+			result[i] = formatScore(element.Score)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execZIncrBy increments the score of a member
+func execZIncrBy(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	delta, errReply := parseScore(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	member := string(args[2])
+
+	sortedSet, _, errReply := db.getOrInitSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	score := delta
+	element, exists := sortedSet.Get(member)
+	if exists {
+		score = element.Score + delta
+		if math.IsNaN(score) {
+			return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+		}
+	}
+	sortedSet.Add(member, score)
+	db.addAof(utils.ToCmdLine2("zincrby", args...))
+	return reply.MakeBulkReply(formatScore(score))
+}
+
+// execZRem removes given members
+func execZRem(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	var deleted int64 = 0
+	for _, member := range args[1:] {
+		if sortedSet.Remove(string(member)) {
+			deleted++
+		}
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine2("zrem", args...))
+	}
+	return reply.MakeIntReply(deleted)
+}
+
+// execZCard gets number of members in sortedset
+func execZCard(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.Len())
+}
+
+func countGeneric(db *DB, args [][]byte, parseBorder func(string) (SortedSet.Border, error)) redis.Reply {
+	key := string(args[0])
+	min, err := parseBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := parseBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.RangeCount(min, max))
+}
+
+// execZCount gets number of members which score within given range
+func execZCount(db *DB, args [][]byte) redis.Reply {
+	return countGeneric(db, args, SortedSet.ParseScoreBorder)
+}
+
+// execZLexCount gets number of members which member within given lex range
+func execZLexCount(db *DB, args [][]byte) redis.Reply {
+	return countGeneric(db, args, SortedSet.ParseLexBorder)
+}
+
+func rankGeneric(db *DB, args [][]byte, desc bool) redis.Reply {
+	key := string(args[0])
+	member := string(args[1])
+	withScore := false
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHSCORE" {
+			return reply.MakeSyntaxErrReply()
+		}
+		withScore = true
+	} else if len(args) > 3 {
+		return reply.MakeSyntaxErrReply()
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		if withScore {
+			return reply.MakeNullMultiBulkReply()
+		}
+		return &reply.NullBulkReply{}
+	}
+
+	rank := sortedSet.GetRank(member, desc)
+	if rank < 0 {
+		if withScore {
+			return reply.MakeNullMultiBulkReply()
+		}
+		return &reply.NullBulkReply{}
+	}
+	if withScore {
+		element, _ := sortedSet.Get(member)
+		return reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeIntReply(rank),
+			reply.MakeBulkReply(formatScore(element.Score)),
+		})
+	}
+	return reply.MakeIntReply(rank)
+}
+
+// execZRank gets index of a member in sortedset, ascending order, start from 0
+// ZRANK key member [WITHSCORE]
+func execZRank(db *DB, args [][]byte) redis.Reply {
+	return rankGeneric(db, args, false)
+}
+
+// execZRevRank gets index of a member in sortedset, descending order, start from 0
+func execZRevRank(db *DB, args [][]byte) redis.Reply {
+	return rankGeneric(db, args, true)
+}
+
+/* ---- range ---- */
+
+// rangeSpec describes a ZRANGE query
+type rangeSpec struct {
+	byScore    bool
+	byLex      bool
+	rev        bool
+	withScores bool
+	hasLimit   bool
+	offset     int64
+	count      int64
+}
+
+// parseRangeOptions parses [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+// legacy commands such as ZRANGEBYSCORE only accept WITHSCORES and LIMIT
+func parseRangeOptions(args [][]byte, spec *rangeSpec, legacy bool) reply.ErrorReply {
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "BYSCORE":
+			if legacy {
+				return reply.MakeSyntaxErrReply()
+			}
+			spec.byScore = true
+		case "BYLEX":
+			if legacy {
+				return reply.MakeSyntaxErrReply()
+			}
+			spec.byLex = true
+		case "REV":
+			if legacy {
+				return reply.MakeSyntaxErrReply()
+			}
+			spec.rev = true
+		case "WITHSCORES":
+			spec.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			offset, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			count, err := strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			spec.hasLimit = true
+			spec.offset = offset
+			spec.count = count
+			i += 2
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if spec.byScore && spec.byLex {
+		return reply.MakeSyntaxErrReply()
+	}
+	if spec.hasLimit && !spec.byScore && !spec.byLex {
+		return reply.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.byLex {
+		return reply.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// rangeGeneric executes a ZRANGE query, for REV queries start is the upper border
+func rangeGeneric(db *DB, key string, start []byte, stop []byte, spec *rangeSpec) redis.Reply {
+	if spec.byScore || spec.byLex {
+		parseBorder := SortedSet.ParseScoreBorder
+		if spec.byLex {
+			parseBorder = SortedSet.ParseLexBorder
+		}
+		minArg, maxArg := start, stop
+		if spec.rev {
+			minArg, maxArg = stop, start
+		}
+		min, err := parseBorder(string(minArg))
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		max, err := parseBorder(string(maxArg))
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+
+		sortedSet, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if sortedSet == nil {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		offset, limit := int64(0), int64(-1)
+		if spec.hasLimit {
+			offset, limit = spec.offset, spec.count
+		}
+		elements := sortedSet.Range(min, max, offset, limit, spec.rev)
+		return elementsToReply(elements, spec.withScores)
+	}
+
+	start64, err := strconv.ParseInt(string(start), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop64, err := strconv.ParseInt(string(stop), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	from, to, ok := normalizeRange(start64, stop64, int(sortedSet.Len()))
+	if !ok {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	elements := sortedSet.RangeByRank(int64(from), int64(to), spec.rev)
+	return elementsToReply(elements, spec.withScores)
+}
+
+// execZRange gets members in range
+// ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func execZRange(db *DB, args [][]byte) redis.Reply {
+	spec := &rangeSpec{}
+	if errReply := parseRangeOptions(args[3:], spec, false); errReply != nil {
+		return errReply
+	}
+	return rangeGeneric(db, string(args[0]), args[1], args[2], spec)
+}
+
+// execZRevRange gets members in range, sort by descending order
+// ZREVRANGE key start stop [WITHSCORES]
+func execZRevRange(db *DB, args [][]byte) redis.Reply {
+	spec := &rangeSpec{rev: true}
+	if errReply := parseRangeOptions(args[3:], spec, true); errReply != nil {
+		return errReply
+	}
+	if spec.hasLimit {
+		return reply.MakeSyntaxErrReply()
+	}
+	return rangeGeneric(db, string(args[0]), args[1], args[2], spec)
+}
+
+// execZRangeByScore gets members which score within given range, in ascending order
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func execZRangeByScore(db *DB, args [][]byte) redis.Reply {
+	spec := &rangeSpec{byScore: true}
+	if errReply := parseRangeOptions(args[3:], spec, true); errReply != nil {
+		return errReply
+	}
+	return rangeGeneric(db, string(args[0]), args[1], args[2], spec)
+}
+
+// execZRevRangeByScore gets members which score within given range, in descending order
+// ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func execZRevRangeByScore(db *DB, args [][]byte) redis.Reply {
+	spec := &rangeSpec{byScore: true, rev: true}
+	if errReply := parseRangeOptions(args[3:], spec, true); errReply != nil {
+		return errReply
+	}
+	return rangeGeneric(db, string(args[0]), args[1], args[2], spec)
+}
+
+// execZRangeByLex gets members which member within given lex range
+// ZRANGEBYLEX key min max [LIMIT offset count]
+func execZRangeByLex(db *DB, args [][]byte) redis.Reply {
+	spec := &rangeSpec{byLex: true}
+	if errReply := parseRangeOptions(args[3:], spec, true); errReply != nil {
+		return errReply
+	}
+	return rangeGeneric(db, string(args[0]), args[1], args[2], spec)
+}
+
+// execZRevRangeByLex gets members which member within given lex range, in descending order
+// ZREVRANGEBYLEX key max min [LIMIT offset count]
+func execZRevRangeByLex(db *DB, args [][]byte) redis.Reply {
+	spec := &rangeSpec{byLex: true, rev: true}
+	if errReply := parseRangeOptions(args[3:], spec, true); errReply != nil {
+		return errReply
+	}
+	return rangeGeneric(db, string(args[0]), args[1], args[2], spec)
+}
+
+/* ---- remove range ---- */
+
+func removeRangeGeneric(db *DB, cmdName string, args [][]byte, parseBorder func(string) (SortedSet.Border, error)) redis.Reply {
+	key := string(args[0])
+	min, err := parseBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := parseBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	removed := sortedSet.RemoveRange(min, max)
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+	}
+	return reply.MakeIntReply(removed)
+}
+
+// execZRemRangeByScore removes members which score within given range
+func execZRemRangeByScore(db *DB, args [][]byte) redis.Reply {
+	return removeRangeGeneric(db, "zremrangebyscore", args, SortedSet.ParseScoreBorder)
+}
+
+// execZRemRangeByLex removes members which member within given lex range
+func execZRemRangeByLex(db *DB, args [][]byte) redis.Reply {
+	return removeRangeGeneric(db, "zremrangebylex", args, SortedSet.ParseLexBorder)
+}
+
+// execZRemRangeByRank removes members within given indexes
+func execZRemRangeByRank(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	start64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop64, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	start, stop, ok := normalizeRange(start64, stop64, int(sortedSet.Len()))
+	if !ok {
+		return reply.MakeIntReply(0)
+	}
+	removed := sortedSet.RemoveByRank(int64(start), int64(stop))
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2("zremrangebyrank", args...))
+	}
+	return reply.MakeIntReply(removed)
+}
+
+func popGenericZ(db *DB, cmdName string, args [][]byte, max bool) redis.Reply {
+	key := string(args[0])
+	count := 1
+	if len(args) == 2 {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = int(count64)
+	} else if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+
+	var removed []*SortedSet.Element
+	if max {
+		removed = sortedSet.PopMax(count)
+	} else {
+		removed = sortedSet.PopMin(count)
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if len(removed) > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+	}
+	return elementsToReply(removed, true)
+}
+
+// execZPopMin removes and returns members with the lowest scores
+// ZPOPMIN key [count]
+func execZPopMin(db *DB, args [][]byte) redis.Reply {
+	return popGenericZ(db, "zpopmin", args, false)
+}
+
+// execZPopMax removes and returns members with the highest scores
+// ZPOPMAX key [count]
+func execZPopMax(db *DB, args [][]byte) redis.Reply {
+	return popGenericZ(db, "zpopmax", args, true)
+}
+
+/* ---- union & intersect ---- */
+
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+func aggregate(a, b float64, aggregateType int) float64 {
+	switch aggregateType {
+	case aggregateMin:
+		return math.Min(a, b)
+	case aggregateMax:
+		return math.Max(a, b)
+	}
+	result := a + b
+	if math.IsNaN(result) { // inf + -inf
+		return 0
+	}
+	return result
+}
+
+// getAsWeightedSet reads a sorted set or a set (whose members have score 1) as member -> score map
+func (db *DB) getAsWeightedSet(key string) (map[string]float64, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	result := make(map[string]float64)
+	switch data := entity.Data.(type) {
+	case *SortedSet.SortedSet:
+		if data.Len() == 0 {
+			break
+		}
+		data.ForEachByRank(0, data.Len(), false, func(element *SortedSet.Element) bool {
+			result[element.Member] = element.Score
+			return true
+		})
+	case *HashSet.Set:
+		data.ForEach(func(member string) bool {
+			result[member] = 1
+			return true
+		})
+	default:
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return result, nil
+}
+
+// prepareZStore locks destination for writing and source keys for reading
+func prepareZStore(args [][]byte) ([]string, []string) {
+	dest := string(args[0])
+	numKeys, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || numKeys <= 0 || numKeys > int64(len(args)-2) {
+		return []string{dest}, nil
+	}
+	_, keys := readAllKeys(args[2 : 2+numKeys])
+	return []string{dest}, keys
+}
+
+// storeGeneric executes ZUNIONSTORE and ZINTERSTORE
+// destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM | MIN | MAX]
+func storeGeneric(db *DB, cmdName string, args [][]byte, intersect bool) redis.Reply {
+	dest := string(args[0])
+	numKeys, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return reply.MakeErrReply("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if numKeys > int64(len(args)-2) {
+		return reply.MakeSyntaxErrReply()
+	}
+	keys := args[2 : 2+numKeys]
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregateType := aggregateSum
+	options := args[2+numKeys:]
+	for i := 0; i < len(options); i++ {
+		switch strings.ToUpper(string(options[i])) {
+		case "WEIGHTS":
+			if i+int(numKeys) >= len(options) {
+				return reply.MakeSyntaxErrReply()
+			}
+			for j := range weights {
+				weight, err := strconv.ParseFloat(string(options[i+1+j]), 64)
+				if err != nil || math.IsNaN(weight) {
+					return reply.MakeErrReply("ERR weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += int(numKeys)
+		case "AGGREGATE":
+			if i+1 >= len(options) {
+				return reply.MakeSyntaxErrReply()
+			}
+			switch strings.ToUpper(string(options[i+1])) {
+			case "SUM":
+				aggregateType = aggregateSum
+			case "MIN":
+				aggregateType = aggregateMin
+			case "MAX":
+				aggregateType = aggregateMax
+			default:
+				return reply.MakeSyntaxErrReply()
+			}
+			i++
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	var result map[string]float64
+	for i, key := range keys {
+		set, errReply := db.getAsWeightedSet(string(key))
+		if errReply != nil {
+			return errReply
+		}
+		weighted := make(map[string]float64, len(set))
+		for member, score := range set {
+			score *= weights[i]
+			if math.IsNaN(score) { // inf * 0
+				score = 0
+			}
+			weighted[member] = score
+		}
+		if result == nil {
+			result = weighted
+			continue
+		}
+		if intersect {
+			for member, score := range result {
+				other, ok := weighted[member]
+				if !ok {
+					delete(result, member)
+					continue
+				}
+				result[member] = aggregate(score, other, aggregateType)
+			}
+		} else {
+			for member, score := range weighted {
+				if old, ok := result[member]; ok {
+					result[member] = aggregate(old, score, aggregateType)
+				} else {
+					result[member] = score
+				}
+			}
+		}
+	}
+
+	db.Remove(dest)
+	if len(result) > 0 {
+		sortedSet := SortedSet.Make()
+		for member, score := range result {
+			sortedSet.Add(member, score)
+		}
+		db.PutEntity(dest, &database.DataEntity{
+			Data: sortedSet,
+		})
+	}
+	db.addAof(utils.ToCmdLine2(cmdName, args...))
+	return reply.MakeIntReply(int64(len(result)))
+}
+
+// execZUnionStore stores the union of multiple sorted sets into destination
+func execZUnionStore(db *DB, args [][]byte) redis.Reply {
+	return storeGeneric(db, "zunionstore", args, false)
+}
+
+// execZInterStore stores the intersection of multiple sorted sets into destination
+func execZInterStore(db *DB, args [][]byte) redis.Reply {
+	return storeGeneric(db, "zinterstore", args, true)
+}
+
+func init() {
+	RegisterCommand("ZAdd", execZAdd, writeFirstKey, -4, "write", "sortedset", "fast")
+	RegisterCommand("ZScore", execZScore, readFirstKey, 3, "read", "sortedset", "fast")
+	RegisterCommand("ZMScore", execZMScore, readFirstKey, -3, "read", "sortedset", "fast")
+	RegisterCommand("ZIncrBy", execZIncrBy, writeFirstKey, 4, "write", "sortedset", "fast")
+	RegisterCommand("ZRank", execZRank, readFirstKey, -3, "read", "sortedset", "fast")
+	RegisterCommand("ZRevRank", execZRevRank, readFirstKey, -3, "read", "sortedset", "fast")
+	RegisterCommand("ZCount", execZCount, readFirstKey, 4, "read", "sortedset", "fast")
+	RegisterCommand("ZLexCount", execZLexCount, readFirstKey, 4, "read", "sortedset", "fast")
+	RegisterCommand("ZCard", execZCard, readFirstKey, 2, "read", "sortedset", "fast")
+	RegisterCommand("ZRange", execZRange, readFirstKey, -4, "read", "sortedset", "slow")
+	RegisterCommand("ZRevRange", execZRevRange, readFirstKey, -4, "read", "sortedset", "slow")
+	RegisterCommand("ZRangeByScore", execZRangeByScore, readFirstKey, -4, "read", "sortedset", "slow")
+	RegisterCommand("ZRevRangeByScore", execZRevRangeByScore, readFirstKey, -4, "read", "sortedset", "slow")
+	RegisterCommand("ZRangeByLex", execZRangeByLex, readFirstKey, -4, "read", "sortedset", "slow")
+	RegisterCommand("ZRevRangeByLex", execZRevRangeByLex, readFirstKey, -4, "read", "sortedset", "slow")
+	RegisterCommand("ZRem", execZRem, writeFirstKey, -3, "write", "sortedset", "fast")
+	RegisterCommand("ZRemRangeByScore", execZRemRangeByScore, writeFirstKey, 4, "write", "sortedset", "slow")
+	RegisterCommand("ZRemRangeByLex", execZRemRangeByLex, writeFirstKey, 4, "write", "sortedset", "slow")
+	RegisterCommand("ZRemRangeByRank", execZRemRangeByRank, writeFirstKey, 4, "write", "sortedset", "slow")
+	RegisterCommand("ZPopMin", execZPopMin, writeFirstKey, -2, "write", "sortedset", "fast")
+	RegisterCommand("ZPopMax", execZPopMax, writeFirstKey, -2, "write", "sortedset", "fast")
+	RegisterCommand("ZUnionStore", execZUnionStore, prepareZStore, -4, "write", "sortedset", "slow")
+	RegisterCommand("ZInterStore", execZInterStore, prepareZStore, -4, "write", "sortedset", "slow")
+}
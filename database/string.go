@@ -6,6 +6,8 @@ import (
 	"redisgo/lib/utils"
 	"redisgo/redis/reply"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func (db *DB) getAsString(key string) ([]byte, reply.ErrorReply) {
@@ -33,17 +35,135 @@ func execGet(db *DB, args [][]byte) redis.Reply {
 	return reply.MakeBulkReply(bytes)
 }
 
+const (
+	upsertPolicy = iota // default
+	insertPolicy        // set nx
+	updatePolicy        // set xx
+)
+
+const (
+	ttlDiscard = iota // default, SET会清除原有的过期时间
+	ttlKeep           // set keepttl
+	ttlSet            // set ex/px/exat/pxat
+)
+
 // execSet sets string value to given key
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
 func execSet(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	value := args[1]
+	policy := upsertPolicy
+	ttlPolicy := ttlDiscard
+	var expireAt int64 // unix milliseconds
+	returnOld := false
+
+	// parse options
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch arg {
+		case "NX":
+			if policy == updatePolicy {
+				return reply.MakeSyntaxErrReply()
+			}
+			policy = insertPolicy
+		case "XX":
+			if policy == insertPolicy {
+				return reply.MakeSyntaxErrReply()
+			}
+			policy = updatePolicy
+		case "GET":
+			returnOld = true
+		case "KEEPTTL":
+			if ttlPolicy == ttlSet {
+				return reply.MakeSyntaxErrReply()
+			}
+			ttlPolicy = ttlKeep
+		case "EX", "PX", "EXAT", "PXAT":
+			if ttlPolicy != ttlDiscard || i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			raw, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if raw <= 0 {
+				return reply.MakeErrReply("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if arg == "PX" || arg == "PXAT" {
+				unit = time.Millisecond
+			}
+			ms, errReply := parseExpireTime("set", args[i+1], unit, arg == "EXAT" || arg == "PXAT")
+			if errReply != nil {
+				return errReply
+			}
+			expireAt = ms
+			ttlPolicy = ttlSet
+			i++
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	var old []byte
+	if returnOld {
+		var errReply reply.ErrorReply
+		old, errReply = db.getAsString(key)
+		if errReply != nil {
+			return errReply
+		}
+	}
 
 	entity := &database.DataEntity{
 		Data: value,
 	}
-	db.PutEntity(key, entity)
-	db.addAof(utils.ToCmdLine2("set", args...))
-	return &reply.OKReply{}
+	// 先清理已过期的key, 否则 KEEPTTL 会保留旧的过期时间
+	db.GetEntity(key)
+	var result int
+	switch policy {
+	case upsertPolicy:
+		db.PutEntity(key, entity)
+		result = 1
+	case insertPolicy:
+		result = db.PutIfAbsent(key, entity)
+	case updatePolicy:
+		result = db.PutIfExists(key, entity)
+	}
+
+	if result > 0 {
+		// 以规范化的形式写入AOF, 过期时间统一使用绝对时间
+		switch ttlPolicy {
+		case ttlSet:
+			expireTime := time.UnixMilli(expireAt)
+			db.Expire(key, expireTime)
+			db.addAof(utils.ToCmdLine2("set", args[0], value, []byte("PXAT"), []byte(strconv.FormatInt(expireAt, 10))))
+		case ttlKeep:
+			db.addAof(utils.ToCmdLine2("set", args[0], value, []byte("KEEPTTL")))
+		default:
+			db.Persist(key)
+			db.addAof(utils.ToCmdLine2("set", args[0], value))
+		}
+	}
+
+	if returnOld {
+		if old == nil {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply(old)
+	}
+	if result > 0 {
+		return &reply.OKReply{}
+	}
+	return &reply.NullBulkReply{}
+}
+
+func prepareMSet(args [][]byte) ([]string, []string) {
+	size := len(args) / 2
+	keys := make([]string, size)
+	for i := 0; i < size; i++ {
+		keys[i] = string(args[2*i])
+	}
+	return keys, nil
 }
 
 // execMSet sets multi key-value in database
@@ -63,12 +183,31 @@ func execMSet(db *DB, args [][]byte) redis.Reply {
 	for i, key := range keys {
 		value := values[i]
 		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.Persist(key)
 	}
 
 	db.addAof(utils.ToCmdLine2("mset", args...))
 	return &reply.OKReply{}
 }
 
+// execMSetNX sets multi key-value only if none of the keys exists
+func execMSetNX(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	size := len(args) / 2
+	for i := 0; i < size; i++ {
+		if _, exists := db.GetEntity(string(args[i*2])); exists {
+			return reply.MakeIntReply(0)
+		}
+	}
+	for i := 0; i < size; i++ {
+		db.PutEntity(string(args[i*2]), &database.DataEntity{Data: args[i*2+1]})
+	}
+	db.addAof(utils.ToCmdLine2("msetnx", args...))
+	return reply.MakeIntReply(1)
+}
+
 // execMGet get multi key-value from database
 func execMGet(db *DB, args [][]byte) redis.Reply {
 	keys := make([]string, len(args))
@@ -205,9 +344,15 @@ func execDecrBy(db *DB, args [][]byte) redis.Reply {
 // execSetNX sets string if not exists
 func execSetNX(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
+	// GetEntity 会删除已过期的 key, 过期的 key 视为不存在
+	if _, exists := db.GetEntity(key); exists {
+		return reply.MakeIntReply(0)
+	}
 	entity := &database.DataEntity{Data: args[1]}
 	result := db.PutIfAbsent(key, entity)
-	db.addAof(utils.ToCmdLine2("setnx", args...))
+	if result > 0 {
+		db.addAof(utils.ToCmdLine2("setnx", args...))
+	}
 	return reply.MakeIntReply(int64(result))
 }
 
@@ -216,37 +361,40 @@ func execGetSet(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	value := args[1]
 
-	entity, exists := db.GetEntity(key)
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
 	db.PutEntity(key, &database.DataEntity{Data: value})
-	if !exists {
+	db.Persist(key)
+	db.addAof(utils.ToCmdLine2("getset", args...))
+	if old == nil {
 		return reply.MakeNullBulkReply()
 	}
-	old := entity.Data.([]byte)
-	db.addAof(utils.ToCmdLine2("getset", args...))
 	return reply.MakeBulkReply(old)
 }
 
 // execStrlen returns len of string value bound to the given key
 func execStrlen(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	entity, exist := db.GetEntity(key)
-	if !exist {
-		return reply.MakeNullBulkReply()
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
 	}
-	length := int64(len(entity.Data.([]byte)))
-	return reply.MakeIntReply(length)
+	return reply.MakeIntReply(int64(len(bytes)))
 }
 
 func init() {
-	RegisterCommand("get", execGet, 2)
-	RegisterCommand("set", execSet, 3)
-	RegisterCommand("mget", execMGet, -2)
-	RegisterCommand("mset", execMSet, -3)
-	RegisterCommand("SetNX", execSetNX, 3)
-	RegisterCommand("GetSet", execGetSet, 3)
-	RegisterCommand("StrLen", execStrlen, 2)
-	RegisterCommand("incr", execIncr, 2)
-	RegisterCommand("incrby", execIncrBy, 3)
-	RegisterCommand("decr", execDecr, 2)
-	RegisterCommand("decrby", execDecrBy, 3)
+	RegisterCommand("get", execGet, readFirstKey, 2, "read", "string", "fast")
+	RegisterCommand("set", execSet, writeFirstKey, -3, "write", "string", "slow")
+	RegisterCommand("mget", execMGet, readAllKeys, -2, "read", "string", "fast")
+	RegisterCommand("mset", execMSet, prepareMSet, -3, "write", "string", "slow")
+	RegisterCommand("MSetNX", execMSetNX, prepareMSet, -3, "write", "string", "slow")
+	RegisterCommand("SetNX", execSetNX, writeFirstKey, 3, "write", "string", "fast")
+	RegisterCommand("GetSet", execGetSet, writeFirstKey, 3, "write", "string", "slow")
+	RegisterCommand("StrLen", execStrlen, readFirstKey, 2, "read", "string", "fast")
+	RegisterCommand("incr", execIncr, writeFirstKey, 2, "write", "string", "fast")
+	RegisterCommand("incrby", execIncrBy, writeFirstKey, 3, "write", "string", "fast")
+	RegisterCommand("decr", execDecr, writeFirstKey, 2, "write", "string", "fast")
+	RegisterCommand("decrby", execDecrBy, writeFirstKey, 3, "write", "string", "fast")
 }
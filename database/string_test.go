@@ -0,0 +1,30 @@
+package database
+
+import (
+	"redisgo/lib/utils"
+	"redisgo/redis/connection"
+	"redisgo/redis/reply"
+	"testing"
+	"time"
+)
+
+func TestSetKeepTTLOnExpiredKey(t *testing.T) {
+	db := makeDB()
+	db.passive = true // 不使用时间轮, 过期的 key 保留到被访问时
+	c := &connection.Connection{}
+	db.Exec(c, utils.ToCmdLine("set", "k", "v", "px", "50"))
+	time.Sleep(100 * time.Millisecond)
+
+	result := db.Exec(c, utils.ToCmdLine("set", "k", "v2", "keepttl"))
+	if _, ok := result.(*reply.OKReply); !ok {
+		t.Fatalf("expected OK, got %s", result.ToBytes())
+	}
+	result = db.Exec(c, utils.ToCmdLine("get", "k"))
+	if bulk, ok := result.(*reply.BulkReply); !ok || string(bulk.Arg) != "v2" {
+		t.Errorf("expected v2, got %s", result.ToBytes())
+	}
+	result = db.Exec(c, utils.ToCmdLine("pttl", "k"))
+	if intReply, ok := result.(*reply.IntReply); !ok || intReply.Code != -1 {
+		t.Errorf("expected no ttl, got %s", result.ToBytes())
+	}
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"redisgo/interface/redis"
+	"redisgo/lib/utils"
+	"redisgo/redis/reply"
+	"strings"
+)
+
+// 集群中跨节点的事务使用 TCC 模式, 每个参与的节点上执行一个 Tx:
+// try 阶段锁定相关的 key, 记录写入前的值作为回滚日志, 然后执行命令
+// confirm 阶段释放锁; cancel 阶段用回滚日志恢复 key 后再释放锁
+// 只在 try 执行命令期间持有写屏障的读锁, 等待 confirm/cancel 时不阻塞快照, 快照可能包含 try 的结果,
+// 和 AOF 一样, 回滚时写入的恢复命令会覆盖它们; 从 try 到 confirm/cancel 期间持有事务屏障的读锁, FLUSHDB 会等待事务结束
+
+// Tx is a transaction tried on current node, related keys stay locked until it's committed or rolled back
+type Tx struct {
+	database  *StandaloneDatabase
+	db        *DB
+	writeKeys []string
+	readKeys  []string
+	undoLogs  []CmdLine
+	finished  bool
+}
+
+// TryTx locks keys of commands, records undo logs of keys to be written and executes commands,
+// replies results of each command. Commit or Rollback must be called if it succeeds
+func (database *StandaloneDatabase) TryTx(c redis.Connection, cmdLines []CmdLine) (*Tx, []redis.Reply, redis.Reply) {
+	writeKeys := make([]string, 0)
+	readKeys := make([]string, 0)
+	for _, cmdLine := range cmdLines {
+		cmdName := strings.ToLower(string(cmdLine[0]))
+		cmd, ok := cmdTable[cmdName]
+		if !ok {
+			return nil, nil, reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		}
+		if !validateArity(cmd.arity, cmdLine) {
+			return nil, nil, reply.MakeArgNumErrReply(cmdName)
+		}
+		if isSpecialCommand(cmd) || isExclusiveCommand(cmdName) { // FLUSHDB 无法回滚
+			return nil, nil, reply.MakeErrReply("ERR Command '" + cmdName + "' not allowed inside a transaction")
+		}
+		if isWriteCommand(cmdName) && database.isReadOnlyFor(c) {
+			return nil, nil, reply.MakeErrReply("READONLY You can't write against a read only replica.")
+		}
+		write, read := cmd.prepare(cmdLine[1:])
+		writeKeys = append(writeKeys, write...)
+		readKeys = append(readKeys, read...)
+	}
+	tx := &Tx{
+		database:  database,
+		db:        database.dbSet[c.GetDBIndex()],
+		writeKeys: writeKeys,
+		readKeys:  readKeys,
+	}
+	database.txBarrier.RLock()
+	if len(writeKeys) > 0 {
+		database.writeBarrier.RLock()
+		defer database.writeBarrier.RUnlock()
+	}
+	tx.db.RWLocks(writeKeys, readKeys)
+	undoLogs, err := tx.db.makeUndoLogs(writeKeys)
+	if err != nil {
+		tx.unlock()
+		return nil, nil, reply.MakeErrReply("ERR " + err.Error())
+	}
+	tx.undoLogs = undoLogs
+	return tx, tx.db.execCmdLines(cmdLines), nil
+}
+
+// makeUndoLogs records commands which restore keys to current values, missing keys will be deleted
+func (db *DB) makeUndoLogs(keys []string) ([]CmdLine, error) {
+	seen := make(map[string]struct{}, len(keys))
+	undoLogs := make([]CmdLine, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		restoreCmd, exists, err := db.makeRestoreCmd(key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			restoreCmd = utils.ToCmdLine("del", key)
+		}
+		undoLogs = append(undoLogs, restoreCmd)
+	}
+	return undoLogs, nil
+}
+
+// Commit keeps the results of transaction and unlocks keys
+func (tx *Tx) Commit() {
+	if tx.finished {
+		return
+	}
+	tx.finished = true
+	tx.unlock()
+}
+
+// Rollback restores keys written by transaction and unlocks keys
+// 恢复命令是幂等的, 不需要持有写屏障, 见 startSnapshot
+func (tx *Tx) Rollback() {
+	if tx.finished {
+		return
+	}
+	tx.finished = true
+	if len(tx.undoLogs) > 0 {
+		tx.db.execCmdLines(tx.undoLogs)
+	}
+	tx.unlock()
+}
+
+func (tx *Tx) unlock() {
+	tx.db.RWUnLocks(tx.writeKeys, tx.readKeys)
+	tx.database.txBarrier.RUnlock()
+}
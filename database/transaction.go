@@ -0,0 +1,152 @@
+package database
+
+import (
+	"redisgo/interface/redis"
+	"redisgo/redis/reply"
+	"strings"
+)
+
+// StartMulti starts multi-command-transaction
+func StartMulti(c redis.Connection) redis.Reply {
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	c.SetMultiState(true)
+	return reply.MakeOkReply()
+}
+
+// DiscardMulti drops MULTI pending commands
+func DiscardMulti(c redis.Connection) redis.Reply {
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	c.SetMultiState(false)
+	return reply.MakeOkReply()
+}
+
+// Watch set watching keys, 记录key当前的版本号
+func Watch(db *DB, c redis.Connection, args [][]byte) redis.Reply {
+	watching := c.GetWatching()
+	for _, bkey := range args {
+		key := string(bkey)
+		watching[key] = db.GetVersion(key)
+	}
+	return reply.MakeOkReply()
+}
+
+// UnWatch clears all watching keys
+func UnWatch(c redis.Connection) redis.Reply {
+	watching := c.GetWatching()
+	for key := range watching {
+		delete(watching, key)
+	}
+	return reply.MakeOkReply()
+}
+
+// EnqueueCmd validates the command and puts it into the transaction queue
+// 命令不存在或参数个数错误会被记录下来, EXEC 时整个事务放弃执行
+func EnqueueCmd(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		errReply := reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		c.AddTxError(errReply)
+		return errReply
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		errReply := reply.MakeArgNumErrReply(cmdName)
+		c.AddTxError(errReply)
+		return errReply
+	}
+	if isSpecialCommand(cmd) {
+		errReply := reply.MakeErrReply("ERR Command '" + cmdName + "' not allowed inside a transaction")
+		c.AddTxError(errReply)
+		return errReply
+	}
+	c.EnqueueCmd(cmdLine)
+	return reply.MakeQueuedReply()
+}
+
+// execMulti executes the queued commands of connection
+func execMulti(db *DB, c redis.Connection) redis.Reply {
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer c.SetMultiState(false)
+	if len(c.GetTxErrors()) > 0 {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	return db.ExecMulti(c.GetWatching(), c.GetQueuedCmdLine())
+}
+
+// ExecMulti executes commands atomically, all related keys are locked during the transaction
+// 被 WATCH 的key版本号发生变化时放弃执行, 返回 nil
+func (db *DB) ExecMulti(watching map[string]uint32, cmdLines []CmdLine) redis.Reply {
+	writeKeys := make([]string, 0)
+	readKeys := make([]string, 0, len(watching))
+	for _, cmdLine := range cmdLines {
+		cmd := cmdTable[strings.ToLower(string(cmdLine[0]))]
+		write, read := cmd.prepare(cmdLine[1:])
+		writeKeys = append(writeKeys, write...)
+		readKeys = append(readKeys, read...)
+	}
+	for key := range watching {
+		readKeys = append(readKeys, key)
+	}
+	db.RWLocks(writeKeys, readKeys)
+	defer db.RWUnLocks(writeKeys, readKeys)
+
+	if isWatchingChanged(db, watching) {
+		return reply.MakeNullMultiBulkReply()
+	}
+
+	return reply.MakeMultiRawReply(db.execCmdLines(cmdLines))
+}
+
+// execCmdLines executes validated commands whose keys have been locked
+// 事务中的AOF先收集起来, 最后包裹在 MULTI/EXEC 中一次写入, 加载时不会只重放一部分
+// 和 execWithVersion 一样, 只有修改了数据的命令才增加其 key 的版本号
+func (db *DB) execCmdLines(cmdLines []CmdLine) []redis.Reply {
+	aofLines := make([]CmdLine, 0, len(cmdLines)+2)
+	aofLines = append(aofLines, CmdLine{[]byte("multi")})
+	changed := false
+	txDB := *db
+	txDB.addAof = func(lines ...CmdLine) {
+		changed = true
+		aofLines = append(aofLines, lines...)
+	}
+	results := make([]redis.Reply, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		cmd := cmdTable[strings.ToLower(string(cmdLine[0]))]
+		write, _ := cmd.prepare(cmdLine[1:])
+		db.preserve(write...)
+		changed = false
+		results = append(results, cmd.executor(&txDB, cmdLine[1:]))
+		if changed {
+			db.addVersion(write...)
+		}
+	}
+	if len(aofLines) > 1 {
+		aofLines = append(aofLines, CmdLine{[]byte("exec")})
+		db.addAof(aofLines...)
+	}
+	return results
+}
+
+func isWatchingChanged(db *DB, watching map[string]uint32) bool {
+	for key, ver := range watching {
+		currentVersion := db.GetVersion(key)
+		if ver != currentVersion {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	registerSpecialCommand("Multi", 1, "transaction", "fast")
+	registerSpecialCommand("Exec", 1, "transaction", "slow")
+	registerSpecialCommand("Discard", 1, "transaction", "fast")
+	registerSpecialCommand("Watch", -2, "transaction", "fast")
+	registerSpecialCommand("Unwatch", 1, "transaction", "fast")
+}
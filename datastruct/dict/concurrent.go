@@ -0,0 +1,298 @@
+package dict
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentDict is thread safe map using sharding lock
+// key按FNV哈希分配到固定数量的分片, 每个分片使用独立的读写锁
+type ConcurrentDict struct {
+	table      []*shard
+	count      int32
+	shardCount int
+}
+
+type shard struct {
+	m     map[string]interface{}
+	mutex sync.RWMutex
+}
+
+// computeCapacity rounds param up to power of 2
+func computeCapacity(param int) (size int) {
+	if param <= 16 {
+		return 16
+	}
+	n := param - 1
+	n |= n >> 1
+	n |= n >> 2
+	n |= n >> 4
+	n |= n >> 8
+	n |= n >> 16
+	if n < 0 || n >= math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return n + 1
+}
+
+// MakeConcurrent creates ConcurrentDict with the given shard count
+func MakeConcurrent(shardCount int) *ConcurrentDict {
+	shardCount = computeCapacity(shardCount)
+	table := make([]*shard, shardCount)
+	for i := 0; i < shardCount; i++ {
+		table[i] = &shard{
+			m: make(map[string]interface{}),
+		}
+	}
+	return &ConcurrentDict{
+		count:      0,
+		table:      table,
+		shardCount: shardCount,
+	}
+}
+
+const prime32 = uint32(16777619)
+
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
+
+func (dict *ConcurrentDict) spread(hashCode uint32) uint32 {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	tableSize := uint32(len(dict.table))
+	return (tableSize - 1) & hashCode
+}
+
+func (dict *ConcurrentDict) getShard(key string) *shard {
+	return dict.table[dict.spread(fnv32(key))]
+}
+
+// Get returns the binding value and whether the key is exist
+func (dict *ConcurrentDict) Get(key string) (val interface{}, exists bool) {
+	s := dict.getShard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	val, exists = s.m[key]
+	return
+}
+
+// Len returns the number of dict
+func (dict *ConcurrentDict) Len() int {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	return int(atomic.LoadInt32(&dict.count))
+}
+
+// Put puts key value into dict and returns the number of new inserted key-value
+func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 0
+	}
+	dict.addCount(1)
+	s.m[key] = val
+	return 1
+}
+
+// PutIfAbsent puts value if the key is not exists and returns the number of updated key-value
+func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.m[key]; ok {
+		return 0
+	}
+	s.m[key] = val
+	dict.addCount(1)
+	return 1
+}
+
+// PutIfExists puts value if the key is exist and returns the number of inserted key-value
+func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+// Remove removes the key and return the number of deleted key-value
+func (dict *ConcurrentDict) Remove(key string) (result int) {
+	s := dict.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.m[key]; ok {
+		delete(s.m, key)
+		dict.addCount(-1)
+		return 1
+	}
+	return 0
+}
+
+func (dict *ConcurrentDict) addCount(delta int32) {
+	atomic.AddInt32(&dict.count, delta)
+}
+
+// ForEach traversal the dict
+// it may not visits new entry inserted during traversal, and the consumer must not modify the dict
+func (dict *ConcurrentDict) ForEach(consumer Consumer) {
+	if dict == nil {
+		panic("dict is nil")
+	}
+
+	for _, s := range dict.table {
+		s.mutex.RLock()
+		goNext := func() bool {
+			defer s.mutex.RUnlock()
+			for key, value := range s.m {
+				if !consumer(key, value) {
+					return false
+				}
+			}
+			return true
+		}()
+		if !goNext {
+			break
+		}
+	}
+}
+
+// Keys returns all keys in dict
+func (dict *ConcurrentDict) Keys() []string {
+	keys := make([]string, 0, dict.Len())
+	dict.ForEach(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// shardLens takes a snapshot of the size of each shard
+func (dict *ConcurrentDict) shardLens() ([]int, int) {
+	lens := make([]int, len(dict.table))
+	total := 0
+	for i, s := range dict.table {
+		s.mutex.RLock()
+		lens[i] = len(s.m)
+		s.mutex.RUnlock()
+		total += lens[i]
+	}
+	return lens, total
+}
+
+// pickKeys returns keys at the given global positions, positions must be sorted in ascending order
+// 全局位置按分片顺序编号, 每个分片最多在读锁下遍历一次
+func (dict *ConcurrentDict) pickKeys(lens []int, positions []int) []string {
+	result := make([]string, 0, len(positions))
+	base := 0
+	p := 0
+	for i, s := range dict.table {
+		end := base + lens[i]
+		if p < len(positions) && positions[p] < end {
+			s.mutex.RLock()
+			j := base
+			for key := range s.m {
+				for p < len(positions) && positions[p] == j {
+					result = append(result, key)
+					p++
+				}
+				j++
+				if p >= len(positions) || positions[p] >= end {
+					break
+				}
+			}
+			s.mutex.RUnlock()
+			// 分片可能在快照后变小, 越界的位置被丢弃
+			for p < len(positions) && positions[p] < end {
+				p++
+			}
+		}
+		base = end
+	}
+	return result
+}
+
+// RandomKeys randomly returns keys of the given number, may contain duplicated key
+// 每个key被选中的概率相等
+func (dict *ConcurrentDict) RandomKeys(limit int) []string {
+	lens, total := dict.shardLens()
+	if total == 0 || limit <= 0 {
+		return nil
+	}
+	positions := make([]int, limit)
+	for i := range positions {
+		positions[i] = rand.Intn(total)
+	}
+	sort.Ints(positions)
+	result := dict.pickKeys(lens, positions)
+	rand.Shuffle(len(result), func(i, j int) {
+		result[i], result[j] = result[j], result[i]
+	})
+	return result
+}
+
+// RandomDistinctKeys randomly returns keys of the given number, won't contain duplicated key
+func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
+	lens, total := dict.shardLens()
+	if total == 0 || limit <= 0 {
+		return nil
+	}
+	if limit >= total {
+		keys := dict.Keys()
+		rand.Shuffle(len(keys), func(i, j int) {
+			keys[i], keys[j] = keys[j], keys[i]
+		})
+		return keys
+	}
+	// Floyd's algorithm samples distinct positions
+	selected := make(map[int]struct{}, limit)
+	for j := total - limit; j < total; j++ {
+		t := rand.Intn(j + 1)
+		if _, ok := selected[t]; ok {
+			selected[j] = struct{}{}
+		} else {
+			selected[t] = struct{}{}
+		}
+	}
+	positions := make([]int, 0, limit)
+	for position := range selected {
+		positions = append(positions, position)
+	}
+	sort.Ints(positions)
+	result := dict.pickKeys(lens, positions)
+	rand.Shuffle(len(result), func(i, j int) {
+		result[i], result[j] = result[j], result[i]
+	})
+	return result
+}
+
+// Clear removes all keys in dict
+func (dict *ConcurrentDict) Clear() {
+	for _, s := range dict.table {
+		s.mutex.Lock()
+		dict.addCount(-int32(len(s.m)))
+		s.m = make(map[string]interface{})
+		s.mutex.Unlock()
+	}
+}
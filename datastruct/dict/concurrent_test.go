@@ -0,0 +1,79 @@
+package dict
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+const benchKeyCount = 1 << 16
+
+var benchKeys = func() []string {
+	keys := make([]string, benchKeyCount)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+	return keys
+}()
+
+func prefill(d Dict) Dict {
+	for _, key := range benchKeys {
+		d.Put(key, key)
+	}
+	return d
+}
+
+// benchmarkParallel runs op concurrently, each goroutine starts at a different key
+func benchmarkParallel(b *testing.B, d Dict, op func(d Dict, i int)) {
+	var seed int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(atomic.AddInt64(&seed, 7919))
+		for pb.Next() {
+			op(d, i)
+			i++
+		}
+	})
+}
+
+func get(d Dict, i int) {
+	d.Get(benchKeys[i&(benchKeyCount-1)])
+}
+
+func put(d Dict, i int) {
+	key := benchKeys[i&(benchKeyCount-1)]
+	d.Put(key, key)
+}
+
+// 读写比例为 9:1
+func getPut(d Dict, i int) {
+	if i%10 == 0 {
+		put(d, i)
+	} else {
+		get(d, i)
+	}
+}
+
+func BenchmarkConcurrentDictGet(b *testing.B) {
+	benchmarkParallel(b, prefill(MakeConcurrent(1024)), get)
+}
+
+func BenchmarkSyncDictGet(b *testing.B) {
+	benchmarkParallel(b, prefill(MakeSyncDict()), get)
+}
+
+func BenchmarkConcurrentDictPut(b *testing.B) {
+	benchmarkParallel(b, MakeConcurrent(1024), put)
+}
+
+func BenchmarkSyncDictPut(b *testing.B) {
+	benchmarkParallel(b, MakeSyncDict(), put)
+}
+
+func BenchmarkConcurrentDictGetPut(b *testing.B) {
+	benchmarkParallel(b, prefill(MakeConcurrent(1024)), getPut)
+}
+
+func BenchmarkSyncDictGetPut(b *testing.B) {
+	benchmarkParallel(b, prefill(MakeSyncDict()), getPut)
+}
@@ -0,0 +1,58 @@
+package dict
+
+import (
+	"hash/fnv"
+	"math/bits"
+)
+
+// Scan incrementally iterates the dict like redis SCAN: keys are grouped into 2^n buckets by hash,
+// and the cursor walks buckets in reverse binary order, so keys present during the whole iteration
+// are returned at least once even if the dict grows or shrinks between calls.
+// It returns the visited keys accepted by match (nil match accepts all) and the next cursor, 0 means finished.
+func Scan(d Dict, cursor uint64, count int, match func(key string) bool) ([]string, uint64) {
+	if count <= 0 {
+		count = 10
+	}
+	mask := tableMask(d.Len())
+	buckets := make(map[uint64][]string)
+	d.ForEach(func(key string, val interface{}) bool {
+		b := hashKey(key) & mask
+		buckets[b] = append(buckets[b], key)
+		return true
+	})
+
+	result := make([]string, 0, count)
+	visited := 0
+	for {
+		for _, key := range buckets[cursor&mask] {
+			visited++
+			if match == nil || match(key) {
+				result = append(result, key)
+			}
+		}
+		// reverse binary increment
+		cursor |= ^mask
+		cursor = bits.Reverse64(cursor)
+		cursor++
+		cursor = bits.Reverse64(cursor)
+		if cursor == 0 || visited >= count {
+			break
+		}
+	}
+	return result, cursor
+}
+
+// tableMask returns the mask of the smallest power-of-two table which can hold size keys
+func tableMask(size int) uint64 {
+	n := uint64(4)
+	for n < uint64(size) {
+		n <<= 1
+	}
+	return n - 1
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
@@ -0,0 +1,42 @@
+package list
+
+import "testing"
+
+func TestQuickListTrim(t *testing.T) {
+	size := pageSize*3 + 10
+	cases := [][2]int{
+		{0, size},
+		{0, 0},
+		{size, size},
+		{1, size - 1},
+		{pageSize, size},
+		{pageSize + 5, pageSize*2 + 7},
+		{pageSize*3 - 1, pageSize*3 + 1},
+		{10, 20},
+	}
+	for _, c := range cases {
+		start, stop := c[0], c[1]
+		ql := NewQuickList()
+		for i := 0; i < size; i++ {
+			ql.Add(i)
+		}
+		ql.Trim(start, stop)
+		if ql.Len() != stop-start {
+			t.Fatalf("trim [%d, %d): expected len %d, got %d", start, stop, stop-start, ql.Len())
+		}
+		i := 0
+		ql.ForEach(func(_ int, v interface{}) bool {
+			if v.(int) != start+i {
+				t.Fatalf("trim [%d, %d): expected %d at %d, got %v", start, stop, start+i, i, v)
+			}
+			i++
+			return true
+		})
+		// 截断后仍然可以在两端添加和删除
+		ql.Add(-1)
+		ql.Insert(0, -2)
+		if ql.Get(0) != -2 || ql.Get(ql.Len()-1) != -1 || ql.Len() != stop-start+2 {
+			t.Fatalf("trim [%d, %d): list broken after trim", start, stop)
+		}
+	}
+}
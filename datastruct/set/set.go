@@ -0,0 +1,119 @@
+package set
+
+import "redisgo/datastruct/dict"
+
+// Set is a set of elements based on hash table
+type Set struct {
+	dict dict.Dict
+}
+
+// Make creates a new set
+func Make(members ...string) *Set {
+	set := &Set{
+		dict: dict.MakeSimpleDict(),
+	}
+	for _, member := range members {
+		set.Add(member)
+	}
+	return set
+}
+
+// Add adds member into set, returns the number of new added member
+func (set *Set) Add(val string) int {
+	return set.dict.Put(val, nil)
+}
+
+// Remove removes member from set, returns the number of removed member
+func (set *Set) Remove(val string) int {
+	return set.dict.Remove(val)
+}
+
+// Has returns true if the val exists in the set
+func (set *Set) Has(val string) bool {
+	if set == nil || set.dict == nil {
+		return false
+	}
+	_, exists := set.dict.Get(val)
+	return exists
+}
+
+// Len returns number of members in the set
+func (set *Set) Len() int {
+	if set == nil || set.dict == nil {
+		return 0
+	}
+	return set.dict.Len()
+}
+
+// ToSlice convert set to []string
+func (set *Set) ToSlice() []string {
+	return set.dict.Keys()
+}
+
+// ForEach visits each member in the set
+func (set *Set) ForEach(consumer func(member string) bool) {
+	if set == nil || set.dict == nil {
+		return
+	}
+	set.dict.ForEach(func(key string, val interface{}) bool {
+		return consumer(key)
+	})
+}
+
+// Dict returns the underlying dict, used for scanning
+func (set *Set) Dict() dict.Dict {
+	return set.dict
+}
+
+// Intersect intersects two sets
+func (set *Set) Intersect(another *Set) *Set {
+	result := Make()
+	// 遍历较小的集合
+	small, large := set, another
+	if small.Len() > large.Len() {
+		small, large = large, small
+	}
+	small.ForEach(func(member string) bool {
+		if large.Has(member) {
+			result.Add(member)
+		}
+		return true
+	})
+	return result
+}
+
+// Union adds two sets
+func (set *Set) Union(another *Set) *Set {
+	result := Make()
+	set.ForEach(func(member string) bool {
+		result.Add(member)
+		return true
+	})
+	another.ForEach(func(member string) bool {
+		result.Add(member)
+		return true
+	})
+	return result
+}
+
+// Diff subtracts two sets
+func (set *Set) Diff(another *Set) *Set {
+	result := Make()
+	set.ForEach(func(member string) bool {
+		if !another.Has(member) {
+			result.Add(member)
+		}
+		return true
+	})
+	return result
+}
+
+// RandomMembers randomly returns keys of the given number, may contain duplicated key
+func (set *Set) RandomMembers(limit int) []string {
+	return set.dict.RandomKeys(limit)
+}
+
+// RandomDistinctMembers randomly returns keys of the given number, won't contain duplicated key
+func (set *Set) RandomDistinctMembers(limit int) []string {
+	return set.dict.RandomDistinctKeys(limit)
+}
@@ -0,0 +1,219 @@
+package sortedset
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+/*
+ * ScoreBorder is a struct represents `min` `max` parameter of redis command `ZRANGEBYSCORE`
+ * can accept:
+ *   int or float value, such as 2.718, 2, -2.718, -2 ...
+ *   exclusive int or float value, such as (2.718, (2, (-2.718, (-2 ...
+ *   infinity: +inf, -inf， inf(same as +inf)
+ */
+
+const (
+	negativeInf int8 = -1
+	positiveInf int8 = 1
+)
+
+// Border represents range of a key
+// min.less(e) 表示e满足下界, max.greater(e) 表示e满足上界
+type Border interface {
+	greater(element *Element) bool
+	less(element *Element) bool
+	getValue() interface{}
+	getExclude() bool
+	isEmptyRange(max Border) bool
+}
+
+// ScoreBorder represents range of a float value, including: <, <=, >, >=, +inf, -inf
+type ScoreBorder struct {
+	Inf     int8
+	Value   float64
+	Exclude bool
+}
+
+// if max.greater(score) then the score is within the upper border
+// do not use min.greater()
+func (border *ScoreBorder) greater(element *Element) bool {
+	value := element.Score
+	if border.Inf == negativeInf {
+		return false
+	} else if border.Inf == positiveInf {
+		return true
+	}
+	if border.Exclude {
+		return border.Value > value
+	}
+	return border.Value >= value
+}
+
+func (border *ScoreBorder) less(element *Element) bool {
+	value := element.Score
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < value
+	}
+	return border.Value <= value
+}
+
+func (border *ScoreBorder) getValue() interface{} {
+	return border.Value
+}
+
+func (border *ScoreBorder) getExclude() bool {
+	return border.Exclude
+}
+
+// isEmptyRange returns true if no score is within [border, max]
+func (border *ScoreBorder) isEmptyRange(max Border) bool {
+	maxBorder, ok := max.(*ScoreBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	minValue := border.Value
+	maxValue := maxBorder.Value
+	return minValue > maxValue || (minValue == maxValue && (border.Exclude || maxBorder.Exclude))
+}
+
+var scorePositiveInfBorder = &ScoreBorder{
+	Inf: positiveInf,
+}
+
+var scoreNegativeInfBorder = &ScoreBorder{
+	Inf: negativeInf,
+}
+
+// ParseScoreBorder creates ScoreBorder from redis arguments
+func ParseScoreBorder(s string) (Border, error) {
+	if s == "inf" || s == "+inf" {
+		return scorePositiveInfBorder, nil
+	}
+	if s == "-inf" {
+		return scoreNegativeInfBorder, nil
+	}
+	exclude := false
+	if len(s) > 0 && s[0] == '(' {
+		exclude = true
+		s = s[1:]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return nil, errors.New("ERR min or max is not a float")
+	}
+	if math.IsInf(value, 1) {
+		return scorePositiveInfBorder, nil
+	} else if math.IsInf(value, -1) {
+		return scoreNegativeInfBorder, nil
+	}
+	return &ScoreBorder{
+		Inf:     0,
+		Value:   value,
+		Exclude: exclude,
+	}, nil
+}
+
+// LexBorder represents range of a string value, including: <, <=, >, >=, +, -
+type LexBorder struct {
+	Inf     int8
+	Value   string
+	Exclude bool
+}
+
+// if max.greater(lex) then the lex is within the upper border
+// do not use min.greater()
+func (border *LexBorder) greater(element *Element) bool {
+	value := element.Member
+	if border.Inf == negativeInf {
+		return false
+	} else if border.Inf == positiveInf {
+		return true
+	}
+	if border.Exclude {
+		return border.Value > value
+	}
+	return border.Value >= value
+}
+
+func (border *LexBorder) less(element *Element) bool {
+	value := element.Member
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < value
+	}
+	return border.Value <= value
+}
+
+func (border *LexBorder) getValue() interface{} {
+	return border.Value
+}
+
+func (border *LexBorder) getExclude() bool {
+	return border.Exclude
+}
+
+// isEmptyRange returns true if no member is within [border, max]
+func (border *LexBorder) isEmptyRange(max Border) bool {
+	maxBorder, ok := max.(*LexBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	minValue := border.Value
+	maxValue := maxBorder.Value
+	return minValue > maxValue || (minValue == maxValue && (border.Exclude || maxBorder.Exclude))
+}
+
+var lexPositiveInfBorder = &LexBorder{
+	Inf: positiveInf,
+}
+
+var lexNegativeInfBorder = &LexBorder{
+	Inf: negativeInf,
+}
+
+// ParseLexBorder creates LexBorder from redis arguments
+// accepts: +, -, [member, (member
+func ParseLexBorder(s string) (Border, error) {
+	if s == "+" {
+		return lexPositiveInfBorder, nil
+	}
+	if s == "-" {
+		return lexNegativeInfBorder, nil
+	}
+	if len(s) > 0 && s[0] == '(' {
+		return &LexBorder{
+			Value:   s[1:],
+			Exclude: true,
+		}, nil
+	}
+	if len(s) > 0 && s[0] == '[' {
+		return &LexBorder{
+			Value:   s[1:],
+			Exclude: false,
+		}, nil
+	}
+	return nil, errors.New("ERR min or max not valid string range item")
+}
@@ -0,0 +1,344 @@
+package sortedset
+
+import "math/rand"
+
+const (
+	maxLevel = 16
+)
+
+// Element is a key-score pair
+type Element struct {
+	Member string
+	Score  float64
+}
+
+// Level aspect of a node
+type Level struct {
+	forward *node // forward node has greater score
+	span    int64
+}
+
+type node struct {
+	Element
+	backward *node
+	level    []*Level // level[0] is base level
+}
+
+type skiplist struct {
+	header *node
+	tail   *node
+	length int64
+	level  int16
+}
+
+func makeNode(level int16, score float64, member string) *node {
+	n := &node{
+		Element: Element{
+			Score:  score,
+			Member: member,
+		},
+		level: make([]*Level, level),
+	}
+	for i := range n.level {
+		n.level[i] = new(Level)
+	}
+	return n
+}
+
+func makeSkiplist() *skiplist {
+	return &skiplist{
+		level:  1,
+		header: makeNode(maxLevel, 0, ""),
+	}
+}
+
+func randomLevel() int16 {
+	level := int16(1)
+	for float32(rand.Int31()&0xFFFF) < (0.25 * 0xFFFF) {
+		level++
+	}
+	if level < maxLevel {
+		return level
+	}
+	return maxLevel
+}
+
+// before returns true if the element (score, member) should be placed after n
+func (n *node) before(score float64, member string) bool {
+	return n.Score < score || (n.Score == score && n.Member < member)
+}
+
+func (skiplist *skiplist) insert(member string, score float64) *node {
+	update := make([]*node, maxLevel) // link new node with node in `update`
+	rank := make([]int64, maxLevel)
+
+	// find position to insert
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		if i == skiplist.level-1 {
+			rank[i] = 0
+		} else {
+			rank[i] = rank[i+1] // store rank that is crossed to reach the insert position
+		}
+		for n.level[i].forward != nil && n.level[i].forward.before(score, member) {
+			rank[i] += n.level[i].span
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+
+	level := randomLevel()
+	// extend skiplist level
+	if level > skiplist.level {
+		for i := skiplist.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = skiplist.header
+			update[i].level[i].span = skiplist.length
+		}
+		skiplist.level = level
+	}
+
+	// make node and link into skiplist
+	n = makeNode(level, score, member)
+	for i := int16(0); i < level; i++ {
+		n.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = n
+
+		// update span covered by update[i] as n is inserted here
+		n.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// increment span for untouched levels
+	for i := level; i < skiplist.level; i++ {
+		update[i].level[i].span++
+	}
+
+	// set backward node
+	if update[0] == skiplist.header {
+		n.backward = nil
+	} else {
+		n.backward = update[0]
+	}
+	if n.level[0].forward != nil {
+		n.level[0].forward.backward = n
+	} else {
+		skiplist.tail = n
+	}
+	skiplist.length++
+	return n
+}
+
+/*
+ * param node: node to delete
+ * param update: backward node (of target)
+ */
+func (skiplist *skiplist) removeNode(n *node, update []*node) {
+	for i := int16(0); i < skiplist.level; i++ {
+		if update[i].level[i].forward == n {
+			update[i].level[i].span += n.level[i].span - 1
+			update[i].level[i].forward = n.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if n.level[0].forward != nil {
+		n.level[0].forward.backward = n.backward
+	} else {
+		skiplist.tail = n.backward
+	}
+	for skiplist.level > 1 && skiplist.header.level[skiplist.level-1].forward == nil {
+		skiplist.level--
+	}
+	skiplist.length--
+}
+
+/*
+ * return: has found and removed node
+ */
+func (skiplist *skiplist) remove(member string, score float64) bool {
+	/*
+	 * find backward node (of target) or last node of each level
+	 * their forward need to be updated
+	 */
+	update := make([]*node, maxLevel)
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && n.level[i].forward.before(score, member) {
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+	n = n.level[0].forward
+	if n != nil && score == n.Score && n.Member == member {
+		skiplist.removeNode(n, update)
+		// free x
+		return true
+	}
+	return false
+}
+
+/*
+ * return: 1 based rank, 0 means member not found
+ */
+func (skiplist *skiplist) getRank(member string, score float64) int64 {
+	var rank int64 = 0
+	x := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.Score < score ||
+				(x.level[i].forward.Score == score &&
+					x.level[i].forward.Member <= member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		/* x might be equal to zsl->header, so test if obj is non-NULL */
+		if x != skiplist.header && x.Member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+/*
+ * 1-based rank
+ */
+func (skiplist *skiplist) getByRank(rank int64) *node {
+	var i int64 = 0
+	n := skiplist.header
+	// scan from top level
+	for level := skiplist.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && (i+n.level[level].span) <= rank {
+			i += n.level[level].span
+			n = n.level[level].forward
+		}
+		if i == rank {
+			return n
+		}
+	}
+	return nil
+}
+
+func (skiplist *skiplist) hasInRange(min Border, max Border) bool {
+	if min.isEmptyRange(max) { // empty range
+		return false
+	}
+	// min > tail
+	n := skiplist.tail
+	if n == nil || !min.less(&n.Element) {
+		return false
+	}
+	// max < head
+	n = skiplist.header.level[0].forward
+	if n == nil || !max.greater(&n.Element) {
+		return false
+	}
+	return true
+}
+
+func (skiplist *skiplist) getFirstInRange(min Border, max Border) *node {
+	if !skiplist.hasInRange(min, max) {
+		return nil
+	}
+	n := skiplist.header
+	// scan from top level
+	for level := skiplist.level - 1; level >= 0; level-- {
+		// if forward is not in range than move forward
+		for n.level[level].forward != nil && !min.less(&n.level[level].forward.Element) {
+			n = n.level[level].forward
+		}
+	}
+	/* This is an inner range, so the next node cannot be NULL. */
+	n = n.level[0].forward
+	if !max.greater(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+func (skiplist *skiplist) getLastInRange(min Border, max Border) *node {
+	if !skiplist.hasInRange(min, max) {
+		return nil
+	}
+	n := skiplist.header
+	// scan from top level
+	for level := skiplist.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && max.greater(&n.level[level].forward.Element) {
+			n = n.level[level].forward
+		}
+	}
+	if !min.less(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+/*
+ * return removed elements
+ */
+func (skiplist *skiplist) RemoveRange(min Border, max Border, limit int) (removed []*Element) {
+	update := make([]*node, maxLevel)
+	removed = make([]*Element, 0)
+	// find backward nodes (of target range) or last node of each level
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil {
+			if min.less(&n.level[i].forward.Element) { // already in range
+				break
+			}
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+
+	// node is the first one within range
+	n = n.level[0].forward
+
+	// remove nodes in range
+	for n != nil {
+		if !max.greater(&n.Element) { // already out of range
+			break
+		}
+		next := n.level[0].forward
+		removedElement := n.Element
+		removed = append(removed, &removedElement)
+		skiplist.removeNode(n, update)
+		if limit > 0 && len(removed) == limit {
+			break
+		}
+		n = next
+	}
+	return removed
+}
+
+// RemoveRangeByRank removes elements of 1-based rank, including start, exclude stop
+func (skiplist *skiplist) RemoveRangeByRank(start int64, stop int64) (removed []*Element) {
+	var i int64 = 0 // rank of iterator
+	update := make([]*node, maxLevel)
+	removed = make([]*Element, 0)
+
+	// scan from top level
+	n := skiplist.header
+	for level := skiplist.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && (i+n.level[level].span) < start {
+			i += n.level[level].span
+			n = n.level[level].forward
+		}
+		update[level] = n
+	}
+
+	i++
+	n = n.level[0].forward // first node in range
+
+	// remove nodes in range
+	for n != nil && i < stop {
+		next := n.level[0].forward
+		removedElement := n.Element
+		removed = append(removed, &removedElement)
+		skiplist.removeNode(n, update)
+		n = next
+		i++
+	}
+	return removed
+}
@@ -1,6 +1,9 @@
 package database
 
-import "redisgo/interface/redis"
+import (
+	"redisgo/interface/redis"
+	"time"
+)
 
 
 
@@ -14,6 +17,12 @@ type Database interface {
 	AfterClientClose(c redis.Connection)
 }
 
+// DBEngine is the storage engine which exposes its data for persistence, such as AOF rewrite
+type DBEngine interface {
+	Database
+	ForEach(dbIndex int, cb func(key string, entity *DataEntity, expiration *time.Time) bool)
+}
+
 type DataEntity struct {
 	Data interface{}
-}
\ No newline at end of file
+}
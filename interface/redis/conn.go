@@ -5,4 +5,37 @@ type Connection interface {
 	Write([]byte) error // 给客户端回消息
 	GetDBIndex() int    //查询客户端正在用的DB
 	SelectDB(int)       //切换DB 函数
+
+	// 认证相关
+	SetAuthenticated(bool)
+	IsAuthenticated() bool
+	SetUserName(string)
+	GetUserName() string
+	// 通过 _peerauth 认证的集群节点才能执行内部命令
+	SetClusterPeer(bool)
+	IsClusterPeer() bool
+
+	// 事务相关
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	ClearQueuedCmds()
+	GetWatching() map[string]uint32
+	AddTxError(err error)
+	GetTxErrors() []error
+
+	// 订阅相关
+	Subscribe(channel string)
+	UnSubscribe(channel string)
+	SubsCount() int
+	GetChannels() []string
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	PSubsCount() int
+	GetPatterns() []string
+	SSubscribe(channel string)
+	SUnSubscribe(channel string)
+	SSubsCount() int
+	GetShardChannels() []string
 }
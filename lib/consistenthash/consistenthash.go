@@ -3,6 +3,7 @@ package consistenthash
 import (
 	"hash/crc32"
 	"sort"
+	"strconv"
 )
 
 // HashFunc defines function to generate hash code
@@ -10,21 +11,29 @@ type HashFunc func(data []byte) uint32
 
 
 // Map stores nodes and you can pick node from map
+// 每个节点在哈希环上有 replicas * weight 个虚拟节点, 权重越大分到的 key 越多
 type NodeMap struct {
 	hashFunc HashFunc
+	replicas int
 	keys []int
 	hashMap map[int]string
+	weights map[string]int
 }
 
-// NewNodeMap creates a new NodeMap
-func NewNodeMap(fn HashFunc) *NodeMap {
+// NewNodeMap creates a new NodeMap, every node has replicas virtual nodes for each unit of weight
+func NewNodeMap(replicas int, fn HashFunc) *NodeMap {
 	m := &NodeMap{
 		hashFunc: fn,
+		replicas: replicas,
 		hashMap: make(map[int]string),
+		weights: make(map[string]int),
 	}
 	if fn == nil {
 		m.hashFunc = crc32.ChecksumIEEE
 	}
+	if replicas <= 0 {
+		m.replicas = 1
+	}
 	return m
 }
 
@@ -33,16 +42,48 @@ func (m *NodeMap)IsEmpty() bool {
 	return len(m.keys) == 0
 }
 
-// AddNode add the given nodes into consistent hash circle
+// AddNode add the given nodes into consistent hash circle with weight 1
 func (m *NodeMap) AddNode(keys ...string) {
 	for _, key := range keys {
 		if key == "" {
 			continue
 		}
-		u := m.hashFunc([]byte(key))
-		hash := int(u)
-		m.keys = append(m.keys, hash)
-		m.hashMap[hash] = key
+		m.weights[key] = 1
+	}
+	m.build()
+}
+
+// AddWeightedNode adds node with the given weight, weight of existing node is updated
+func (m *NodeMap) AddWeightedNode(key string, weight int) {
+	if key == "" || weight <= 0 {
+		return
+	}
+	m.weights[key] = weight
+	m.build()
+}
+
+// build generates virtual nodes of all nodes
+// 第一个虚拟节点使用节点名本身, replicas 为1时和没有虚拟节点时的哈希环相同
+// 哈希冲突时保留名称较小的节点, 各节点添加顺序不同时也能得到相同的哈希环
+func (m *NodeMap) build() {
+	m.keys = m.keys[:0]
+	m.hashMap = make(map[int]string)
+	for node, weight := range m.weights {
+		for i := 0; i < m.replicas*weight; i++ {
+			name := node
+			if i > 0 {
+				name = node + "#" + strconv.Itoa(i)
+			}
+			hash := int(m.hashFunc([]byte(name)))
+			if exist, ok := m.hashMap[hash]; ok {
+				if exist < node {
+					continue
+				}
+			} else {
+				m.keys = append(m.keys, hash)
+			}
+			m.hashMap[hash] = node
+		}
 	}
 	sort.Ints(m.keys)
 }
@@ -65,4 +106,63 @@ func (m *NodeMap) PickNode(key string) string {
 	}
 
 	return m.hashMap[m.keys[index]]
-}
\ No newline at end of file
+}
+
+// RemoveNode removes the given nodes from consistent hash circle
+func (m *NodeMap) RemoveNode(keys ...string) {
+	for _, key := range keys {
+		delete(m.weights, key)
+	}
+	m.build()
+}
+
+// Nodes returns all nodes in NodeMap ordered by name
+func (m *NodeMap) Nodes() []string {
+	nodes := make([]string, 0, len(m.weights))
+	for node := range m.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Weight returns weight of the node, 0 if the node not exists
+func (m *NodeMap) Weight(node string) int {
+	return m.weights[node]
+}
+
+// NodeStat describes how much of the hash circle a node covers
+type NodeStat struct {
+	Node   string
+	Weight int
+	// 虚拟节点个数, 和其他节点哈希冲突的不计入
+	Points int
+	// 负责的哈希值范围占整个环的比例
+	Share float64
+}
+
+// Stats returns share of hash circle of each node ordered by name
+// 每个虚拟节点负责从前一个虚拟节点(不含)到自身的范围, 第一个虚拟节点还负责环尾部的范围
+func (m *NodeMap) Stats() []NodeStat {
+	points := make(map[string]int)
+	ranges := make(map[string]uint64)
+	for i, hash := range m.keys {
+		node := m.hashMap[hash]
+		points[node]++
+		if i == 0 {
+			ranges[node] += uint64(hash) + (1 << 32) - uint64(m.keys[len(m.keys)-1])
+		} else {
+			ranges[node] += uint64(hash - m.keys[i-1])
+		}
+	}
+	stats := make([]NodeStat, 0, len(m.weights))
+	for _, node := range m.Nodes() {
+		stats = append(stats, NodeStat{
+			Node:   node,
+			Weight: m.weights[node],
+			Points: points[node],
+			Share:  float64(ranges[node]) / (1 << 32),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,71 @@
+package consistenthash
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+)
+
+// FNV returns 32-bit FNV-1a hash of data
+func FNV(data []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(data)
+	return h.Sum32()
+}
+
+// Murmur3 returns 32-bit MurmurHash3 of data with seed 0
+func Murmur3(data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	var h uint32
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	// 不足4字节的尾部
+	var k uint32
+	switch len(data) - n {
+	case 3:
+		k ^= uint32(data[n+2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[n+1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[n])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// GetHashFunc returns hash function by name: crc32, fnv or murmur3
+func GetHashFunc(name string) (HashFunc, bool) {
+	switch strings.ToLower(name) {
+	case "", "crc32":
+		return crc32.ChecksumIEEE, true
+	case "fnv":
+		return FNV, true
+	case "murmur3":
+		return Murmur3, true
+	}
+	return nil, false
+}
@@ -0,0 +1,32 @@
+package timewheel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelayNeverEarly(t *testing.T) {
+	tw := New(100*time.Millisecond, 10)
+	tw.Start()
+	defer tw.Stop()
+
+	delays := []time.Duration{0, 50 * time.Millisecond, 150 * time.Millisecond, 1050 * time.Millisecond}
+	done := make(chan time.Duration, len(delays))
+	for _, d := range delays {
+		d := d
+		start := time.Now()
+		tw.AddJob(d, "", func() {
+			if elapsed := time.Since(start); elapsed < d {
+				t.Errorf("job with delay %v ran after %v", d, elapsed)
+			}
+			done <- d
+		})
+	}
+	for range delays {
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("job not executed")
+		}
+	}
+}
@@ -14,6 +14,8 @@ const configfile string = "redis.conf"
 var defaultProperties = &config.ServerProperties{
 	Bind: "0.0.0.0",
 	Port: 6379,
+	DbFilename: "dump.rdb",
+	ReplicaReadOnly: true,
 }
 
 func fileExists(filename string) bool {
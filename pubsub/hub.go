@@ -0,0 +1,159 @@
+package pubsub
+
+import (
+	"redisgo/datastruct/dict"
+	"redisgo/interface/redis"
+	"redisgo/lib/sync/lock"
+	"redisgo/lib/wildcard"
+)
+
+const (
+	subsDictSize   = 1 << 4
+	subsLockerSize = 1 << 4
+)
+
+// subscribers of a channel or a pattern
+type subscribers struct {
+	pattern *wildcard.Pattern // 只有模式订阅使用
+	conns   map[redis.Connection]struct{}
+}
+
+// Hub stores all subscribe relations
+// 频道、模式和分片频道分开存储, 同一个频道/模式的订阅者在对应的锁保护下修改
+type Hub struct {
+	// channel -> *subscribers
+	subs dict.Dict
+	// pattern -> *subscribers
+	psubs dict.Dict
+	// shard channel -> *subscribers
+	ssubs dict.Dict
+	// lock channel or pattern
+	subsLocker *lock.Locks
+}
+
+// MakeHub creates new hub
+func MakeHub() *Hub {
+	return &Hub{
+		subs:       dict.MakeConcurrent(subsDictSize),
+		psubs:      dict.MakeConcurrent(subsDictSize),
+		ssubs:      dict.MakeConcurrent(subsDictSize),
+		subsLocker: lock.Make(subsLockerSize),
+	}
+}
+
+// 频道和模式可能同名, 加锁时用前缀区分
+func channelLockKey(channel string) string {
+	return "c:" + channel
+}
+
+func patternLockKey(pattern string) string {
+	return "p:" + pattern
+}
+
+func shardChannelLockKey(channel string) string {
+	return "s:" + channel
+}
+
+// subscribe adds c into subscribers of key, returns whether it is a new subscription
+func (hub *Hub) subscribe(d dict.Dict, key string, lockKey string, c redis.Connection, isPattern bool) bool {
+	hub.subsLocker.Lock(lockKey)
+	defer hub.subsLocker.UnLock(lockKey)
+
+	raw, ok := d.Get(key)
+	var subs *subscribers
+	if ok {
+		subs, _ = raw.(*subscribers)
+	} else {
+		subs = &subscribers{
+			conns: make(map[redis.Connection]struct{}),
+		}
+		if isPattern {
+			subs.pattern = wildcard.CompilePattern(key)
+		}
+		d.Put(key, subs)
+	}
+	if _, exists := subs.conns[c]; exists {
+		return false
+	}
+	subs.conns[c] = struct{}{}
+	return true
+}
+
+// unsubscribe removes c from subscribers of key, empty subscribers will be removed
+func (hub *Hub) unsubscribe(d dict.Dict, key string, lockKey string, c redis.Connection) {
+	hub.subsLocker.Lock(lockKey)
+	defer hub.subsLocker.UnLock(lockKey)
+
+	raw, ok := d.Get(key)
+	if !ok {
+		return
+	}
+	subs, _ := raw.(*subscribers)
+	delete(subs.conns, c)
+	if len(subs.conns) == 0 {
+		d.Remove(key)
+	}
+}
+
+// publish sends message to channel subscribers and pattern subscribers, returns number of receivers
+func (hub *Hub) publish(channel string, message []byte) int {
+	count := hub.publishToChannel(hub.subs, channel, channelLockKey(channel), makeMsg(channel, message))
+	// 逐个模式加锁, 不在持有频道锁时获取其他锁, 避免死锁
+	for _, pattern := range hub.psubs.Keys() {
+		count += hub.publishToPattern(pattern, channel, message)
+	}
+	return count
+}
+
+// spublish sends message to shard channel subscribers, pattern subscribers are not involved
+func (hub *Hub) spublish(channel string, message []byte) int {
+	return hub.publishToChannel(hub.ssubs, channel, shardChannelLockKey(channel), makeSMsg(channel, message))
+}
+
+func (hub *Hub) publishToChannel(d dict.Dict, channel string, lockKey string, data []byte) int {
+	hub.subsLocker.RLock(lockKey)
+	defer hub.subsLocker.RUnLock(lockKey)
+
+	raw, ok := d.Get(channel)
+	if !ok {
+		return 0
+	}
+	subs, _ := raw.(*subscribers)
+	for c := range subs.conns {
+		_ = c.Write(data)
+	}
+	return len(subs.conns)
+}
+
+func (hub *Hub) publishToPattern(pattern string, channel string, message []byte) int {
+	lockKey := patternLockKey(pattern)
+	hub.subsLocker.RLock(lockKey)
+	defer hub.subsLocker.RUnLock(lockKey)
+
+	raw, ok := hub.psubs.Get(pattern)
+	if !ok {
+		return 0
+	}
+	subs, _ := raw.(*subscribers)
+	if !subs.pattern.IsMatch(channel) {
+		return 0
+	}
+	data := makePMsg(pattern, channel, message)
+	for c := range subs.conns {
+		_ = c.Write(data)
+	}
+	return len(subs.conns)
+}
+
+// numSub returns number of subscribers of channel
+func (hub *Hub) numSub(d dict.Dict, channel string, lockKey string) int {
+	hub.subsLocker.RLock(lockKey)
+	defer hub.subsLocker.RUnLock(lockKey)
+
+	raw, ok := d.Get(channel)
+	if !ok {
+		return 0
+	}
+	subs, _ := raw.(*subscribers)
+	return len(subs.conns)
+}
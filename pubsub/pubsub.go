@@ -0,0 +1,253 @@
+package pubsub
+
+import (
+	"redisgo/interface/redis"
+	"redisgo/lib/wildcard"
+	"redisgo/redis/reply"
+	"strings"
+)
+
+var (
+	_subscribe    = []byte("subscribe")
+	_unsubscribe  = []byte("unsubscribe")
+	_psubscribe   = []byte("psubscribe")
+	_punsubscribe = []byte("punsubscribe")
+	_ssubscribe   = []byte("ssubscribe")
+	_sunsubscribe = []byte("sunsubscribe")
+	messageBytes  = []byte("message")
+	pmessageBytes = []byte("pmessage")
+	smessageBytes = []byte("smessage")
+)
+
+func makeMsg(channel string, message []byte) []byte {
+	return reply.MakeMultiBulkReply([][]byte{
+		messageBytes,
+		[]byte(channel),
+		message,
+	}).ToBytes()
+}
+
+func makePMsg(pattern string, channel string, message []byte) []byte {
+	return reply.MakeMultiBulkReply([][]byte{
+		pmessageBytes,
+		[]byte(pattern),
+		[]byte(channel),
+		message,
+	}).ToBytes()
+}
+
+func makeSMsg(channel string, message []byte) []byte {
+	return reply.MakeMultiBulkReply([][]byte{
+		smessageBytes,
+		[]byte(channel),
+		message,
+	}).ToBytes()
+}
+
+// makeSubsReply makes the confirmation of (un)subscribe, count includes channels and patterns
+// 没有订阅任何频道时执行 UNSUBSCRIBE, channel 为 nil
+func makeSubsReply(kind []byte, channel []byte, count int) []byte {
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply(kind),
+		reply.MakeBulkReply(channel),
+		reply.MakeIntReply(int64(count)),
+	}).ToBytes()
+}
+
+func subsCount(c redis.Connection) int {
+	return c.SubsCount() + c.PSubsCount()
+}
+
+// Subscribe puts the given connection into the given channels
+// 每个频道单独回复一条确认消息, 直接写入连接
+func Subscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	for _, arg := range args {
+		channel := string(arg)
+		if hub.subscribe(hub.subs, channel, channelLockKey(channel), c, false) {
+			c.Subscribe(channel)
+		}
+		_ = c.Write(makeSubsReply(_subscribe, arg, subsCount(c)))
+	}
+	return &reply.NoReply{}
+}
+
+// UnSubscribe removes the given connection from the given channels, all channels if args is empty
+func UnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	var channels []string
+	if len(args) > 0 {
+		channels = make([]string, len(args))
+		for i, arg := range args {
+			channels[i] = string(arg)
+		}
+	} else {
+		channels = c.GetChannels()
+	}
+	if len(channels) == 0 {
+		_ = c.Write(makeSubsReply(_unsubscribe, nil, subsCount(c)))
+		return &reply.NoReply{}
+	}
+	for _, channel := range channels {
+		hub.unsubscribe(hub.subs, channel, channelLockKey(channel), c)
+		c.UnSubscribe(channel)
+		_ = c.Write(makeSubsReply(_unsubscribe, []byte(channel), subsCount(c)))
+	}
+	return &reply.NoReply{}
+}
+
+// PSubscribe puts the given connection into the given patterns
+func PSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	for _, arg := range args {
+		pattern := string(arg)
+		if hub.subscribe(hub.psubs, pattern, patternLockKey(pattern), c, true) {
+			c.PSubscribe(pattern)
+		}
+		_ = c.Write(makeSubsReply(_psubscribe, arg, subsCount(c)))
+	}
+	return &reply.NoReply{}
+}
+
+// PUnSubscribe removes the given connection from the given patterns, all patterns if args is empty
+func PUnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	var patterns []string
+	if len(args) > 0 {
+		patterns = make([]string, len(args))
+		for i, arg := range args {
+			patterns[i] = string(arg)
+		}
+	} else {
+		patterns = c.GetPatterns()
+	}
+	if len(patterns) == 0 {
+		_ = c.Write(makeSubsReply(_punsubscribe, nil, subsCount(c)))
+		return &reply.NoReply{}
+	}
+	for _, pattern := range patterns {
+		hub.unsubscribe(hub.psubs, pattern, patternLockKey(pattern), c)
+		c.PUnSubscribe(pattern)
+		_ = c.Write(makeSubsReply(_punsubscribe, []byte(pattern), subsCount(c)))
+	}
+	return &reply.NoReply{}
+}
+
+// SSubscribe puts the given connection into the given shard channels
+// 回复中的订阅数只计算分片频道
+func SSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	for _, arg := range args {
+		channel := string(arg)
+		if hub.subscribe(hub.ssubs, channel, shardChannelLockKey(channel), c, false) {
+			c.SSubscribe(channel)
+		}
+		_ = c.Write(makeSubsReply(_ssubscribe, arg, c.SSubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+// SUnSubscribe removes the given connection from the given shard channels, all shard channels if args is empty
+func SUnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	var channels []string
+	if len(args) > 0 {
+		channels = make([]string, len(args))
+		for i, arg := range args {
+			channels[i] = string(arg)
+		}
+	} else {
+		channels = c.GetShardChannels()
+	}
+	if len(channels) == 0 {
+		_ = c.Write(makeSubsReply(_sunsubscribe, nil, c.SSubsCount()))
+		return &reply.NoReply{}
+	}
+	for _, channel := range channels {
+		hub.unsubscribe(hub.ssubs, channel, shardChannelLockKey(channel), c)
+		c.SUnSubscribe(channel)
+		_ = c.Write(makeSubsReply(_sunsubscribe, []byte(channel), c.SSubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+// UnsubscribeAll removes the given connection from all channels and patterns, used when client closed
+func UnsubscribeAll(hub *Hub, c redis.Connection) {
+	for _, channel := range c.GetShardChannels() {
+		hub.unsubscribe(hub.ssubs, channel, shardChannelLockKey(channel), c)
+		c.SUnSubscribe(channel)
+	}
+	for _, channel := range c.GetChannels() {
+		hub.unsubscribe(hub.subs, channel, channelLockKey(channel), c)
+		c.UnSubscribe(channel)
+	}
+	for _, pattern := range c.GetPatterns() {
+		hub.unsubscribe(hub.psubs, pattern, patternLockKey(pattern), c)
+		c.PUnSubscribe(pattern)
+	}
+}
+
+// Publish sends message to all subscribers of channel, returns the number of receivers
+func Publish(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	channel := string(args[0])
+	count := hub.publish(channel, args[1])
+	return reply.MakeIntReply(int64(count))
+}
+
+// SPublish sends message to all subscribers of shard channel, returns the number of receivers
+func SPublish(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("spublish")
+	}
+	channel := string(args[0])
+	count := hub.spublish(channel, args[1])
+	return reply.MakeIntReply(int64(count))
+}
+
+// PubSub executes PUBSUB CHANNELS [pattern], PUBSUB NUMSUB [channel ...], PUBSUB NUMPAT
+// and their shard channel versions PUBSUB SHARDCHANNELS [pattern], PUBSUB SHARDNUMSUB [channel ...]
+func PubSub(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("pubsub")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "channels", "shardchannels":
+		if len(args) > 2 {
+			return reply.MakeErrReply("ERR Unknown subcommand or wrong number of arguments for '" + subCmd + "'")
+		}
+		var pattern *wildcard.Pattern
+		if len(args) == 2 {
+			pattern = wildcard.CompilePattern(string(args[1]))
+		}
+		d := hub.subs
+		if subCmd == "shardchannels" {
+			d = hub.ssubs
+		}
+		channels := make([][]byte, 0)
+		for _, channel := range d.Keys() {
+			if pattern != nil && !pattern.IsMatch(channel) {
+				continue
+			}
+			channels = append(channels, []byte(channel))
+		}
+		return reply.MakeMultiBulkReply(channels)
+	case "numsub", "shardnumsub":
+		result := make([]redis.Reply, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			channel := string(arg)
+			var count int
+			if subCmd == "shardnumsub" {
+				count = hub.numSub(hub.ssubs, channel, shardChannelLockKey(channel))
+			} else {
+				count = hub.numSub(hub.subs, channel, channelLockKey(channel))
+			}
+			result = append(result, reply.MakeBulkReply(arg))
+			result = append(result, reply.MakeIntReply(int64(count)))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "numpat":
+		if len(args) != 1 {
+			return reply.MakeErrReply("ERR Unknown subcommand or wrong number of arguments for 'numpat'")
+		}
+		return reply.MakeIntReply(int64(hub.psubs.Len()))
+	}
+	return reply.MakeErrReply("ERR Unknown subcommand '" + string(args[0]) + "'. Try PUBSUB HELP.")
+}
@@ -0,0 +1,498 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"redisgo/datastruct/dict"
+	List "redisgo/datastruct/list"
+	"redisgo/datastruct/set"
+	SortedSet "redisgo/datastruct/sortedset"
+	"redisgo/interface/database"
+	"strconv"
+	"time"
+)
+
+// Object is a key read from rdb file
+type Object struct {
+	DBIndex int
+	Key     string
+	Entity  *database.DataEntity
+	// Expiration is nil if the key is persistent
+	Expiration *time.Time
+}
+
+// Decoder reads objects from rdb file
+// 除了 Encoder 写入的基础编码, 还支持 redis 使用的 ziplist, listpack, intset 等紧凑编码, 不支持 stream 和 module
+type Decoder struct {
+	reader  *bufio.Reader
+	crc     uint64
+	offset  int64
+	version int
+	buf     [8]byte
+}
+
+// NewDecoder creates a Decoder reads from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		reader: bufio.NewReader(r),
+	}
+}
+
+// Offset returns the number of bytes consumed, including the checksum after Parse finished
+func (dec *Decoder) Offset() int64 {
+	return dec.offset
+}
+
+func (dec *Decoder) read(p []byte) error {
+	n, err := io.ReadFull(dec.reader, p)
+	dec.offset += int64(n)
+	dec.crc = updateCRC(dec.crc, p[:n])
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (dec *Decoder) readByte() (byte, error) {
+	if err := dec.read(dec.buf[:1]); err != nil {
+		return 0, err
+	}
+	return dec.buf[0], nil
+}
+
+// readLength returns length, or encoding type of string if isEncoded is true
+func (dec *Decoder) readLength() (length uint64, isEncoded bool, err error) {
+	first, err := dec.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3f), false, nil
+	case len14Bit:
+		next, err := dec.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case lenEncVal:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case len32Bit:
+		if err := dec.read(dec.buf[:4]); err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(dec.buf[:4])), false, nil
+	case len64Bit:
+		if err := dec.read(dec.buf[:8]); err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(dec.buf[:8]), false, nil
+	}
+	return 0, false, fmt.Errorf("%w: unknown length encoding %d", ErrBadFormat, first)
+}
+
+func (dec *Decoder) readLen() (int, error) {
+	length, isEncoded, err := dec.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if isEncoded || length > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: invalid length", ErrBadFormat)
+	}
+	return int(length), nil
+}
+
+func (dec *Decoder) readString() ([]byte, error) {
+	length, isEncoded, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !isEncoded {
+		if length > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: string too long", ErrBadFormat)
+		}
+		s := make([]byte, length)
+		return s, dec.read(s)
+	}
+	switch length {
+	case encInt8:
+		b, err := dec.readByte()
+		return []byte(strconv.Itoa(int(int8(b)))), err
+	case encInt16:
+		if err := dec.read(dec.buf[:2]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(dec.buf[:2]))))), nil
+	case encInt32:
+		if err := dec.read(dec.buf[:4]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(dec.buf[:4]))))), nil
+	case encLZF:
+		compressedLen, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		compressed := make([]byte, compressedLen)
+		if err := dec.read(compressed); err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, rawLen)
+	}
+	return nil, fmt.Errorf("%w: unknown string encoding %d", ErrBadFormat, length)
+}
+
+// Parse reads the whole file and calls cb for each key, stops if cb returns false
+// 已过期的key也会传给 cb, 由调用方决定是否丢弃
+func (dec *Decoder) Parse(cb func(o *Object) bool) error {
+	header := make([]byte, len(magic)+4)
+	if err := dec.read(header); err != nil {
+		return err
+	}
+	if string(header[:len(magic)]) != magic {
+		return fmt.Errorf("%w: wrong signature", ErrBadFormat)
+	}
+	ver, err := strconv.Atoi(string(header[len(magic):]))
+	if err != nil || ver < 1 || ver > maxVersion {
+		return fmt.Errorf("%w: can't handle rdb version %s", ErrBadFormat, header[len(magic):])
+	}
+	dec.version = ver
+
+	dbIndex := 0
+	var expiration *time.Time
+	for {
+		opCode, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch opCode {
+		case opCodeEOF:
+			return dec.checkSum()
+		case opCodeSelectDB:
+			if dbIndex, err = dec.readLen(); err != nil {
+				return err
+			}
+		case opCodeResizeDB:
+			if _, err := dec.readLen(); err != nil {
+				return err
+			}
+			if _, err := dec.readLen(); err != nil {
+				return err
+			}
+		case opCodeAux:
+			if _, err := dec.readString(); err != nil {
+				return err
+			}
+			if _, err := dec.readString(); err != nil {
+				return err
+			}
+		case opCodeExpireTime:
+			if err := dec.read(dec.buf[:4]); err != nil {
+				return err
+			}
+			t := time.Unix(int64(binary.LittleEndian.Uint32(dec.buf[:4])), 0)
+			expiration = &t
+		case opCodeExpireTimeMs:
+			if err := dec.read(dec.buf[:8]); err != nil {
+				return err
+			}
+			t := time.UnixMilli(int64(binary.LittleEndian.Uint64(dec.buf[:8])))
+			expiration = &t
+		case opCodeFreq:
+			if _, err := dec.readByte(); err != nil {
+				return err
+			}
+		case opCodeIdle:
+			if _, err := dec.readLen(); err != nil {
+				return err
+			}
+		case opCodeSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err := dec.readLen(); err != nil {
+					return err
+				}
+			}
+		case opCodeFunction2:
+			// 不支持 redis function, 跳过函数库代码
+			if _, err := dec.readString(); err != nil {
+				return err
+			}
+		case opCodeModuleAux, opCodeFunctionPreGA:
+			return fmt.Errorf("%w: unsupported op code %d", ErrBadFormat, opCode)
+		default:
+			key, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			data, err := dec.readValue(opCode)
+			if err != nil {
+				return fmt.Errorf("read key %s: %w", key, err)
+			}
+			obj := &Object{
+				DBIndex:    dbIndex,
+				Key:        string(key),
+				Entity:     &database.DataEntity{Data: data},
+				Expiration: expiration,
+			}
+			expiration = nil
+			if !cb(obj) {
+				return nil
+			}
+		}
+	}
+}
+
+func (dec *Decoder) checkSum() error {
+	if dec.version < 5 {
+		return nil
+	}
+	expected := dec.crc
+	if err := dec.read(dec.buf[:8]); err != nil {
+		return err
+	}
+	actual := binary.LittleEndian.Uint64(dec.buf[:8])
+	// 校验和为0表示写入时关闭了校验
+	if actual != 0 && actual != expected {
+		return fmt.Errorf("%w: wrong checksum", ErrBadFormat)
+	}
+	return nil
+}
+
+func (dec *Decoder) readValue(valueType byte) (interface{}, error) {
+	switch valueType {
+	case typeString:
+		return dec.readString()
+	case typeList:
+		return dec.readList()
+	case typeSet:
+		return dec.readSet()
+	case typeZSet, typeZSet2:
+		return dec.readZSet(valueType == typeZSet2)
+	case typeHash:
+		return dec.readHash()
+	case typeHashZipMap:
+		return dec.readEncoded(parseZipMap, toHash)
+	case typeListZipList:
+		return dec.readEncoded(parseZipList, toList)
+	case typeSetIntSet:
+		return dec.readEncoded(parseIntSet, toSet)
+	case typeZSetZipList:
+		return dec.readEncoded(parseZipList, toZSet)
+	case typeHashZipList:
+		return dec.readEncoded(parseZipList, toHash)
+	case typeHashListPack:
+		return dec.readEncoded(parseListPack, toHash)
+	case typeZSetListPack:
+		return dec.readEncoded(parseListPack, toZSet)
+	case typeSetListPack:
+		return dec.readEncoded(parseListPack, toSet)
+	case typeListQuickList, typeListQuickList2:
+		return dec.readQuickList(valueType == typeListQuickList2)
+	case typeModule, typeModule2, typeStreamListPacks:
+		return nil, fmt.Errorf("%w: unsupported type %d", ErrBadFormat, valueType)
+	}
+	return nil, fmt.Errorf("%w: unknown type %d", ErrBadFormat, valueType)
+}
+
+func (dec *Decoder) readList() (interface{}, error) {
+	size, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	list := List.NewQuickList()
+	for i := 0; i < size; i++ {
+		val, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		list.Add(val)
+	}
+	return list, nil
+}
+
+func (dec *Decoder) readSet() (interface{}, error) {
+	size, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	s := set.Make()
+	for i := 0; i < size; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		s.Add(string(member))
+	}
+	return s, nil
+}
+
+func (dec *Decoder) readHash() (interface{}, error) {
+	size, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	hash := dict.MakeSimpleDict()
+	for i := 0; i < size; i++ {
+		field, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		val, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		hash.Put(string(field), val)
+	}
+	return hash, nil
+}
+
+func (dec *Decoder) readZSet(binaryScore bool) (interface{}, error) {
+	size, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	zset := SortedSet.Make()
+	for i := 0; i < size; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScore {
+			if err := dec.read(dec.buf[:8]); err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(dec.buf[:8]))
+		} else {
+			score, err = dec.readStringScore()
+			if err != nil {
+				return nil, err
+			}
+		}
+		zset.Add(string(member), score)
+	}
+	return zset, nil
+}
+
+// readStringScore reads score of typeZSet, 253, 254, 255 represent nan, +inf and -inf
+func (dec *Decoder) readStringScore() (float64, error) {
+	length, err := dec.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, length)
+	if err := dec.read(buf); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+// readEncoded reads a string which contains compact encoded elements, then builds the value by elements
+func (dec *Decoder) readEncoded(parse func([]byte) ([][]byte, error), build func([][]byte) (interface{}, error)) (interface{}, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	elements, err := parse(buf)
+	if err != nil {
+		return nil, err
+	}
+	return build(elements)
+}
+
+func (dec *Decoder) readQuickList(v2 bool) (interface{}, error) {
+	size, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	list := List.NewQuickList()
+	for i := 0; i < size; i++ {
+		container := quickListNodePacked
+		if v2 {
+			if container, err = dec.readLen(); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		if container == quickListNodePlain {
+			list.Add(buf)
+			continue
+		}
+		var elements [][]byte
+		if v2 {
+			elements, err = parseListPack(buf)
+		} else {
+			elements, err = parseZipList(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range elements {
+			list.Add(e)
+		}
+	}
+	return list, nil
+}
+
+func toList(elements [][]byte) (interface{}, error) {
+	list := List.NewQuickList()
+	for _, e := range elements {
+		list.Add(e)
+	}
+	return list, nil
+}
+
+func toSet(elements [][]byte) (interface{}, error) {
+	s := set.Make()
+	for _, e := range elements {
+		s.Add(string(e))
+	}
+	return s, nil
+}
+
+func toHash(elements [][]byte) (interface{}, error) {
+	if len(elements)%2 != 0 {
+		return nil, errors.New("odd number of hash elements")
+	}
+	hash := dict.MakeSimpleDict()
+	for i := 0; i < len(elements); i += 2 {
+		hash.Put(string(elements[i]), elements[i+1])
+	}
+	return hash, nil
+}
+
+func toZSet(elements [][]byte) (interface{}, error) {
+	if len(elements)%2 != 0 {
+		return nil, errors.New("odd number of zset elements")
+	}
+	zset := SortedSet.Make()
+	for i := 0; i < len(elements); i += 2 {
+		score, err := strconv.ParseFloat(string(elements[i+1]), 64)
+		if err != nil {
+			return nil, errors.New("invalid zset score")
+		}
+		zset.Add(string(elements[i]), score)
+	}
+	return zset, nil
+}
@@ -0,0 +1,71 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"redisgo/interface/database"
+)
+
+// DUMP 格式和 redis 相同: <类型><值><2字节 RDB 版本><8字节 CRC64>, 版本和校验和都是小端序, 校验和包括版本
+
+// dumpFooterLen is the length of version and checksum at the end of payload
+const dumpFooterLen = 10
+
+// Dump serializes value of the entity in the format of redis DUMP command
+func Dump(entity *database.DataEntity) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	valueType, writeValue, err := enc.valueWriter(entity)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.writeByte(valueType); err != nil {
+		return nil, err
+	}
+	if err := writeValue(); err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint16(enc.buf[:], version)
+	if err := enc.write(enc.buf[:2]); err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint64(enc.buf[:], enc.crc)
+	if _, err := enc.writer.Write(enc.buf[:8]); err != nil {
+		return nil, err
+	}
+	if err := enc.writer.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore deserializes payload generated by Dump or redis DUMP command
+func Restore(payload []byte) (*database.DataEntity, error) {
+	if len(payload) < dumpFooterLen+1 {
+		return nil, fmt.Errorf("%w: payload too short", ErrBadFormat)
+	}
+	body := payload[:len(payload)-8]
+	ver := int(binary.LittleEndian.Uint16(body[len(body)-2:]))
+	if ver > maxVersion {
+		return nil, fmt.Errorf("%w: can't handle rdb version %d", ErrBadFormat, ver)
+	}
+	expected := binary.LittleEndian.Uint64(payload[len(payload)-8:])
+	if updateCRC(0, body) != expected {
+		return nil, fmt.Errorf("%w: wrong checksum", ErrBadFormat)
+	}
+	dec := NewDecoder(bytes.NewReader(body[:len(body)-2]))
+	dec.version = ver
+	valueType, err := dec.readByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := dec.readValue(valueType)
+	if err != nil {
+		return nil, err
+	}
+	if dec.Offset() != int64(len(body)-2) {
+		return nil, fmt.Errorf("%w: unexpected data after value", ErrBadFormat)
+	}
+	return &database.DataEntity{Data: data}, nil
+}
@@ -0,0 +1,249 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"redisgo/datastruct/dict"
+	List "redisgo/datastruct/list"
+	"redisgo/datastruct/set"
+	SortedSet "redisgo/datastruct/sortedset"
+	"redisgo/interface/database"
+	"strconv"
+	"time"
+)
+
+// Encoder writes data entities in rdb format
+// 写入的数据只使用基础编码, redis 5.0 及以上版本都可以加载
+type Encoder struct {
+	writer *bufio.Writer
+	crc    uint64
+	buf    [9]byte
+}
+
+// NewEncoder creates an Encoder writes into w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		writer: bufio.NewWriter(w),
+	}
+}
+
+func (enc *Encoder) write(p []byte) error {
+	enc.crc = updateCRC(enc.crc, p)
+	_, err := enc.writer.Write(p)
+	return err
+}
+
+func (enc *Encoder) writeByte(b byte) error {
+	enc.buf[0] = b
+	return enc.write(enc.buf[:1])
+}
+
+func (enc *Encoder) writeLength(length uint64) error {
+	buf := enc.buf[:]
+	switch {
+	case length < 1<<6:
+		buf[0] = byte(length)
+		return enc.write(buf[:1])
+	case length < 1<<14:
+		buf[0] = byte(len14Bit<<6 | length>>8)
+		buf[1] = byte(length)
+		return enc.write(buf[:2])
+	case length <= math.MaxUint32:
+		buf[0] = len32Bit
+		binary.BigEndian.PutUint32(buf[1:], uint32(length))
+		return enc.write(buf[:5])
+	default:
+		buf[0] = len64Bit
+		binary.BigEndian.PutUint64(buf[1:], length)
+		return enc.write(buf[:9])
+	}
+}
+
+func (enc *Encoder) writeString(s []byte) error {
+	if err := enc.writeLength(uint64(len(s))); err != nil {
+		return err
+	}
+	return enc.write(s)
+}
+
+// WriteHeader writes magic number, version and basic aux fields
+func (enc *Encoder) WriteHeader() error {
+	if err := enc.write([]byte(fmt.Sprintf("%s%04d", magic, version))); err != nil {
+		return err
+	}
+	if err := enc.WriteAux("redis-bits", "64"); err != nil {
+		return err
+	}
+	return enc.WriteAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+}
+
+// WriteAux writes an aux field, loaders ignore unknown fields
+func (enc *Encoder) WriteAux(key string, value string) error {
+	if err := enc.writeByte(opCodeAux); err != nil {
+		return err
+	}
+	if err := enc.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return enc.writeString([]byte(value))
+}
+
+// WriteDBHeader selects db, the following entries belong to it
+func (enc *Encoder) WriteDBHeader(dbIndex int) error {
+	if err := enc.writeByte(opCodeSelectDB); err != nil {
+		return err
+	}
+	return enc.writeLength(uint64(dbIndex))
+}
+
+// WriteEntry writes a key with its value and expiration, expiration is nil if the key is persistent
+func (enc *Encoder) WriteEntry(key string, entity *database.DataEntity, expiration *time.Time) error {
+	if expiration != nil {
+		if err := enc.writeByte(opCodeExpireTimeMs); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(enc.buf[:], uint64(expiration.UnixMilli()))
+		if err := enc.write(enc.buf[:8]); err != nil {
+			return err
+		}
+	}
+	valueType, writeValue, err := enc.valueWriter(entity)
+	if err != nil {
+		return fmt.Errorf("%w of key %s", err, key)
+	}
+	if err := enc.writeByte(valueType); err != nil {
+		return err
+	}
+	if err := enc.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return writeValue()
+}
+
+var errUnknownType = errors.New("unknown data type")
+
+// valueWriter returns rdb type of the entity and the function writes its value
+func (enc *Encoder) valueWriter(entity *database.DataEntity) (byte, func() error, error) {
+	switch val := entity.Data.(type) {
+	case []byte:
+		return typeString, func() error {
+			return enc.writeString(val)
+		}, nil
+	case List.List:
+		return typeList, func() error {
+			return enc.writeList(val)
+		}, nil
+	case dict.Dict:
+		return typeHash, func() error {
+			return enc.writeHash(val)
+		}, nil
+	case *set.Set:
+		return typeSet, func() error {
+			return enc.writeSet(val)
+		}, nil
+	case *SortedSet.SortedSet:
+		return typeZSet2, func() error {
+			return enc.writeZSet(val)
+		}, nil
+	}
+	return 0, nil, errUnknownType
+}
+
+func (enc *Encoder) writeList(list List.List) error {
+	if err := enc.writeLength(uint64(list.Len())); err != nil {
+		return err
+	}
+	var err error
+	list.ForEach(func(i int, v interface{}) bool {
+		bytes, _ := v.([]byte)
+		err = enc.writeString(bytes)
+		return err == nil
+	})
+	return err
+}
+
+func (enc *Encoder) writeHash(hash dict.Dict) error {
+	if err := enc.writeLength(uint64(hash.Len())); err != nil {
+		return err
+	}
+	var err error
+	hash.ForEach(func(field string, v interface{}) bool {
+		bytes, _ := v.([]byte)
+		if err = enc.writeString([]byte(field)); err != nil {
+			return false
+		}
+		err = enc.writeString(bytes)
+		return err == nil
+	})
+	return err
+}
+
+func (enc *Encoder) writeSet(s *set.Set) error {
+	if err := enc.writeLength(uint64(s.Len())); err != nil {
+		return err
+	}
+	var err error
+	s.ForEach(func(member string) bool {
+		err = enc.writeString([]byte(member))
+		return err == nil
+	})
+	return err
+}
+
+func (enc *Encoder) writeZSet(zset *SortedSet.SortedSet) error {
+	size := zset.Len()
+	if err := enc.writeLength(uint64(size)); err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+	var err error
+	zset.ForEachByRank(0, size, false, func(element *SortedSet.Element) bool {
+		if err = enc.writeString([]byte(element.Member)); err != nil {
+			return false
+		}
+		binary.LittleEndian.PutUint64(enc.buf[:], math.Float64bits(element.Score))
+		err = enc.write(enc.buf[:8])
+		return err == nil
+	})
+	return err
+}
+
+// WriteEnd writes EOF op code and checksum, then flushes buffered data
+func (enc *Encoder) WriteEnd() error {
+	if err := enc.writeByte(opCodeEOF); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(enc.buf[:], enc.crc)
+	if _, err := enc.writer.Write(enc.buf[:8]); err != nil {
+		return err
+	}
+	return enc.writer.Flush()
+}
+
+// WriteDBEngine writes all keys in the first dbCount DBs of engine, empty DBs are skipped
+func (enc *Encoder) WriteDBEngine(engine database.DBEngine, dbCount int) error {
+	for i := 0; i < dbCount; i++ {
+		var err error
+		selected := false
+		engine.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			if !selected {
+				if err = enc.WriteDBHeader(i); err != nil {
+					return false
+				}
+				selected = true
+			}
+			err = enc.WriteEntry(key, entity, expiration)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// 以下是 redis 用于小型集合的紧凑编码, 只需要解析
+
+var errBadEncoding = fmt.Errorf("%w: bad compact encoding", ErrBadFormat)
+
+// lzfDecompress decompresses LZF compressed string
+func lzfDecompress(in []byte, rawLen int) ([]byte, error) {
+	out := make([]byte, 0, rawLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// 字面量, 长度为 ctrl+1
+			if i+ctrl+1 > len(in) {
+				return nil, errBadEncoding
+			}
+			out = append(out, in[i:i+ctrl+1]...)
+			i += ctrl + 1
+			continue
+		}
+		// 回溯引用之前输出的内容
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errBadEncoding
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errBadEncoding
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errBadEncoding
+		}
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != rawLen {
+		return nil, errBadEncoding
+	}
+	return out, nil
+}
+
+// parseZipList returns all entries of ziplist
+func parseZipList(buf []byte) ([][]byte, error) {
+	// zlbytes(4) zltail(4) zllen(2) entries... end(0xff)
+	if len(buf) < 11 {
+		return nil, errBadEncoding
+	}
+	var result [][]byte
+	i := 10
+	for {
+		if i >= len(buf) {
+			return nil, errBadEncoding
+		}
+		if buf[i] == 0xff {
+			return result, nil
+		}
+		// 跳过前一个entry的长度
+		if buf[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(buf) {
+			return nil, errBadEncoding
+		}
+		enc := buf[i]
+		i++
+		var strLen int
+		switch {
+		case enc>>6 == 0:
+			strLen = int(enc & 0x3f)
+		case enc>>6 == 1:
+			if i >= len(buf) {
+				return nil, errBadEncoding
+			}
+			strLen = int(enc&0x3f)<<8 | int(buf[i])
+			i++
+		case enc>>6 == 2:
+			if i+4 > len(buf) {
+				return nil, errBadEncoding
+			}
+			strLen = int(binary.BigEndian.Uint32(buf[i:]))
+			i += 4
+		default:
+			val, n, err := zipListInt(enc, buf[i:])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, []byte(strconv.FormatInt(val, 10)))
+			i += n
+			continue
+		}
+		if strLen < 0 || i+strLen > len(buf) {
+			return nil, errBadEncoding
+		}
+		result = append(result, buf[i:i+strLen])
+		i += strLen
+	}
+}
+
+// zipListInt decodes integer entry of ziplist, returns the value and bytes consumed after the encoding byte
+func zipListInt(enc byte, buf []byte) (int64, int, error) {
+	need := map[byte]int{0xc0: 2, 0xd0: 4, 0xe0: 8, 0xf0: 3, 0xfe: 1}
+	if enc >= 0xf1 && enc <= 0xfd {
+		return int64(enc&0x0f) - 1, 0, nil
+	}
+	n, ok := need[enc]
+	if !ok || len(buf) < n {
+		return 0, 0, errBadEncoding
+	}
+	switch enc {
+	case 0xc0:
+		return int64(int16(binary.LittleEndian.Uint16(buf))), n, nil
+	case 0xd0:
+		return int64(int32(binary.LittleEndian.Uint32(buf))), n, nil
+	case 0xe0:
+		return int64(binary.LittleEndian.Uint64(buf)), n, nil
+	case 0xf0:
+		// 24位整数, 左移后算术右移完成符号扩展
+		v := int32(uint32(buf[0])<<8|uint32(buf[1])<<16|uint32(buf[2])<<24) >> 8
+		return int64(v), n, nil
+	default:
+		return int64(int8(buf[0])), n, nil
+	}
+}
+
+// parseListPack returns all entries of listpack
+func parseListPack(buf []byte) ([][]byte, error) {
+	// total bytes(4) num elements(2) entries... end(0xff)
+	if len(buf) < 7 {
+		return nil, errBadEncoding
+	}
+	var result [][]byte
+	i := 6
+	for {
+		if i >= len(buf) {
+			return nil, errBadEncoding
+		}
+		b := buf[i]
+		if b == 0xff {
+			return result, nil
+		}
+		var entryLen int // encoding 和数据的长度, 不含 backlen
+		var val []byte
+		switch {
+		case b&0x80 == 0:
+			val = []byte(strconv.Itoa(int(b & 0x7f)))
+			entryLen = 1
+		case b&0xc0 == 0x80:
+			strLen := int(b & 0x3f)
+			entryLen = 1 + strLen
+			if i+entryLen > len(buf) {
+				return nil, errBadEncoding
+			}
+			val = buf[i+1 : i+entryLen]
+		case b&0xe0 == 0xc0:
+			if i+2 > len(buf) {
+				return nil, errBadEncoding
+			}
+			v := int(b&0x1f)<<8 | int(buf[i+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			val = []byte(strconv.Itoa(v))
+			entryLen = 2
+		case b&0xf0 == 0xe0:
+			if i+2 > len(buf) {
+				return nil, errBadEncoding
+			}
+			strLen := int(b&0x0f)<<8 | int(buf[i+1])
+			entryLen = 2 + strLen
+			if i+entryLen > len(buf) {
+				return nil, errBadEncoding
+			}
+			val = buf[i+2 : i+entryLen]
+		case b == 0xf0:
+			if i+5 > len(buf) {
+				return nil, errBadEncoding
+			}
+			strLen := int(binary.LittleEndian.Uint32(buf[i+1:]))
+			entryLen = 5 + strLen
+			if strLen < 0 || i+entryLen > len(buf) {
+				return nil, errBadEncoding
+			}
+			val = buf[i+5 : i+entryLen]
+		default:
+			size := map[byte]int{0xf1: 2, 0xf2: 3, 0xf3: 4, 0xf4: 8}[b]
+			if size == 0 || i+1+size > len(buf) {
+				return nil, errBadEncoding
+			}
+			val = []byte(strconv.FormatInt(listPackInt(buf[i+1:i+1+size]), 10))
+			entryLen = 1 + size
+		}
+		result = append(result, val)
+		i += entryLen + listPackBackLen(entryLen)
+	}
+}
+
+// listPackInt decodes little endian signed integer with 2, 3, 4 or 8 bytes
+func listPackInt(buf []byte) int64 {
+	var u uint64
+	for j := len(buf) - 1; j >= 0; j-- {
+		u = u<<8 | uint64(buf[j])
+	}
+	shift := 64 - uint(len(buf))*8
+	return int64(u<<shift) >> shift
+}
+
+// listPackBackLen returns bytes used by backlen of an entry
+func listPackBackLen(entryLen int) int {
+	switch {
+	case entryLen <= 127:
+		return 1
+	case entryLen < 16383:
+		return 2
+	case entryLen < 2097151:
+		return 3
+	case entryLen < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// parseIntSet returns all integers of intset as strings
+func parseIntSet(buf []byte) ([][]byte, error) {
+	// encoding(4) length(4) contents
+	if len(buf) < 8 {
+		return nil, errBadEncoding
+	}
+	width := int(binary.LittleEndian.Uint32(buf))
+	length := int(binary.LittleEndian.Uint32(buf[4:]))
+	if width != 2 && width != 4 && width != 8 || len(buf) < 8+width*length || length < 0 {
+		return nil, errBadEncoding
+	}
+	result := make([][]byte, 0, length)
+	for i := 0; i < length; i++ {
+		v := listPackInt(buf[8+i*width : 8+(i+1)*width])
+		result = append(result, []byte(strconv.FormatInt(v, 10)))
+	}
+	return result, nil
+}
+
+// parseZipMap returns fields and values of zipmap, which is used by hash before redis 2.6
+func parseZipMap(buf []byte) ([][]byte, error) {
+	if len(buf) < 2 {
+		return nil, errBadEncoding
+	}
+	var result [][]byte
+	i := 1 // 跳过 zmlen
+	readLen := func() (int, bool) {
+		if i >= len(buf) {
+			return 0, false
+		}
+		b := buf[i]
+		if b < 254 {
+			i++
+			return int(b), true
+		}
+		if b == 254 && i+5 <= len(buf) {
+			n := int(binary.LittleEndian.Uint32(buf[i+1:]))
+			i += 5
+			return n, true
+		}
+		return 0, false
+	}
+	for {
+		if i >= len(buf) {
+			return nil, errBadEncoding
+		}
+		if buf[i] == 0xff {
+			return result, nil
+		}
+		keyLen, ok := readLen()
+		if !ok || i+keyLen > len(buf) {
+			return nil, errBadEncoding
+		}
+		result = append(result, buf[i:i+keyLen])
+		i += keyLen
+		valLen, ok := readLen()
+		if !ok || i >= len(buf) {
+			return nil, errBadEncoding
+		}
+		free := int(buf[i])
+		i++
+		if i+valLen+free > len(buf) {
+			return nil, errBadEncoding
+		}
+		result = append(result, buf[i:i+valLen])
+		i += valLen + free
+	}
+}
@@ -0,0 +1,88 @@
+// Package rdb encodes and decodes snapshot files in redis RDB format
+package rdb
+
+import (
+	"errors"
+	"hash/crc64"
+)
+
+const (
+	magic = "REDIS"
+	// version written by Encoder, supported since redis 5.0
+	version = 9
+	// maxVersion is the highest version Decoder accepts, redis 7.4 writes version 12
+	maxVersion = 12
+)
+
+// value types
+const (
+	typeString          = 0
+	typeList            = 1
+	typeSet             = 2
+	typeZSet            = 3
+	typeHash            = 4
+	typeZSet2           = 5
+	typeModule          = 6
+	typeModule2         = 7
+	typeHashZipMap      = 9
+	typeListZipList     = 10
+	typeSetIntSet       = 11
+	typeZSetZipList     = 12
+	typeHashZipList     = 13
+	typeListQuickList   = 14
+	typeStreamListPacks = 15
+	typeHashListPack    = 16
+	typeZSetListPack    = 17
+	typeListQuickList2  = 18
+	typeSetListPack     = 20
+)
+
+// op codes
+const (
+	opCodeSlotInfo      = 244
+	opCodeFunction2     = 245
+	opCodeFunctionPreGA = 246
+	opCodeModuleAux     = 247
+	opCodeIdle          = 248
+	opCodeFreq          = 249
+	opCodeAux           = 250
+	opCodeResizeDB      = 251
+	opCodeExpireTimeMs  = 252
+	opCodeExpireTime    = 253
+	opCodeSelectDB      = 254
+	opCodeEOF           = 255
+)
+
+// length encoding, the highest 2 bits of first byte
+const (
+	len6Bit      = 0
+	len14Bit     = 1
+	len32or64Bit = 2
+	lenEncVal    = 3
+	len32Bit     = 0x80
+	len64Bit     = 0x81
+)
+
+// special string encodings when the highest 2 bits are lenEncVal
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// quicklist node container of typeListQuickList2
+const (
+	quickListNodePlain  = 1
+	quickListNodePacked = 2
+)
+
+// ErrBadFormat is returned when the file is not a valid rdb file
+var ErrBadFormat = errors.New("bad rdb format")
+
+// redis 使用 Jones 多项式的 crc64, 初值和结果都不取反
+var crcTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+func updateCRC(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"errors"
 	"net"
 	"redisgo/interface/redis"
 	"redisgo/lib/logger"
@@ -8,6 +9,7 @@ import (
 	"redisgo/redis/parser"
 	"redisgo/redis/reply"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,6 +21,8 @@ type Client struct {
 	waitingReqs chan *request
 	ticker      *time.Ticker
 	addr        string
+	// 建立连接后需要发送的认证命令, 重连后重新发送
+	handshakes [][][]byte
 
 	working *sync.WaitGroup
 }
@@ -53,6 +57,21 @@ func MakeClient(addr string) (*Client, error) {
 	}, nil
 }
 
+// Auth authenticates to server with password, the password will be reused when reconnecting
+func (client *Client) Auth(password string) error {
+	return client.Handshake([][]byte{[]byte("AUTH"), []byte(password)})
+}
+
+// Handshake sends an authentication command which must reply OK, the command will be resent when reconnecting
+func (client *Client) Handshake(args [][]byte) error {
+	r := client.Send(args)
+	if !reply.IsOKReply(r) {
+		return errors.New(strings.ToLower(string(args[0])) + " failed: " + strings.TrimSpace(string(r.ToBytes())))
+	}
+	client.handshakes = append(client.handshakes, args)
+	return nil
+}
+
 // Start starts asynchronous goroutines
 func (client *Client) Start() {
 	client.ticker = time.NewTicker(10 * time.Second)
@@ -123,6 +142,18 @@ func (client *Client) handleConnectionError(err error) error {
 	go func() {
 		_ = client.handleRead()
 	}()
+	for _, args := range client.handshakes {
+		// 在写协程中先于重试的请求发送, 回复按顺序被忽略
+		authReq := &request{
+			args:      args,
+			heartbeat: true,
+		}
+		_, err1 = client.conn.Write(reply.MakeMultiBulkReply(authReq.args).ToBytes())
+		if err1 != nil {
+			return err1
+		}
+		client.waitingReqs <- authReq
+	}
 	return nil
 }
 
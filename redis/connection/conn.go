@@ -15,6 +15,25 @@ type Connection struct {
 	mu sync.Mutex
 	// selected db
 	selectedDB int
+	// 配置了 requirepass 时, 通过 AUTH 后才能执行命令
+	authenticated bool
+	// 认证使用的 ACL 用户
+	userName string
+	// 是否是通过认证的集群节点
+	clusterPeer bool
+
+	// MULTI 之后的命令入队, 由 EXEC 一起执行
+	multiState bool
+	queue      [][][]byte
+	// WATCH 的key及其当时的版本号
+	watching map[string]uint32
+	// 入队时发现的错误, EXEC 时返回 EXECABORT
+	txErrors []error
+
+	// 订阅的频道、模式和分片频道, 只在处理该连接的协程中修改
+	subs  map[string]struct{}
+	psubs map[string]struct{}
+	ssubs map[string]struct{}
 }
 
 func NewConn(conn net.Conn) *Connection {
@@ -46,6 +65,36 @@ func (c *Connection) SelectDB(dbNum int) {
 	c.selectedDB = dbNum
 }
 
+// SetAuthenticated marks whether the connection has passed authentication
+func (c *Connection) SetAuthenticated(authenticated bool) {
+	c.authenticated = authenticated
+}
+
+// IsAuthenticated returns whether the connection has passed authentication
+func (c *Connection) IsAuthenticated() bool {
+	return c.authenticated
+}
+
+// SetUserName sets the ACL user of connection
+func (c *Connection) SetUserName(name string) {
+	c.userName = name
+}
+
+// GetUserName returns the ACL user of connection
+func (c *Connection) GetUserName() string {
+	return c.userName
+}
+
+// SetClusterPeer marks whether the connection comes from an authenticated cluster peer
+func (c *Connection) SetClusterPeer(peer bool) {
+	c.clusterPeer = peer
+}
+
+// IsClusterPeer returns whether the connection comes from an authenticated cluster peer
+func (c *Connection) IsClusterPeer() bool {
+	return c.clusterPeer
+}
+
 // 写的时候上锁
 func (c *Connection) Write(b []byte) error {
 	if len(b) == 0 {
@@ -61,3 +110,132 @@ func (c *Connection) Write(b []byte) error {
 	_, err := c.conn.Write(b)
 	return err
 }
+
+// InMultiState tells whether connection is in multi state
+func (c *Connection) InMultiState() bool {
+	return c.multiState
+}
+
+// SetMultiState sets connection multi state, leaving multi state clears queue and watching keys
+func (c *Connection) SetMultiState(state bool) {
+	if !state {
+		c.watching = nil
+		c.queue = nil
+		c.txErrors = nil
+	}
+	c.multiState = state
+}
+
+// GetQueuedCmdLine returns queued commands of current transaction
+func (c *Connection) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+// EnqueueCmd enqueues command of current transaction
+func (c *Connection) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+// ClearQueuedCmds clears queued commands of current transaction
+func (c *Connection) ClearQueuedCmds() {
+	c.queue = nil
+}
+
+// GetWatching returns watching keys and their versions
+func (c *Connection) GetWatching() map[string]uint32 {
+	if c.watching == nil {
+		c.watching = make(map[string]uint32)
+	}
+	return c.watching
+}
+
+// AddTxError stores syntax error within transaction
+func (c *Connection) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+// GetTxErrors returns syntax errors within transaction
+func (c *Connection) GetTxErrors() []error {
+	return c.txErrors
+}
+
+// Subscribe add current connection into subscribers of the given channel
+func (c *Connection) Subscribe(channel string) {
+	if c.subs == nil {
+		c.subs = make(map[string]struct{})
+	}
+	c.subs[channel] = struct{}{}
+}
+
+// UnSubscribe removes current connection from subscribers of the given channel
+func (c *Connection) UnSubscribe(channel string) {
+	delete(c.subs, channel)
+}
+
+// SubsCount returns the number of subscribing channels
+func (c *Connection) SubsCount() int {
+	return len(c.subs)
+}
+
+// GetChannels returns all subscribing channels
+func (c *Connection) GetChannels() []string {
+	channels := make([]string, 0, len(c.subs))
+	for channel := range c.subs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// PSubscribe add current connection into subscribers of the given pattern
+func (c *Connection) PSubscribe(pattern string) {
+	if c.psubs == nil {
+		c.psubs = make(map[string]struct{})
+	}
+	c.psubs[pattern] = struct{}{}
+}
+
+// PUnSubscribe removes current connection from subscribers of the given pattern
+func (c *Connection) PUnSubscribe(pattern string) {
+	delete(c.psubs, pattern)
+}
+
+// PSubsCount returns the number of subscribing patterns
+func (c *Connection) PSubsCount() int {
+	return len(c.psubs)
+}
+
+// GetPatterns returns all subscribing patterns
+func (c *Connection) GetPatterns() []string {
+	patterns := make([]string, 0, len(c.psubs))
+	for pattern := range c.psubs {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// SSubscribe add current connection into subscribers of the given shard channel
+func (c *Connection) SSubscribe(channel string) {
+	if c.ssubs == nil {
+		c.ssubs = make(map[string]struct{})
+	}
+	c.ssubs[channel] = struct{}{}
+}
+
+// SUnSubscribe removes current connection from subscribers of the given shard channel
+func (c *Connection) SUnSubscribe(channel string) {
+	delete(c.ssubs, channel)
+}
+
+// SSubsCount returns the number of subscribing shard channels
+func (c *Connection) SSubsCount() int {
+	return len(c.ssubs)
+}
+
+// GetShardChannels returns all subscribing shard channels
+func (c *Connection) GetShardChannels() []string {
+	channels := make([]string, 0, len(c.ssubs))
+	for channel := range c.ssubs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
@@ -31,7 +31,7 @@ type Handler struct {
 func MakeHandler() *Handler {
 	var db database.Database
 	// db = database2.NewEchoDatabase() // test
-	if config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
+	if config.Properties.ClusterEnabled || config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
 		db = cluster.MakeClusterDatabase() // 集群
 	} else {
 		db = database2.NewStandaloneDataBase() // 单机
@@ -86,6 +86,12 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 			logger.Error("require multi bulk protocol")
 			continue
 		}
+		if len(r.Args) > 0 && strings.ToLower(string(r.Args[0])) == "quit" {
+			_ = client.Write(reply.MakeOkReply().ToBytes())
+			h.closeClient(client)
+			logger.Info("connection closed:" + client.RemoteAddr().String())
+			return
+		}
 		result := h.db.Exec(client, r.Args)
 		if result != nil {
 			_ = client.Write(result.ToBytes())
@@ -24,6 +24,7 @@ type readState struct { //读入状态（小写）
 	msgType           byte
 	args              [][]byte
 	bulkLen           int64
+	readingBody       bool // 下一行是bulk string的内容而不是头部
 	// readingRepl       bool
 }
 
@@ -182,17 +183,17 @@ func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
 		if err != nil {
 			return nil, true, err
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' { // 倒数第二个不为'/r'则错误
+		if len(msg) < 2 || msg[len(msg)-2] != '\r' { // 倒数第二个不为'/r'则错误
 			return nil, false, errors.New("protocol error:" + string(msg))
 		}
 	} else {
 		msg = make([]byte, state.bulkLen + 2)    //
-		_, err2 := io.ReadFull(bufReader, msg) //将buffer中的消息都读到msg中
+		_, err = io.ReadFull(bufReader, msg) //将buffer中的消息都读到msg中
 		if err != nil {
-			return nil, true, err2
+			return nil, true, err
 		}
 
-		if len(msg) == 0 || 
+		if len(msg) < 2 || 
 			msg[len(msg)-2] != '\r' || 
 			msg[len(msg)-1] != '\n' {
 			return nil, false, errors.New("protocol error:" + string(msg))
@@ -235,9 +236,10 @@ func parseBulkHeader(msg []byte, state *readState) error {
 
 	if state.bulkLen == -1 { // null bulk
 		return nil
-	} else if state.bulkLen > 0 {
+	} else if state.bulkLen >= 0 {
 		state.msgType = msg[0]
 		state.readingMultiLine = true
+		state.readingBody = true
 		state.expectedArgsCount = 1 // 一个参数
 		state.args = make([][]byte, 0, 1)
 		return nil
@@ -277,14 +279,22 @@ func parseSingleLineReply(msg []byte) (redis.Reply, error) {
 func readBody(msg []byte, state *readState) error {
 	line := msg[0 : len(msg)-2]
 	var err error
-	if line[0] == '$' {
+	if state.readingBody {
+		state.args = append(state.args, line)
+		state.readingBody = false
+		return nil
+	}
+	if len(line) > 0 && line[0] == '$' {
 		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
 		if err != nil {
 			return errors.New("protocol error:" + string(msg))
 		}
-		if state.bulkLen <= 0 {
-			state.args = append(state.args, []byte{})
+		if state.bulkLen < 0 { // null bulk, 使用 nil 和空字符串区分
+			state.args = append(state.args, nil)
 			state.bulkLen = 0
+		} else {
+			// $0 的内容是紧随其后的空行
+			state.readingBody = true
 		}
 	} else {
 		state.args = append(state.args, line)
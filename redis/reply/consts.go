@@ -36,10 +36,10 @@ func MakeOkReply() *OKReply {
 type NullBulkReply struct {
 }
 
-var nullbytes = []byte("$-1\r\n")
+var nullBulkBytes = []byte("$-1\r\n")
 
 func (*NullBulkReply) ToBytes() []byte {
-	return nullbytes
+	return nullBulkBytes
 }
 
 
@@ -57,8 +57,36 @@ func (*EmptyMultiBulkReply) ToBytes() []byte {
 	return emptyMultiBulkbytes
 }
 
-func MakeEmptyMultiBulkReply() *NullBulkReply {
-	return &NullBulkReply{}
+func MakeEmptyMultiBulkReply() *EmptyMultiBulkReply {
+	return &EmptyMultiBulkReply{}
+}
+
+// NullMultiBulk
+type NullMultiBulkReply struct {
+}
+
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+func (*NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+func MakeNullMultiBulkReply() *NullMultiBulkReply {
+	return &NullMultiBulkReply{}
+}
+
+// QUEUED, MULTI 之后入队命令的回复
+type QueuedReply struct {
+}
+
+var queuedBytes = []byte("+QUEUED\r\n")
+
+func (*QueuedReply) ToBytes() []byte {
+	return queuedBytes
+}
+
+func MakeQueuedReply() *QueuedReply {
+	return &QueuedReply{}
 }
 
 // no reply
@@ -19,8 +19,8 @@ type BulkReply struct {
 }
 
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.Arg) == 0 {
-		return NullBulkReplyBytes
+	if r.Arg == nil {
+		return nullBulkBytes
 	}
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
 }
@@ -131,3 +131,29 @@ func MakeErrReply(status string) *StandardErrReply {
 		Status: status,
 	}
 }
+
+/* ---- Multi Raw Reply ---- */
+
+// MultiRawReply stores nested replies, for example the reply of HSCAN
+// 数组中的元素可以是任意类型的回复
+type MultiRawReply struct {
+	Replies []redis.Reply
+}
+
+// MakeMultiRawReply creates MultiRawReply
+func MakeMultiRawReply(replies []redis.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *MultiRawReply) ToBytes() []byte {
+	argLen := len(r.Replies)
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	for _, arg := range r.Replies {
+		buf.Write(arg.ToBytes())
+	}
+	return buf.Bytes()
+}